@@ -1,19 +1,31 @@
-package main
+package application
 
 import (
+	"context"
 	"encoding/json"
-	"encoding/xml"
-	"flag"
-	"io/ioutil"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	kitlog "github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/exporter-toolkit/web"
 	"github.com/prometheus/log"
+	"golang.org/x/sync/errgroup"
+
+	"hadoop_exporter/internal/common"
 )
 
 const (
@@ -21,24 +33,65 @@ const (
 	// yarn.resourcemanager.webapp.cross-origin.enabled = true 必须开启，否则任务指标无法采集
 )
 
+// 子命令的参数，由hadoop-exporter application之后的参数解析
+var flags = common.NewFlagSet("hadoop-exporter application")
+
 var (
-	listenAddress  = flag.String("web.listen-address", ":9077", "暴露指标的监听地址，默认9077.") //设置成ip:port的格式，似乎更容易进行更改
-	metricsPath    = flag.String("web.telemetry-path", "/metrics", "暴露指标的路由.")
-	clientConfFile = flag.String("yarn-site.path", "/etc/hadoop/conf/yarn-site.xml", "YARN的客户端配置路径，支持绝对路径和相对路径")
-	timeout        = flag.String("get.timeout-seconds", "5", "请求超时的时间")
+	listenAddress  = common.StringsVar(flags, "web.listen-address", ":9077", "暴露指标的监听地址，可重复指定以监听多个地址，默认9077.") //设置成ip:port的格式，似乎更容易进行更改
+	metricsPath    = flags.String("web.telemetry-path", "/metrics", "暴露指标的路由.")
+	systemdSocket  = flags.Bool("web.systemd-socket", false, "使用systemd socket activation提供的监听，而不是web.listen-address.")
+	webConfigFile  = flags.String("web.config.file", "", "开启TLS或basic auth的配置文件路径，为空时使用HTTP.")
+	clientConfFile = flags.String("yarn-site.path", "/etc/hadoop/conf/yarn-site.xml", "YARN的客户端配置路径，支持绝对路径和相对路径")
+	timeout        = flags.String("get.timeout-seconds", "5", "请求超时的时间")
+	pushGateway    = flags.String("push.gateway", "", "Pushgateway地址，设置后定时推送指标.")
+	pushInterval   = flags.Duration("push.interval", 15*time.Second, "推送到Pushgateway的间隔.")
+	// 任务数量较多时，逐个采集任务开销很大，可以只通过/ws/v1/cluster/appstatistics采集各状态的任务数
+	collectApps          = flags.Bool("collect.apps", true, "通过/ws/v1/cluster/apps采集每个任务的指标.")
+	collectAppStatistics = flags.Bool("collect.app-statistics", false, "通过/ws/v1/cluster/appstatistics采集各状态的任务数.")
+	appStatisticsTypes   = flags.String("app-statistics.types", "", "按任务类型统计，多个类型用逗号分隔，为空时不区分类型.")
+	scrapeConcurrency    = flags.Int("scrape.concurrency", 4, "一次采集中并发请求的接口数，小于等于0时不限制.")
+	collectFairShare     = flags.Bool("collect.fair-share", false, "通过/ws/v1/cluster/scheduler采集FairScheduler各队列的fair share和资源需求，CapacityScheduler时不输出.")
+	// 指标名前缀，设置后指标名为<namespace>_application_XXX
+	metricNamespace = flags.String("metric.namespace", "", "指标名前缀，为空时保持原有指标名.")
+	// 只采集一次，用于调试和在CI中校验配置
+	oneshotMode = flags.Bool("oneshot", false, "采集一次指标并以文本格式输出到标准输出后退出，后端不可达时返回非0.")
+	// 访问后端的代理，为空时使用环境变量中的代理设置
+	httpProxy = flags.String("http.proxy", "", "请求后端时使用的代理地址，为空时使用HTTP_PROXY、HTTPS_PROXY和NO_PROXY环境变量.")
+	// 通过Knox等网关访问时REST接口不在/ws/v1下
+	yarnWSPrefix = flags.String("yarn.ws-prefix", "/ws/v1", "REST接口的路径前缀，通过Knox等网关访问时改为网关中的路径，例如/gateway/default/resourcemanager/v1.")
+	// 后端很慢时避免整个/metrics阻塞到Prometheus超时
+	collectTimeout = flags.Duration("collect.timeout", 0, "单次采集的超时时间，超时后只返回已采集到的指标，为0时不限制.")
+	// 在exporter端按规则保留或丢弃指标，用于控制高基数指标
+	relabelConfigFile = flags.String("metric.relabel-config", "", "指标过滤规则文件路径，按指标名和标签值保留或丢弃指标，为空时不过滤.")
+	// 任务名和用户是任意字符串，自动生成的任务名会让指标数量不断膨胀
+	appsNameRegexStrip = flags.String("apps.name-regex-strip", "", "任务名中匹配该正则的部分替换为*，例如去掉Spark任务名中的UUID，为空时保持原任务名.")
+	appsDropLabels     = flags.String("apps.drop-labels", "", "任务指标中不输出的标签，多个标签用逗号分隔，可选amContainer、applicationType、name、user.")
+	appsTypes          = flags.String("apps.types", "", "只采集这些类型的任务，多个类型用逗号分隔，例如MAPREDUCE,SPARK，为空时采集所有类型.")
+	// 容器中主机名可能解析到其他网卡的IP或无法解析
+	localIPAddr = flags.String("local.ip", "", "本机IP，原样用于serverip标签和判断本机是否为active节点，为空时通过解析主机名得到.")
+	// 毫秒时间戳在面板中经常被误当作秒，开启后额外输出距离采集时的秒数
+	timeAsAge = flags.Bool("time.as-age", false, "为StartTime等毫秒时间戳指标额外输出距离采集时的秒数*_age_seconds，原指标保持不变.")
+	// 多个Prometheus同时采集时每次采集都会请求后端，限制并发避免放大后端的压力
+	maxRequests = flags.Int("web.max-requests", 4, "同时处理的采集请求数上限，超过时返回503，为0时不限制.")
+	// Kubernetes中configmap可能晚于容器挂载，等待配置文件而不是直接退出
+	configWait = flags.Duration("config.wait", 0, "配置文件不存在或无法解析时重试的最长时间，等待期间/ready返回503，为0时不等待.")
+	// 只能通过代理或负载均衡访问RM的REST接口时指定，默认使用yarn-site.xml中active RM的webapp地址
+	restURL = flags.String("rest.url", "", "ResourceManager REST接口的基础地址，例如http://yarn-lb:8088，设置后不再根据yarn-site.xml查找active的RM.")
 )
 
-//读取配置，从客户端配置中读取需要的信息
-type XMLConf struct {
-	XMLName   xml.Name    `xml:"configuration"`
-	NameValue []NameValue `xml:"property"`
-}
+// 请求后端使用的Transport，在main中根据http.proxy设置
+var transport http.RoundTripper = http.DefaultTransport
 
-type NameValue struct {
-	Name  string `xml:"name"`
-	Value string `xml:"value"`
-	Final string `xml:"final"`
-}
+// 任务指标的标签和采集的任务类型，在main中根据apps.name-regex-strip、apps.drop-labels和apps.types设置
+var (
+	appLabels        = []string{"applicationID", "amContainer", "applicationType", "name", "user"}
+	appNameStrip     *regexp.Regexp
+	droppedAppLabels = map[string]bool{}
+	appTypes         = map[string]bool{} // 类型统一为大写，为空时不过滤
+)
+
+// 暴露和推送指标使用的Gatherer，在main中根据metric.relabel-config设置
+var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
 
 type YARNConf struct {
 	activeServerIP      string //ResourceManger IP
@@ -52,6 +105,8 @@ type YARNConf struct {
 type Exporter struct {
 	url string
 	c   YARNConf
+	// RM主备切换后url和c.activeServerIP会在采集中更新，并发的采集接口通过rmURL读取
+	urlMutex sync.RWMutex
 	// 任务监控指标
 	applicationState *prometheus.Desc
 	startedTime      *prometheus.Desc // 任务开始时间
@@ -67,178 +122,266 @@ type Exporter struct {
 	runningContainers      *prometheus.Desc // 正在运行的容器
 	queueUsagePercentage   *prometheus.Desc // 使用资源占队列的百分比
 	clusterUsagePercentage *prometheus.Desc // 使用资源占集群的百分比
+	ageSeconds             *prometheus.Desc // 任务已运行的秒数，由startedTime计算
+	// 毫秒时间戳距离采集时的秒数，需要开启time.as-age
+	startedTimeAge  *prometheus.Desc
+	finishedTimeAge *prometheus.Desc
+	// 任务结果计数，按appID去重，只统计新观察到结束的任务
+	mutex          sync.Mutex
+	seenApps       map[string]bool // 为nil时表示首次采集，只记录不计数
+	completedTotal prometheus.Counter
+	failedTotal    prometheus.Counter
+	killedTotal    prometheus.Counter
+	// 本次采集是否有接口请求失败，用于oneshot模式判断后端是否可达
+	scrapeFailed atomic.Bool
+	// 本次返回的任务数，没有任务时为0 "/ws/v1/cluster/apps"
+	appCount *prometheus.Desc
+	// 各状态的任务数 "/ws/v1/cluster/appstatistics"
+	appsByState *prometheus.Desc
+	// FairScheduler各队列的内存，用量和需求远高于fair share的队列资源不足 "/ws/v1/cluster/scheduler"
+	queueFairShareMemoryMB       *prometheus.Desc // 当前的fair share，只在有任务的队列间分配
+	queueSteadyFairShareMemoryMB *prometheus.Desc // 按权重计算的fair share，与队列是否有任务无关
+	queueDemandMemoryMB          *prometheus.Desc // 队列中任务需要的内存，包括已使用的
+	queueUsedMemoryMB            *prometheus.Desc
+	// 采集是否超时
+	scrapeTimeout *prometheus.Desc
+	// 配置的yarn.http.policy与实际协议不一致时为1，得到明确结果前每次采集都检测，之后不再请求
+	schemeMismatch   *prometheus.Desc
+	schemeMutex      sync.Mutex
+	schemeChecked    bool
+	schemeMismatched bool
 }
 
-//用于搜索配置值，支持任意返回值类型
-func SearchConf(name string, x *XMLConf) string {
-	for _, v := range x.NameValue {
-		//匹配配置项
-		if strings.Contains(v.Name, name) {
-			return v.Value
-		}
-	}
-	return ""
-}
-
-//读取XML配置文件，返回一个XMLConf结构体
-func ReadXml(path string) *XMLConf {
-	xmlFile, err := os.Open(path)
-	if err != nil {
-		log.Error("Error opening file: %s", path)
-		os.Exit(1)
-	}
-	defer xmlFile.Close()
-	var x XMLConf
-	data, err := ioutil.ReadAll(xmlFile)
-	if err != nil {
-		log.Error("Error reading file: %s", path)
-		os.Exit(1)
-	}
-	err = xml.Unmarshal(data, &x)
-	if err != nil {
-		log.Error("Error unmarshal xml.")
-		os.Exit(1)
-	}
-	return &x
-}
-
-// http请求，设置头并转json
-func HTTPToJSON(url string) (map[string]interface{}, error) {
+// http请求，设置头并转json，ctx取消后请求随之返回
+func HTTPToJSON(ctx context.Context, url string) (map[string]interface{}, error) {
 	t, err := strconv.Atoi(*timeout)
 	client := http.Client{
-		Timeout: time.Duration(t * int(time.Second)),
+		Transport: transport,
+		Timeout:   time.Duration(t * int(time.Second)),
 	}
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Transfer-Encoding", "chunked")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept-Encoding", "gzip")
 	res, err := client.Do(req) // 建立连接
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 	defer res.Body.Close()
-	data, err := ioutil.ReadAll(res.Body)
-	var f interface{}
-	err = json.Unmarshal(data, &f)
+	// 部分代理或登录页会返回HTML，直接解析会得到难以理解的错误
+	if ct := res.Header.Get("Content-Type"); !strings.Contains(ct, "json") {
+		return nil, fmt.Errorf("unexpected Content-Type %q from %s, expected application/json", ct, url)
+	}
+	data, err := common.ReadBody(res)
 	if err != nil {
-		log.Error(err)
+		return nil, err
+	}
+	var m map[string]interface{}
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, err
 	}
-	m := f.(map[string]interface{})
 	return m, nil
 }
 
-//生成采集器使用的配置项
-func CreateYARNConf(e *XMLConf) *YARNConf {
+// 生成采集器使用的配置项
+func CreateYARNConf(e *common.XMLConf) *YARNConf {
 	c := YARNConf{}
-	h, err := os.Hostname()
-	if err != nil {
-		panic(err)
-	}
-	t, err := net.ResolveIPAddr("ip", h)
-	if err != nil {
-		panic(err)
-	}
-	c.activeServerIP = t.IP.String()
+	c.activeServerIP = common.LocalIP(*localIPAddr)
 	// 默认关闭https
 	c.HttpsOpen = httpsmode
-	for _, id := range strings.Split(SearchConf("yarn.resourcemanager.ha.rm-ids", e), ",") {
-		r := "yarn.resourcemanager.hostname." + id
-		h := SearchConf(r, e)
-		t, _ := net.ResolveIPAddr("ip", h)
+	ids := common.SplitIDs(common.SearchConf("yarn.resourcemanager.ha.rm-ids", e))
+	// 非HA时没有yarn.resourcemanager.ha.rm-ids，配置项不带rm-id后缀
+	if len(ids) == 0 {
+		ids = []string{""}
+	}
+	for _, id := range ids {
+		r := common.HAKey("yarn.resourcemanager.hostname", id)
+		h := common.SearchConf(r, e)
+		t, err := net.ResolveIPAddr("ip", h)
+		if h == "" || err != nil {
+			log.Warnf("Cannot resolve %s %q: %v", r, h, err)
+			continue
+		}
 		c.ResourmanagerIPList = append(c.ResourmanagerIPList, t.IP.String()) // 添加到切片中，存储RM的清单
 	}
-	c.activeRMID = strings.Split(SearchConf("yarn.resourcemanager.ha.rm-ids", e), ",")[0]
+	c.activeRMID = ids[0]
 	// 判断是否开启HTTPS，并获取端口
-	if v := SearchConf("yarn.http.policy", e); v == "HTTPS_ONLY" {
+	if v := common.SearchConf("yarn.http.policy", e); v == "HTTPS_ONLY" {
 		c.HttpsOpen = true
-		c.HttpsPort = strings.Split(SearchConf("yarn.resourcemanager.webapp.https.address."+c.activeRMID, e), ":")[1]
+		c.HttpsPort = common.ConfPort(common.HAKey("yarn.resourcemanager.webapp.https.address", c.activeRMID), e, "8090")
 	} else {
-		c.HttpPort = strings.Split(SearchConf("yarn.resourcemanager.webapp.address."+c.activeRMID, e), ":")[1]
+		c.HttpPort = common.ConfPort(common.HAKey("yarn.resourcemanager.webapp.address", c.activeRMID), e, "8088")
 	}
+	applyEnvConf(&c)
 	return &c
 }
 
-func NewExporter(url string, c *YARNConf) *Exporter {
+func NewExporter(url string, c *YARNConf, namespace string) *Exporter {
 	return &Exporter{
 		url: url,
 		c:   *c,
 		applicationState: prometheus.NewDesc(
-			"application_applicationState",
+			prometheus.BuildFQName(namespace, "", "application_applicationState"),
 			"The application state 0,1,2,3",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
 			prometheus.Labels{},
 		),
 		startedTime: prometheus.NewDesc(
-			"application_startedTime",
+			prometheus.BuildFQName(namespace, "", "application_startedTime"),
 			"The application's  start time",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
 			prometheus.Labels{},
 		),
 		finishedTime: prometheus.NewDesc(
-			"application_finishedTime",
+			prometheus.BuildFQName(namespace, "", "application_finishedTime"),
 			"The application's  finish time",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
 			prometheus.Labels{},
 		),
 		elapsedTime: prometheus.NewDesc(
-			"application_elapsedTime",
+			prometheus.BuildFQName(namespace, "", "application_elapsedTime"),
 			"The application's  elapsed time",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
 			prometheus.Labels{},
 		),
 		memorySeconds: prometheus.NewDesc(
-			"application_memorySeconds",
+			prometheus.BuildFQName(namespace, "", "application_memorySeconds"),
 			"The application's memory seconds",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
 			prometheus.Labels{},
 		),
 		vcoreSeconds: prometheus.NewDesc(
-			"application_vcoreSeconds",
+			prometheus.BuildFQName(namespace, "", "application_vcoreSeconds"),
 			"The application's vcore seconds",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
 			prometheus.Labels{},
 		),
 		// Running applications specific
 		allocatedMB: prometheus.NewDesc(
-			"application_allocatedMB",
+			prometheus.BuildFQName(namespace, "", "application_allocatedMB"),
 			"The application's allocated memory MB",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
 			prometheus.Labels{},
 		),
 		allocatedVCores: prometheus.NewDesc(
-			"application_allocatedVCores",
+			prometheus.BuildFQName(namespace, "", "application_allocatedVCores"),
 			"The application's allocated vcore",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
 			prometheus.Labels{},
 		),
 		reservedMB: prometheus.NewDesc(
-			"application_reservedMB",
+			prometheus.BuildFQName(namespace, "", "application_reservedMB"),
 			"The application's reserved vcore",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
 			prometheus.Labels{},
 		),
 		reservedVCores: prometheus.NewDesc(
-			"application_reservedVCores",
+			prometheus.BuildFQName(namespace, "", "application_reservedVCores"),
 			"The application's reserved vcore",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
 			prometheus.Labels{},
 		),
 		runningContainers: prometheus.NewDesc(
-			"application_runningContainers",
+			prometheus.BuildFQName(namespace, "", "application_runningContainers"),
 			"The application's running containers",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
 			prometheus.Labels{},
 		),
 		queueUsagePercentage: prometheus.NewDesc(
-			"application_queueUsagePercentage",
+			prometheus.BuildFQName(namespace, "", "application_queueUsagePercentage"),
 			"The application's usage of queue",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
 			prometheus.Labels{},
 		),
 		clusterUsagePercentage: prometheus.NewDesc(
-			"application_clusterUsagePercentage",
+			prometheus.BuildFQName(namespace, "", "application_clusterUsagePercentage"),
 			"The application's usage of cluster",
-			[]string{"applicationID", "amContainer", "applicationType", "name", "user"},
+			appLabelNames(),
+			prometheus.Labels{},
+		),
+		ageSeconds: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "application_age_seconds"),
+			"The seconds since the running application started",
+			appLabelNames(),
+			prometheus.Labels{},
+		),
+		startedTimeAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "application_startedTime_age_seconds"),
+			"The seconds since the application started",
+			appLabelNames(),
+			prometheus.Labels{},
+		),
+		finishedTimeAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "application_finishedTime_age_seconds"),
+			"The seconds since the application finished",
+			appLabelNames(),
+			prometheus.Labels{},
+		),
+		completedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "application_completed_total",
+			Help:      "The number of applications observed to finish successfully",
+		}),
+		failedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "application_failed_total",
+			Help:      "The number of applications observed to fail",
+		}),
+		killedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "application_killed_total",
+			Help:      "The number of applications observed to be killed",
+		}),
+		appCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "application_listed"),
+			"The number of applications returned by /ws/v1/cluster/apps",
+			nil,
+			prometheus.Labels{},
+		),
+		appsByState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "yarn_apps_by_state"),
+			"The number of applications by state and type",
+			[]string{"state", "applicationType"},
+			prometheus.Labels{},
+		),
+		queueFairShareMemoryMB: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "application_queueFairShareMemoryMB"),
+			"The instantaneous fair share memory of the queue in MB",
+			[]string{"queue"},
+			prometheus.Labels{},
+		),
+		queueSteadyFairShareMemoryMB: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "application_queueSteadyFairShareMemoryMB"),
+			"The steady fair share memory of the queue in MB",
+			[]string{"queue"},
+			prometheus.Labels{},
+		),
+		queueDemandMemoryMB: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "application_queueDemandMemoryMB"),
+			"The memory demand of the queue in MB",
+			[]string{"queue"},
+			prometheus.Labels{},
+		),
+		queueUsedMemoryMB: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "application_queueUsedMemoryMB"),
+			"The memory used by the queue in MB",
+			[]string{"queue"},
+			prometheus.Labels{},
+		),
+		scrapeTimeout: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_scrape_timeout"),
+			"Whether the last scrape exceeded collect.timeout and returned partial metrics, 1 timeout, 0 normal",
+			nil,
+			prometheus.Labels{},
+		),
+		schemeMismatch: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_scheme_mismatch"),
+			"Whether the configured yarn.http.policy does not match the scheme the server actually uses, 1 mismatch, 0 match",
+			nil,
 			prometheus.Labels{},
 		),
 	}
@@ -248,6 +391,8 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.applicationState
 	ch <- e.startedTime
 	ch <- e.finishedTime
+	ch <- e.startedTimeAge
+	ch <- e.finishedTimeAge
 	ch <- e.elapsedTime
 	ch <- e.memorySeconds
 	ch <- e.vcoreSeconds
@@ -258,79 +403,252 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- e.runningContainers
 	ch <- e.queueUsagePercentage
 	ch <- e.clusterUsagePercentage
+	ch <- e.ageSeconds
+	e.completedTotal.Describe(ch)
+	e.failedTotal.Describe(ch)
+	e.killedTotal.Describe(ch)
+	ch <- e.appCount
+	ch <- e.appsByState
+	ch <- e.queueFairShareMemoryMB
+	ch <- e.queueSteadyFairShareMemoryMB
+	ch <- e.queueDemandMemoryMB
+	ch <- e.queueUsedMemoryMB
+	ch <- e.scrapeTimeout
+	ch <- e.schemeMismatch
+}
+
+// 通过appstatistics接口采集各状态的任务数，YARN每次只支持查询一个applicationType，appType为空时不区分类型
+func (e *Exporter) collectAppStatistics(ctx context.Context, appType string, ch chan<- prometheus.Metric) {
+	u := e.rmURL() + *yarnWSPrefix + "/cluster/appstatistics"
+	if appType != "" {
+		u += "?applicationTypes=" + url.QueryEscape(appType)
+	}
+	v, err := HTTPToJSON(ctx, u)
+	if err != nil {
+		log.Error(err)
+		e.scrapeFailed.Store(true)
+		return
+	}
+	appStatInfo, _ := v["appStatInfo"].(map[string]interface{})
+	statItems, _ := appStatInfo["statItem"].([]interface{})
+	for _, item := range statItems {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		count, ok := itemMap["count"].(float64)
+		if !ok {
+			continue
+		}
+		state, _ := itemMap["state"].(string)
+		itemType, _ := itemMap["type"].(string)
+		ch <- prometheus.MustNewConstMetric(e.appsByState, prometheus.GaugeValue, count, state, itemType)
+	}
+}
+
+// 通过scheduler接口采集FairScheduler各队列的fair share，其他调度器没有这些字段，直接跳过
+func (e *Exporter) collectFairShareQueues(ctx context.Context, ch chan<- prometheus.Metric) {
+	v, err := HTTPToJSON(ctx, e.rmURL()+*yarnWSPrefix+"/cluster/scheduler")
+	if err != nil {
+		log.Error(err)
+		e.scrapeFailed.Store(true)
+		return
+	}
+	scheduler, _ := v["scheduler"].(map[string]interface{})
+	info, _ := scheduler["schedulerInfo"].(map[string]interface{})
+	if info["type"] != "fairScheduler" {
+		return
+	}
+	if root, ok := info["rootQueue"].(map[string]interface{}); ok {
+		e.collectFairShareQueue(root, ch)
+	}
+}
+
+// 队列名为完整路径，例如root.default，子队列在不同版本中可能是数组，也可能是{"queue": [...]}
+func (e *Exporter) collectFairShareQueue(q map[string]interface{}, ch chan<- prometheus.Metric) {
+	name, _ := q["queueName"].(string)
+	for key, desc := range map[string]*prometheus.Desc{
+		"fairResources":       e.queueFairShareMemoryMB,
+		"steadyFairResources": e.queueSteadyFairShareMemoryMB,
+		"demandResources":     e.queueDemandMemoryMB,
+		"usedResources":       e.queueUsedMemoryMB,
+	} {
+		r, _ := q[key].(map[string]interface{})
+		if memory, ok := r["memory"].(float64); ok {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, memory, name)
+		}
+	}
+	children := q["childQueues"]
+	if m, ok := children.(map[string]interface{}); ok {
+		children = m["queue"]
+	}
+	list, _ := children.([]interface{})
+	for _, child := range list {
+		if m, ok := child.(map[string]interface{}); ok {
+			e.collectFairShareQueue(m, ch)
+		}
+	}
+}
+
+// 检测配置的协议与实际是否一致，后端不可达时无法判断，下次采集再检测
+func (e *Exporter) collectSchemeMismatch(ctx context.Context, client *http.Client, ch chan<- prometheus.Metric) {
+	e.schemeMutex.Lock()
+	defer e.schemeMutex.Unlock()
+	if !e.schemeChecked {
+		// 不跟随重定向，直接检查Location的协议，跟随后https的证书错误会掩盖重定向
+		probe := *client
+		probe.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		target := e.rmURL() + *yarnWSPrefix + "/cluster/info"
+		resp, err := common.Get(ctx, &probe, target)
+		if err == nil {
+			resp.Body.Close()
+		}
+		e.schemeMismatched = common.SchemeMismatch(target, resp, err)
+		e.schemeChecked = err == nil || e.schemeMismatched
+		if e.schemeMismatched {
+			log.Errorf("Scheme of %s does not match the server, check yarn.http.policy", target)
+		}
+	}
+	v := 0.0
+	if e.schemeMismatched {
+		v = 1
+	}
+	ch <- prometheus.MustNewConstMetric(e.schemeMismatch, prometheus.GaugeValue, v)
 }
 
+// 采集器方法，超过collect.timeout时取消未完成的请求，只输出已读取到的指标，并将hadoop_exporter_scrape_timeout置为1
+// 请求在超时后立即返回，采集在本次Collect内结束，不会与下一次采集重叠
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	common.CollectWithTimeout(*collectTimeout, e.scrapeTimeout, ch, func(ctx context.Context) {
+		e.collect(ctx, ch)
+	})
+}
+
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
 	// 实现Collect方法
-	v, err := HTTPToJSON(e.url + "/ws/v1/cluster/apps?deSelects=resourceRequests&state=RUNNING,FINISHED,FAILED,KILLED")
-	if err != nil {
-		// 如果返回了错误，就要切换RM
-		for _, ip := range e.c.ResourmanagerIPList {
-			if e.c.activeServerIP != ip {
-				e.c.activeServerIP = ip
-				break
+	// 上次采集有接口失败时先确认active RM，RM正常时每次采集不额外请求
+	if e.scrapeFailed.Swap(false) {
+		e.findActiveRM(ctx)
+	}
+	e.collectSchemeMismatch(ctx, &http.Client{Transport: transport, Timeout: 5 * time.Second}, ch)
+	// 各接口互不依赖，并发请求，单个接口失败不影响其他接口的指标
+	g := new(errgroup.Group)
+	if *scrapeConcurrency > 0 {
+		g.SetLimit(*scrapeConcurrency)
+	}
+	if *collectAppStatistics {
+		// 重复的类型会输出相同的指标，导致采集失败，YARN按类型过滤时不区分大小写
+		var types []string
+		seen := make(map[string]bool)
+		for _, appType := range common.SplitIDs(*appStatisticsTypes) {
+			if !seen[strings.ToUpper(appType)] {
+				seen[strings.ToUpper(appType)] = true
+				types = append(types, appType)
 			}
 		}
-		v, err = HTTPToJSON(e.url + "/ws/v1/cluster/apps?deSelects=resourceRequests&state=RUNNING,FINISHED,FAILED,KILLED")
-		if err != nil {
-			log.Error(err)
-			panic(1)
+		if len(types) == 0 {
+			types = []string{""}
+		}
+		for _, appType := range types {
+			appType := appType
+			g.Go(func() error {
+				e.collectAppStatistics(ctx, appType, ch)
+				return nil
+			})
 		}
 	}
-	var t = v["apps"].(map[string]interface{})["app"].([]interface{})
+	if *collectFairShare {
+		g.Go(func() error {
+			e.collectFairShareQueues(ctx, ch)
+			return nil
+		})
+	}
+	if *collectApps {
+		g.Go(func() error {
+			e.collectApps(ctx, ch)
+			return nil
+		})
+	}
+	g.Wait()
+}
+
+// 通过/ws/v1/cluster/apps采集每个任务的指标
+func (e *Exporter) collectApps(ctx context.Context, ch chan<- prometheus.Metric) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	query := "/cluster/apps?deSelects=resourceRequests&state=RUNNING,FINISHED,FAILED,KILLED"
+	if *appsTypes != "" {
+		query += "&applicationTypes=" + url.QueryEscape(strings.Join(common.SplitIDs(*appsTypes), ","))
+	}
+	v, err := HTTPToJSON(ctx, e.rmURL()+*yarnWSPrefix+query)
+	// 如果返回了错误，可能是RM发生了主备切换，找到新的active RM后重试一次
+	if err != nil && e.findActiveRM(ctx) {
+		v, err = HTTPToJSON(ctx, e.rmURL()+*yarnWSPrefix+query)
+	}
+	if err != nil {
+		log.Error(err)
+		e.scrapeFailed.Store(true)
+		return
+	}
+	// 没有符合条件的任务时返回{"apps":null}，也可能没有app字段，都按0个任务处理
+	apps, _ := v["apps"].(map[string]interface{})
+	t, _ := apps["app"].([]interface{})
+	// 旧版本或经过网关时applicationTypes参数可能不生效，本地再过滤一次
+	if len(appTypes) > 0 {
+		filtered := t[:0]
+		for _, app := range t {
+			appDataMap, _ := app.(map[string]interface{})
+			appType, _ := appDataMap["applicationType"].(string)
+			if appTypes[strings.ToUpper(appType)] {
+				filtered = append(filtered, app)
+			}
+		}
+		t = filtered
+	}
+	ch <- prometheus.MustNewConstMetric(e.appCount, prometheus.GaugeValue, float64(len(t)))
+	// 本次返回的已结束任务，RM清理掉的任务不会再出现，因此用它替换seenApps，避免无限增长
+	finishedApps := make(map[string]bool)
 	for _, app := range t {
-		appDataMap := app.(map[string]interface{})
+		appDataMap, _ := app.(map[string]interface{})
 		appState := -1.0
-		appID := appDataMap["id"].(string)
-		amContainer := strings.Split(appDataMap["amContainerLogs"].(string), "/")[5]
-		appType := appDataMap["applicationType"].(string)
-		name := appDataMap["name"].(string)
-		user := appDataMap["user"].(string)
+		appID, _ := appDataMap["id"].(string)
+		// 启动AM之前就失败的任务没有amContainerLogs，此时container标签为空
+		amContainerLogs, _ := appDataMap["amContainerLogs"].(string)
+		appType, _ := appDataMap["applicationType"].(string)
+		name, _ := appDataMap["name"].(string)
+		user, _ := appDataMap["user"].(string)
+		if appNameStrip != nil {
+			name = appNameStrip.ReplaceAllString(name, "*")
+		}
+		labels := appLabelValues(appID, amContainerID(amContainerLogs), appType, name, user)
 		if appDataMap["state"] == "RUNNING" {
 			//此处，需要对RUNNING任务和其他任务进行区分
 			appState = 1
-			ch <- prometheus.MustNewConstMetric(
-				e.allocatedMB,
-				prometheus.GaugeValue,
-				appDataMap["allocatedMB"].(float64),
-				appID, amContainer, appType, name, user,
-			)
-			ch <- prometheus.MustNewConstMetric(
-				e.allocatedVCores,
-				prometheus.GaugeValue,
-				appDataMap["allocatedVCores"].(float64),
-				appID, amContainer, appType, name, user,
-			)
-			ch <- prometheus.MustNewConstMetric(
-				e.reservedMB,
-				prometheus.GaugeValue,
-				appDataMap["reservedMB"].(float64),
-				appID, amContainer, appType, name, user,
-			)
-			ch <- prometheus.MustNewConstMetric(
-				e.reservedVCores,
-				prometheus.GaugeValue,
-				appDataMap["reservedVCores"].(float64),
-				appID, amContainer, appType, name, user,
-			)
-			ch <- prometheus.MustNewConstMetric(
-				e.runningContainers,
-				prometheus.GaugeValue,
-				appDataMap["runningContainers"].(float64),
-				appID, amContainer, appType, name, user,
-			)
-			ch <- prometheus.MustNewConstMetric(
-				e.queueUsagePercentage,
-				prometheus.GaugeValue,
-				appDataMap["queueUsagePercentage"].(float64),
-				appID, amContainer, appType, name, user,
-			)
-			ch <- prometheus.MustNewConstMetric(
-				e.clusterUsagePercentage,
-				prometheus.GaugeValue,
-				appDataMap["clusterUsagePercentage"].(float64),
-				appID, amContainer, appType, name, user,
-			)
+			// FairScheduler不返回queueUsagePercentage和clusterUsagePercentage，缺失的字段不输出
+			for key, desc := range map[string]*prometheus.Desc{
+				"allocatedMB":            e.allocatedMB,
+				"allocatedVCores":        e.allocatedVCores,
+				"reservedMB":             e.reservedMB,
+				"reservedVCores":         e.reservedVCores,
+				"runningContainers":      e.runningContainers,
+				"queueUsagePercentage":   e.queueUsagePercentage,
+				"clusterUsagePercentage": e.clusterUsagePercentage,
+			} {
+				if v, ok := common.GetFloat(appDataMap, key); ok {
+					ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+				}
+			}
+			// startedTime是毫秒时间戳，换算成运行秒数方便面板展示
+			if startedTime, ok := common.GetFloat(appDataMap, "startedTime"); ok && startedTime > 0 {
+				ch <- prometheus.MustNewConstMetric(
+					e.ageSeconds,
+					prometheus.GaugeValue,
+					ageSeconds(startedTime),
+					labels...,
+				)
+			}
 		}
 		if appDataMap["finalStatus"] == "KILLED" {
 			appState = 3
@@ -341,58 +659,305 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 		if appDataMap["finalStatus"] == "FAILED" {
 			appState = 2
 		}
+		if appState == 0 || appState == 2 || appState == 3 {
+			finishedApps[appID] = true
+			if e.seenApps != nil && !e.seenApps[appID] {
+				switch appState {
+				case 0:
+					e.completedTotal.Inc()
+				case 2:
+					e.failedTotal.Inc()
+				case 3:
+					e.killedTotal.Inc()
+				}
+			}
+		}
 		// 其实我觉得用switch也行
 		ch <- prometheus.MustNewConstMetric(
 			e.applicationState,
 			prometheus.GaugeValue,
 			appState,
-			appID, amContainer, appType, name, user,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			e.startedTime,
-			prometheus.GaugeValue,
-			appDataMap["startedTime"].(float64),
-			appID, amContainer, appType, name, user,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			e.finishedTime,
-			prometheus.GaugeValue,
-			appDataMap["finishedTime"].(float64),
-			appID, amContainer, appType, name, user,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			e.elapsedTime,
-			prometheus.GaugeValue,
-			appDataMap["elapsedTime"].(float64),
-			appID, amContainer, appType, name, user,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			e.memorySeconds,
-			prometheus.GaugeValue,
-			appDataMap["memorySeconds"].(float64),
-			appID, amContainer, appType, name, user,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			e.vcoreSeconds,
-			prometheus.GaugeValue,
-			appDataMap["vcoreSeconds"].(float64),
-			appID, amContainer, appType, name, user,
+			labels...,
 		)
+		for key, desc := range map[string]*prometheus.Desc{
+			"startedTime":   e.startedTime,
+			"finishedTime":  e.finishedTime,
+			"elapsedTime":   e.elapsedTime,
+			"memorySeconds": e.memorySeconds,
+			"vcoreSeconds":  e.vcoreSeconds,
+		} {
+			if v, ok := common.GetFloat(appDataMap, key); ok {
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+			}
+		}
+		// 未结束的任务finishedTime为0
+		if *timeAsAge {
+			if v, ok := common.GetFloat(appDataMap, "startedTime"); ok && v > 0 {
+				ch <- prometheus.MustNewConstMetric(e.startedTimeAge, prometheus.GaugeValue, ageSeconds(v), labels...)
+			}
+			if v, ok := common.GetFloat(appDataMap, "finishedTime"); ok && v > 0 {
+				ch <- prometheus.MustNewConstMetric(e.finishedTimeAge, prometheus.GaugeValue, ageSeconds(v), labels...)
+			}
+		}
+	}
+	e.seenApps = finishedApps
+	e.completedTotal.Collect(ch)
+	e.failedTotal.Collect(ch)
+	e.killedTotal.Collect(ch)
+}
+
+// 从amContainerLogs中取出AM的container id，格式为http://<节点>/node/containerlogs/<container id>/<用户>，格式不符时返回空
+func amContainerID(logs string) string {
+	parts := strings.Split(logs, "/")
+	if len(parts) < 6 {
+		return ""
+	}
+	return parts[5]
+}
+
+// 毫秒时间戳距离本次采集的秒数
+func ageSeconds(epochMs float64) float64 {
+	return float64(time.Now().UnixNano())/1e9 - epochMs/1000
+}
+
+// 最近一次采集时后端是否可达
+func (e *Exporter) up() bool {
+	return !e.scrapeFailed.Load()
+}
+
+// 采集一次指标并以文本格式输出到标准输出，与服务模式使用同一个已注册的采集器
+func oneshot(exporter *Exporter) error {
+	mfs, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(os.Stdout, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	if !exporter.up() {
+		return fmt.Errorf("backend %s is unreachable", exporter.url)
+	}
+	return nil
+}
+
+// 配置文件读取成功并注册采集器后为true，用于/ready
+var ready atomic.Bool
+
+// 环境变量中的配置优先于XML配置
+func applyEnvConf(c *YARNConf) {
+	for env, v := range map[string]*string{
+		"HADOOP_EXPORTER_HTTP_PORT":  &c.HttpPort,
+		"HADOOP_EXPORTER_HTTPS_PORT": &c.HttpsPort,
+	} {
+		if s, ok := os.LookupEnv(env); ok {
+			*v = s
+		}
+	}
+	if s, ok := os.LookupEnv("HADOOP_EXPORTER_HTTPS"); ok {
+		c.HttpsOpen = s == "true"
+	}
+	if s, ok := os.LookupEnv("HADOOP_EXPORTER_RESOURCEMANAGER_IPS"); ok {
+		c.ResourmanagerIPList = common.SplitIDs(s)
+	}
+}
+
+// 任务指标的标签名，去掉apps.drop-labels中的标签，applicationID用于区分任务，不能去掉
+func appLabelNames() []string {
+	var names []string
+	for _, name := range appLabels {
+		if !droppedAppLabels[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// 与appLabelNames对应的标签值，参数顺序与appLabels相同
+func appLabelValues(values ...string) []string {
+	var kept []string
+	for i, value := range values {
+		if !droppedAppLabels[appLabels[i]] {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+// 根据RM的IP生成地址，协议和端口与配置相同
+func rmURLFor(c *YARNConf, ip string) string {
+	if c.HttpsOpen {
+		return "https://" + net.JoinHostPort(ip, c.HttpsPort)
+	}
+	return "http://" + net.JoinHostPort(ip, c.HttpPort)
+}
+
+// 当前采集使用的RM地址
+func (e *Exporter) rmURL() string {
+	e.urlMutex.RLock()
+	defer e.urlMutex.RUnlock()
+	return e.url
+}
+
+// 通过/ws/v1/cluster/info的haState找到active的RM，之后的采集都使用它，standby的RM也会返回cluster/info
+// 先检查当前使用的RM，找不到active的RM时保持原来的地址并返回false
+func (e *Exporter) findActiveRM(ctx context.Context) bool {
+	// 指定了rest.url时由代理或负载均衡负责找到active的RM
+	if *restURL != "" {
+		return false
+	}
+	e.urlMutex.RLock()
+	current := e.c.activeServerIP
+	e.urlMutex.RUnlock()
+	ips := []string{current}
+	for _, ip := range e.c.ResourmanagerIPList {
+		if ip != current {
+			ips = append(ips, ip)
+		}
 	}
+	for _, ip := range ips {
+		u := rmURLFor(&e.c, ip)
+		v, err := HTTPToJSON(ctx, u+*yarnWSPrefix+"/cluster/info")
+		if err != nil {
+			continue
+		}
+		info, _ := v["clusterInfo"].(map[string]interface{})
+		if info["haState"] != "ACTIVE" {
+			continue
+		}
+		e.urlMutex.Lock()
+		if e.url != u {
+			log.Infof("Active ResourceManager changed to %s", u)
+		}
+		e.url = u
+		e.c.activeServerIP = ip
+		e.urlMutex.Unlock()
+		return true
+	}
+	return false
 }
 
-func main() {
-	flag.Parse()
+// 根据配置生成采集器，配置了多个RM时先找到active的RM，避免每次采集都先请求standby
+func createExporter(conf *YARNConf) *Exporter {
+	if *restURL != "" {
+		return NewExporter(strings.TrimSuffix(*restURL, "/"), conf, *metricNamespace)
+	}
+	e := NewExporter(rmURLFor(conf, conf.activeServerIP), conf, *metricNamespace)
+	if len(conf.ResourmanagerIPList) > 1 {
+		e.findActiveRM(context.Background())
+	}
+	return e
+}
+
+// 收到SIGHUP时重新读取配置，配置变化可能导致标签变化，因此重新注册采集器
+func reloadOnSIGHUP(exporter *Exporter) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		xmlConf, err := common.ReadXmlOrEmpty(*clientConfFile)
+		if err != nil {
+			log.Errorf("Reload config failed: %s", err)
+			continue
+		}
+		newExporter := createExporter(CreateYARNConf(xmlConf))
+		prometheus.Unregister(exporter)
+		if err := prometheus.Register(newExporter); err != nil {
+			log.Errorf("Register reloaded exporter failed: %s", err)
+			prometheus.Register(exporter)
+			continue
+		}
+		exporter = newExporter
+		log.Info("Config reloaded")
+	}
+}
+
+// /metrics的处理器，同时处理的采集请求超过web.max-requests时返回503
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{MaxRequestsInFlight: *maxRequests}))
+}
+
+// 运行exporter，args为子命令之后的参数
+func Main(args []string) {
+	flags.Parse(args)
+	common.FlagsFromEnv(flags)
 	log.Info("Application Exporter make By Lijiadong(Meepod) (๑•̀ㅂ•́)و✧")
-	conf := CreateYARNConf(ReadXml(*clientConfFile))
-	resourcemanagerURL := "http://" + conf.activeServerIP + ":" + conf.HttpPort
-	if conf.HttpsOpen {
-		resourcemanagerURL = "https://" + conf.activeServerIP + ":" + conf.HttpsPort
+	// 等待配置期间先启动HTTP服务，/ready返回503，配置读取成功后才注册/metrics
+	serveErr := make(chan error, 1)
+	if *configWait > 0 && !*oneshotMode {
+		go func() {
+			serveErr <- serve()
+		}()
+	}
+	xmlConf, err := common.ReadXmlWithWait(*clientConfFile, *configWait)
+	if err != nil {
+		log.Fatal(err)
+	}
+	transport, err = common.NewTransport(*httpProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *relabelConfigFile != "" {
+		gatherer, err = common.RelabelGatherer(prometheus.DefaultGatherer, *relabelConfigFile)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	exporter := NewExporter(resourcemanagerURL, conf)
+	if *appsNameRegexStrip != "" {
+		appNameStrip, err = regexp.Compile(*appsNameRegexStrip)
+		if err != nil {
+			log.Fatalf("Invalid apps.name-regex-strip %s: %s", *appsNameRegexStrip, err)
+		}
+	}
+	for _, label := range strings.Split(*appsDropLabels, ",") {
+		switch label = strings.TrimSpace(label); label {
+		case "":
+		case "amContainer", "applicationType", "name", "user":
+			droppedAppLabels[label] = true
+		default:
+			log.Fatalf("Invalid apps.drop-labels %s: %s can not be dropped", *appsDropLabels, label)
+		}
+	}
+	// YARN按类型过滤时不区分大小写
+	for _, appType := range common.SplitIDs(*appsTypes) {
+		appTypes[strings.ToUpper(appType)] = true
+	}
+	conf := CreateYARNConf(xmlConf)
+	exporter := createExporter(conf)
 	prometheus.MustRegister(exporter)
-	log.Info("Starting Server: %s", *listenAddress)
-	http.Handle(*metricsPath, prometheus.Handler())
+	if *oneshotMode {
+		if err := oneshot(exporter); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	go reloadOnSIGHUP(exporter)
+	log.Infof("Starting Server: %s", listenAddress)
+	if *pushGateway != "" {
+		go common.PushMetrics(*pushGateway, *pushInterval, "application", conf.activeServerIP, gatherer)
+	}
+	http.Handle(*metricsPath, metricsHandler())
+	ready.Store(true)
+	if *configWait > 0 {
+		err = <-serveErr
+	} else {
+		err = serve()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// 启动HTTP服务，/metrics在配置读取成功后单独注册
+func serve() error {
+	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "config is not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK"))
+	})
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 		<head><title>Applications Exporter</title></head>
@@ -403,8 +968,11 @@ func main() {
 		</body>
 		</html>`))
 	})
-	err := http.ListenAndServe(*listenAddress, nil)
-	if err != nil {
-		log.Fatal(err)
+	server := &http.Server{}
+	flagConfig := &web.FlagConfig{
+		WebListenAddresses: &listenAddress.Values,
+		WebSystemdSocket:   systemdSocket,
+		WebConfigFile:      webConfigFile,
 	}
+	return web.ListenAndServe(server, flagConfig, kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr)))
 }
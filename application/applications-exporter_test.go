@@ -0,0 +1,271 @@
+package application
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"hadoop_exporter/internal/common"
+	"hadoop_exporter/internal/testutil"
+)
+
+// 按路径返回固定JSON的RM
+func newRMServer(t *testing.T, routes map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCollectAppsNull(t *testing.T) {
+	for name, body := range map[string]string{
+		"null":  `{"apps":null}`,
+		"noApp": `{"apps":{}}`,
+		"empty": `{}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := newRMServer(t, map[string]string{"/ws/v1/cluster/apps": body})
+			e := NewExporter(srv.URL, &YARNConf{activeServerIP: "127.0.0.1"}, "")
+			mfs := testutil.Gather(t, e)
+			if v, ok := testutil.MetricValue(mfs, "application_listed", nil); !ok || v != 0 {
+				t.Errorf("application_listed = %v, %v, want 0", v, ok)
+			}
+		})
+	}
+}
+
+// 开启apps.types时本地过滤不能因为格式异常的任务panic
+func TestCollectAppsTypeFilterMalformed(t *testing.T) {
+	appTypes["SPARK"] = true
+	defer delete(appTypes, "SPARK")
+	srv := newRMServer(t, map[string]string{"/ws/v1/cluster/apps": `{"apps":{"app":[1,"x",{"id":"a","applicationType":"MAPREDUCE"}]}}`})
+	e := NewExporter(srv.URL, &YARNConf{activeServerIP: "127.0.0.1"}, "")
+	mfs := testutil.Gather(t, e)
+	if v, ok := testutil.MetricValue(mfs, "application_listed", nil); !ok || v != 0 {
+		t.Errorf("application_listed = %v, %v, want 0", v, ok)
+	}
+}
+
+// FairScheduler上没有queueUsagePercentage和clusterUsagePercentage，启动AM前失败的任务没有amContainerLogs，缺失的指标和标签值跳过
+func TestCollectAppsMissingFields(t *testing.T) {
+	srv := newRMServer(t, map[string]string{"/ws/v1/cluster/apps": `{"apps":{"app":[
+		{"id":"application_1_0001","user":"u","name":"fair","applicationType":"SPARK","state":"RUNNING","finalStatus":"UNDEFINED",
+			"amContainerLogs":"http://nm1:8042/node/containerlogs/container_1_0001_01_000001/u","allocatedMB":"1024","allocatedVCores":1,
+			"startedTime":1700000000000,"finishedTime":0,"elapsedTime":1000},
+		{"id":"application_1_0002","user":"u","name":"noam","applicationType":"SPARK","state":"FAILED","finalStatus":"FAILED"}
+	]}}`})
+	e := NewExporter(srv.URL, &YARNConf{activeServerIP: "127.0.0.1"}, "")
+	mfs := testutil.Gather(t, e)
+	if v, ok := testutil.MetricValue(mfs, "application_allocatedMB", map[string]string{"amContainer": "container_1_0001_01_000001"}); !ok || v != 1024 {
+		t.Errorf("application_allocatedMB = %v (found %v), want 1024", v, ok)
+	}
+	for _, name := range []string{"application_queueUsagePercentage", "application_clusterUsagePercentage", "application_reservedMB"} {
+		if v, ok := testutil.MetricValue(mfs, name, nil); ok {
+			t.Errorf("%s = %v, want missing", name, v)
+		}
+	}
+	if v, ok := testutil.MetricValue(mfs, "application_applicationState", map[string]string{"applicationID": "application_1_0002", "amContainer": ""}); !ok || v != 2 {
+		t.Errorf("application_applicationState = %v (found %v), want 2", v, ok)
+	}
+	if v, ok := testutil.MetricValue(mfs, "application_startedTime", map[string]string{"applicationID": "application_1_0002"}); ok {
+		t.Errorf("application_startedTime = %v, want missing", v)
+	}
+}
+
+// 一个接口超时、一个接口返回500时，其他接口的指标照常输出，同时请求数不超过scrape.concurrency
+func TestCollectConcurrency(t *testing.T) {
+	testutil.SetFlag(t, flags, "scrape.concurrency", "2")
+	testutil.SetFlag(t, flags, "get.timeout-seconds", "1")
+	testutil.SetFlag(t, flags, "collect.app-statistics", "true")
+	testutil.SetFlag(t, flags, "app-statistics.types", "MAPREDUCE,SPARK,TEZ")
+	testutil.SetFlag(t, flags, "collect.fair-share", "true")
+	var inflight, maxInflight atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for m := maxInflight.Load(); n > m && !maxInflight.CompareAndSwap(m, n); m = maxInflight.Load() {
+		}
+		time.Sleep(50 * time.Millisecond)
+		var body string
+		switch r.URL.Path {
+		case "/ws/v1/cluster/appstatistics":
+			switch r.URL.Query().Get("applicationTypes") {
+			case "SPARK":
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			case "TEZ":
+				// 超过get.timeout-seconds，客户端断开后结束
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+				return
+			}
+			body = `{"appStatInfo":{"statItem":[{"state":"RUNNING","type":"MAPREDUCE","count":2}]}}`
+		case "/ws/v1/cluster/scheduler":
+			body = `{"scheduler":{"schedulerInfo":{"type":"fairScheduler","rootQueue":{"queueName":"root","fairResources":{"memory":1024}}}}}`
+		case "/ws/v1/cluster/apps":
+			body = appsJSON
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	e := NewExporter(srv.URL, &YARNConf{activeServerIP: "127.0.0.1"}, "")
+	mfs := testutil.Gather(t, e)
+	for _, c := range []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"yarn_apps_by_state", map[string]string{"state": "RUNNING", "applicationType": "MAPREDUCE"}, 2},
+		{"application_queueFairShareMemoryMB", map[string]string{"queue": "root"}, 1024},
+		{"application_listed", nil, 2},
+	} {
+		if got, ok := testutil.MetricValue(mfs, c.name, c.labels); !ok || got != c.want {
+			t.Errorf("%s%v = %v (found %v), want %v", c.name, c.labels, got, ok, c.want)
+		}
+	}
+	if n := maxInflight.Load(); n > 2 {
+		t.Errorf("%d requests in flight, want at most 2", n)
+	}
+}
+
+// 一个运行中和一个已完成的任务，包含collectApps读取的所有字段
+const appsJSON = `{"apps":{"app":[
+	{"id":"application_1_0001","amContainerLogs":"http://nm1:8042/node/containerlogs/container_1_0001_01_000001/hive","applicationType":"MAPREDUCE","name":"job","user":"hive",
+		"queue":"root.default","state":"RUNNING","finalStatus":"UNDEFINED","allocatedMB":1024,"allocatedVCores":1,"reservedMB":0,"reservedVCores":0,"runningContainers":1,
+		"queueUsagePercentage":10,"clusterUsagePercentage":5,"startedTime":1700000000000,"finishedTime":0,"elapsedTime":1000,"memorySeconds":100,"vcoreSeconds":10},
+	{"id":"application_1_0002","amContainerLogs":"http://nm1:8042/node/containerlogs/container_1_0002_01_000001/hive","applicationType":"SPARK","name":"etl","user":"hive",
+		"queue":"root.default","state":"FINISHED","finalStatus":"SUCCEEDED","allocatedMB":-1,"allocatedVCores":-1,"reservedMB":0,"reservedVCores":0,"runningContainers":-1,
+		"queueUsagePercentage":0,"clusterUsagePercentage":0,"startedTime":1700000000000,"finishedTime":1700000100000,"elapsedTime":100000,"memorySeconds":200,"vcoreSeconds":20}
+]}}`
+
+// 开启所有可选指标后，采集到的指标都要在Describe中声明
+func TestPedanticRegistry(t *testing.T) {
+	testutil.SetFlag(t, flags, "time.as-age", "true")
+	testutil.SetFlag(t, flags, "collect.app-statistics", "true")
+	testutil.SetFlag(t, flags, "collect.fair-share", "true")
+	srv := newRMServer(t, map[string]string{
+		"/ws/v1/cluster/apps":          appsJSON,
+		"/ws/v1/cluster/appstatistics": `{"appStatInfo":{"statItem":[{"state":"RUNNING","type":"*","count":1}]}}`,
+		"/ws/v1/cluster/scheduler": `{"scheduler":{"schedulerInfo":{"type":"fairScheduler","rootQueue":{"queueName":"root",
+			"fairResources":{"memory":1024},"steadyFairResources":{"memory":1024},"demandResources":{"memory":512},"usedResources":{"memory":512}}}}}`,
+	})
+	e := NewExporter(srv.URL, &YARNConf{activeServerIP: "127.0.0.1"}, "")
+	// 第二次采集时才会累加已结束任务的计数
+	testutil.Gather(t, e)
+	mfs := testutil.Gather(t, e)
+	if v, ok := testutil.MetricValue(mfs, "application_listed", nil); !ok || v != 2 {
+		t.Errorf("application_listed = %v, %v, want 2", v, ok)
+	}
+}
+
+// 设置--metric.namespace后所有指标都带前缀，包括hadoop_exporter_*等自身的指标
+func TestMetricNamespace(t *testing.T) {
+	srv := newRMServer(t, map[string]string{"/ws/v1/cluster/apps": appsJSON})
+	e := NewExporter(srv.URL, &YARNConf{activeServerIP: "127.0.0.1"}, "test")
+	testutil.CheckNamespace(t, testutil.Gather(t, e), "test")
+}
+
+// 同时处理的请求超过web.max-requests时直接返回503
+func TestMaxRequests(t *testing.T) {
+	testutil.SetFlag(t, flags, "web.max-requests", "1")
+	testutil.CheckMaxRequests(t, func(g prometheus.Gatherer) http.Handler {
+		old := gatherer
+		gatherer = g
+		t.Cleanup(func() { gatherer = old })
+		return metricsHandler()
+	})
+}
+
+// 解析yarn-site.xml格式的配置
+func parseConf(t *testing.T, props string) *common.XMLConf {
+	t.Helper()
+	var x common.XMLConf
+	if err := xml.Unmarshal([]byte("<configuration>"+props+"</configuration>"), &x); err != nil {
+		t.Fatal(err)
+	}
+	return &x
+}
+
+func TestCreateYARNConf(t *testing.T) {
+	testutil.SetFlag(t, flags, "local.ip", "127.0.0.1")
+	prop := func(name, value string) string {
+		return "<property><name>" + name + "</name><value>" + value + "</value></property>"
+	}
+	for _, c := range []struct {
+		name     string
+		props    string
+		rmID     string
+		ips      []string
+		httpPort string
+	}{
+		{
+			name:     "non-HA with default ports",
+			props:    prop("yarn.resourcemanager.hostname", "10.0.0.1"),
+			ips:      []string{"10.0.0.1"},
+			httpPort: "8088",
+		},
+		{
+			name:     "whitespace",
+			props:    prop(" yarn.resourcemanager.hostname\n", " 10.0.0.1 ") + prop("\n\tyarn.resourcemanager.webapp.address ", "\n\t10.0.0.1:8089\n"),
+			ips:      []string{"10.0.0.1"},
+			httpPort: "8089",
+		},
+		{
+			// rm10的配置项名以rm1的为前缀，并且排在前面
+			name: "three ResourceManagers",
+			props: prop("yarn.resourcemanager.ha.rm-ids", "rm1, rm2,rm10") +
+				prop("yarn.resourcemanager.hostname.rm10", "10.0.0.10") + prop("yarn.resourcemanager.webapp.address.rm10", "10.0.0.10:8080") +
+				prop("yarn.resourcemanager.hostname.rm1", "10.0.0.1") + prop("yarn.resourcemanager.webapp.address.rm1", "10.0.0.1:8081") +
+				prop("yarn.resourcemanager.hostname.rm2", "10.0.0.2"),
+			rmID:     "rm1",
+			ips:      []string{"10.0.0.1", "10.0.0.2", "10.0.0.10"},
+			httpPort: "8081",
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := CreateYARNConf(parseConf(t, c.props))
+			if got.activeRMID != c.rmID || got.HttpPort != c.httpPort || strings.Join(got.ResourmanagerIPList, ",") != strings.Join(c.ips, ",") {
+				t.Errorf("CreateYARNConf() = %+v, want rm id %q, ResourceManagers %v and http port %s", *got, c.rmID, c.ips, c.httpPort)
+			}
+		})
+	}
+}
+
+// IPv6地址需要加上方括号
+func TestRMURLForIPv6(t *testing.T) {
+	if got := rmURLFor(&YARNConf{HttpPort: "8088"}, "fe80::1"); got != "http://[fe80::1]:8088" {
+		t.Errorf("rmURLFor() = %q", got)
+	}
+	if got := rmURLFor(&YARNConf{HttpsOpen: true, HttpsPort: "8090"}, "fe80::1"); got != "https://[fe80::1]:8090" {
+		t.Errorf("rmURLFor() = %q", got)
+	}
+}
+
+// 超过collect.timeout时取消未完成的请求，采集在超时后返回，不会等到get.timeout-seconds
+func TestCollectTimeoutCancelsRequests(t *testing.T) {
+	testutil.SetFlag(t, flags, "collect.timeout", "300ms")
+	testutil.SetFlag(t, flags, "get.timeout-seconds", "30")
+	testutil.CheckCollectTimeout(t, func(url string) prometheus.Collector {
+		return NewExporter(url, &YARNConf{activeServerIP: "127.0.0.1"}, "")
+	})
+}
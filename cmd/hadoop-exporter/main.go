@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"hadoop_exporter/application"
+	"hadoop_exporter/datanode"
+	"hadoop_exporter/hbasemaster"
+	"hadoop_exporter/hbaseregionserver"
+	"hadoop_exporter/namenode"
+	"hadoop_exporter/resourcemanager"
+)
+
+// 各角色的子命令，参数和默认值与拆分前的exporter相同
+var roles = []struct {
+	name   string              // 子命令名
+	binary string              // 拆分前的程序名，通过同名软链接启动时不需要子命令
+	main   func(args []string) // 子命令之后的参数
+}{
+	{"namenode", "namenode-exporter", namenode.Main},
+	{"datanode", "datanode-exporter", datanode.Main},
+	{"resourcemanager", "resourcemanager-exporter", resourcemanager.Main},
+	{"application", "applications-exporter", application.Main},
+	{"hbaseregionserver", "hbaseregionserver-exporter", hbaseregionserver.Main},
+	{"hbasemaster", "hbasemaster-exporter", hbasemaster.Main},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <role> [flags]\n\nRoles:\n", filepath.Base(os.Args[0]))
+	for _, r := range roles {
+		fmt.Fprintf(os.Stderr, "  %s\n", r.name)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <role> -h' for the flags of each role.\n", filepath.Base(os.Args[0]))
+}
+
+func main() {
+	// 兼容原来的部署方式，程序名为namenode-exporter等时直接运行对应角色
+	for _, r := range roles {
+		if filepath.Base(os.Args[0]) == r.binary {
+			r.main(os.Args[1:])
+			return
+		}
+	}
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, r := range roles {
+		if os.Args[1] == r.name {
+			r.main(os.Args[2:])
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown role %q\n\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
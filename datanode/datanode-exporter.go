@@ -1,40 +1,96 @@
-package main
+package datanode
 
 import (
+	"context"
 	"encoding/json"
-	"encoding/xml"
-	"flag"
-	"io/ioutil"
+	"fmt"
+	"math"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	kitlog "github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/exporter-toolkit/web"
 	"github.com/prometheus/log"
+
+	"hadoop_exporter/internal/common"
 )
 
 const (
 	httpsmode = false
 )
 
+// 读写块耗时直方图的经典bucket，单位为毫秒，只支持文本格式的抓取方使用
+var blockOpBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// 每次采集最多记入读写块耗时直方图的次数
+const maxBlockOpObservations = 10000
+
+// 开启dfs.metrics.percentiles.intervals后DataNodeActivity中的分位数字段，例如FsyncNanos60s99thPercentileLatency
+var opPercentileRegexp = regexp.MustCompile(`^(ReadBlockOp|WriteBlockOp|FsyncNanos)(\d+)s(\d+)thPercentileLatency$`)
+
+// 子命令的参数，由hadoop-exporter datanode之后的参数解析
+var flags = common.NewFlagSet("hadoop-exporter datanode")
+
 var (
-	listenAddress  = flag.String("web.listen-address", ":9071", "暴露指标的监听地址，默认9071.") //设置成ip:port的格式，似乎更容易进行更改
-	metricsPath    = flag.String("web.telemetry-path", "/metrics", "暴露指标的路由.")
-	clientConfFile = flag.String("hdfs-site.path", "/etc/hadoop/conf/hdfs-site.xml", "")
+	listenAddress  = common.StringsVar(flags, "web.listen-address", ":9071", "暴露指标的监听地址，可重复指定以监听多个地址，默认9071.") //设置成ip:port的格式，似乎更容易进行更改
+	metricsPath    = flags.String("web.telemetry-path", "/metrics", "暴露指标的路由.")
+	systemdSocket  = flags.Bool("web.systemd-socket", false, "使用systemd socket activation提供的监听，而不是web.listen-address.")
+	webConfigFile  = flags.String("web.config.file", "", "开启TLS或basic auth的配置文件路径，为空时使用HTTP.")
+	clientConfFile = flags.String("hdfs-site.path", "/etc/hadoop/conf/hdfs-site.xml", "")
+	timeout        = flags.String("get.timeout-seconds", "5", "请求超时的时间")
+	pushGateway    = flags.String("push.gateway", "", "Pushgateway地址，设置后定时推送指标.")
+	pushInterval   = flags.Duration("push.interval", 15*time.Second, "推送到Pushgateway的间隔.")
+	// 指标名前缀，设置后指标名为<namespace>_DataNode_XXX
+	metricNamespace = flags.String("metric.namespace", "", "指标名前缀，为空时保持原有指标名.")
+	// 慢盘阈值，磁盘读写平均耗时超过该值时DataNode_SlowDisk为1
+	slowDiskThreshold = flags.Float64("datanode.slow-disk-threshold-ms", 0, "慢盘判断阈值，单位毫秒，为0时不输出DataNode_SlowDisk.")
+	// 按bean查询JMX，减少DataNode的CPU和传输量
+	jmxUseQuery = flags.Bool("jmx.use-query", false, "按需要的bean使用qry参数分别查询JMX，而不是获取完整的/jmx.")
+	// 只采集一次，用于调试和在CI中校验配置
+	oneshotMode = flags.Bool("oneshot", false, "采集一次指标并以文本格式输出到标准输出后退出，后端不可达时返回非0.")
+	// 访问后端的代理，为空时使用环境变量中的代理设置
+	httpProxy = flags.String("http.proxy", "", "请求后端时使用的代理地址，为空时使用HTTP_PROXY、HTTPS_PROXY和NO_PROXY环境变量.")
+	// 通过Knox等网关访问时JMX不在/jmx下
+	jmxPath = flags.String("jmx.path", "/jmx", "JMX接口的路径，通过Knox等网关访问时改为网关中的路径，例如/gateway/default/datanode/jmx.")
+	// 后端很慢时避免整个/metrics阻塞到Prometheus超时
+	collectTimeout = flags.Duration("collect.timeout", 0, "单次采集的超时时间，超时后只返回已采集到的指标，为0时不限制.")
+	// 在exporter端按规则保留或丢弃指标，用于控制高基数指标
+	relabelConfigFile = flags.String("metric.relabel-config", "", "指标过滤规则文件路径，按指标名和标签值保留或丢弃指标，为空时不过滤.")
+	// 容器中主机名可能解析到其他网卡的IP或无法解析
+	localIPAddr = flags.String("local.ip", "", "本机IP，原样用于serverip标签和判断本机是否为active节点，为空时通过解析主机名得到.")
+	// 毫秒时间戳在面板中经常被误当作秒，开启后额外输出距离采集时的秒数
+	timeAsAge = flags.Bool("time.as-age", false, "为StartTime等毫秒时间戳指标额外输出距离采集时的秒数*_age_seconds，原指标保持不变.")
+	// 不方便使用rate()的面板可以直接使用exporter计算的吞吐
+	deriveThroughput = flags.Bool("datanode.derive-throughput", false, "根据最近两次采集的BytesRead和BytesWritten计算每秒读写字节数.")
+	// 多个Prometheus同时采集时每次采集都会请求后端，限制并发避免放大后端的压力
+	maxRequests = flags.Int("web.max-requests", 4, "同时处理的采集请求数上限，超过时返回503，为0时不限制.")
+	// Kubernetes中configmap可能晚于容器挂载，等待配置文件而不是直接退出
+	configWait = flags.Duration("config.wait", 0, "配置文件不存在或无法解析时重试的最长时间，等待期间/ready返回503，为0时不等待.")
+	// 原生直方图只能通过protobuf格式抓取，文本格式只有经典的bucket
+	blockOpHistogram = flags.Bool("datanode.block-op-histogram", false, "根据两次采集之间ReadBlockOp和WriteBlockOp的次数增量和耗时累积为原生直方图DataNode_ReadBlockOpLatency和DataNode_WriteBlockOpLatency.")
 )
 
-//读取配置，从客户端配置中读取需要的信息
-type XMLConf struct {
-	XMLName   xml.Name    `xml:"configuration"`
-	NameValue []NameValue `xml:"property"`
-}
+// 请求后端使用的Transport，在main中根据http.proxy设置
+var transport http.RoundTripper = http.DefaultTransport
 
-type NameValue struct {
-	Name  string `xml:"name"`
-	Value string `xml:"value"`
-	Final string `xml:"final"`
-}
+// 暴露和推送指标使用的Gatherer，在main中根据metric.relabel-config设置
+var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
 
 type HDFSConf struct {
 	RpcPort    string // RPC端口
@@ -44,11 +100,18 @@ type HDFSConf struct {
 	HttpsOpen  bool   // 是否开启https
 	HttpPort   string // http端口
 	HttpsPort  string // https端口
+	// dfs.datanode.failed.volumes.tolerated，损坏的磁盘数超过该值时DataNode会退出，-1表示至少保留一块可用磁盘
+	FailedVolumesTolerated float64
+	// dfs.block.scanner.volume.bytes.per.second为0或dfs.datanode.scan.period.hours为负数时不运行块扫描
+	BlockScannerDisabled bool
+	// 配置了dfs.namenode.lifeline.rpc-address时DataNode才会发送lifeline
+	LifelineEnabled bool
 }
 
 type Exporter struct {
-	url string
-	c   HDFSConf
+	url    string
+	c      HDFSConf
+	client *http.Client // 复用的http客户端，带超时，避免JMX无响应时卡住采集
 	// 文件系统指标
 	VolumeFailures    prometheus.Gauge // 坏盘数量 "name": "Hadoop:service=DataNode,name=FSDatasetState",
 	CapacityTotal     prometheus.Gauge // 配置总空间
@@ -86,321 +149,1118 @@ type Exporter struct {
 	FreePhysicalMemorySize  prometheus.Gauge // 空闲物理内存
 	AvailableProcessors     prometheus.Gauge
 	ServerActive            prometheus.Gauge // 服务状态
-
-}
-
-//用于搜索配置值
-func SearchConf(name string, x *XMLConf) string {
-	for _, v := range x.NameValue {
-		//匹配配置项
-		if strings.Contains(v.Name, name) {
-			return v.Value
-		}
-	}
-	return ""
+	// 块复制指标，累计值 "name": "Hadoop:service=DataNode,name=DataNodeActivity"
+	BlocksReplicated          *prometheus.Desc // 复制的块数量
+	BlocksRemoved             *prometheus.Desc // 删除的块数量
+	BlocksVerified            *prometheus.Desc // 校验的块数量
+	BlockVerificationFailures *prometheus.Desc // 校验失败的块数量
+	// 最近一小时校验的块数，JMX只有累计值，根据最近一小时内的BlocksVerified采样计算
+	BlocksVerifiedInLastHour *prometheus.Desc
+	verifiedMutex            sync.Mutex
+	verifiedSamples          []counterSample
+	// 向NameNode发送心跳和lifeline的指标，心跳变慢后DataNode会被标记为stale甚至dead "name": "Hadoop:service=DataNode,name=DataNodeActivity"
+	HeartbeatsTotalNumOps  *prometheus.Desc // 心跳次数，累计值，包含心跳的处理
+	HeartbeatsTotalAvgTime *prometheus.Desc // 心跳平均耗时，包含心跳的处理
+	LifelinesNumOps        *prometheus.Desc // lifeline次数，累计值，未配置lifeline时不输出
+	// 向NameNode汇报块的指标，增量块汇报过多或全量块汇报变慢说明DataNode的元数据压力大 "name": "Hadoop:service=DataNode,name=DataNodeActivity"
+	IncrementalBlockReportsNumOps  *prometheus.Desc // 增量块汇报次数，累计值
+	IncrementalBlockReportsAvgTime *prometheus.Desc // 增量块汇报平均耗时
+	BlockReportsNumOps             *prometheus.Desc // 全量块汇报次数，累计值
+	BlockReportsAvgTime            *prometheus.Desc // 全量块汇报平均耗时
+	// 读写字节数，累计值 "name": "Hadoop:service=DataNode,name=DataNodeActivity"
+	BytesRead    *prometheus.Desc
+	BytesWritten *prometheus.Desc
+	// 根据最近两次采集的读写字节数计算的吞吐，需要开启datanode.derive-throughput
+	ReadThroughputBytesPerSec  *prometheus.Desc
+	WriteThroughputBytesPerSec *prometheus.Desc
+	throughputMutex            sync.Mutex
+	lastBytes                  map[string]counterSample
+	// 读写块的pipeline指标，用于将客户端的写失败对应到具体的DataNode "name": "Hadoop:service=DataNode,name=DataNodeActivity"
+	WriteBlockOpNumOps *prometheus.Desc // 写块操作次数
+	ReadBlockOpNumOps  *prometheus.Desc // 读块操作次数
+	FsyncNanosAvgTime  *prometheus.Desc // fsync平均耗时，单位为纳秒
+	// 读写块和fsync耗时的分位数，仅开启metrics percentiles时存在，指标类型不是summary，因此分位数标签为percentile而不是quantile
+	ReadBlockOpPercentileLatency  *prometheus.Desc
+	WriteBlockOpPercentileLatency *prometheus.Desc
+	FsyncNanosPercentileLatency   *prometheus.Desc
+	// 读写块耗时的直方图，单位为毫秒，JMX只有平均值和分位数，按两次采集之间的次数增量近似累积，需要开启datanode.block-op-histogram
+	ReadBlockOpLatency  prometheus.Histogram
+	WriteBlockOpLatency prometheus.Histogram
+	histogramMutex      sync.Mutex
+	lastBlockOps        map[string]float64
+	// 磁盘延迟指标，需要开启dfs.datanode.fileio.profiling.sampling.percentage "name": "Hadoop:service=DataNode,name=DataNodeVolume-XX"
+	volumeReadIoMeanTime  *prometheus.Desc // 磁盘读平均耗时
+	volumeWriteIoMeanTime *prometheus.Desc // 磁盘写平均耗时
+	slowDisk              *prometheus.Desc // 读写平均耗时超过阈值时为1
+	// 每个数据目录的空间，来自DataNodeInfo的VolumeInfo，FSDatasetState没有汇总值时用它们求和 "name": "Hadoop:service=DataNode,name=DataNodeInfo"
+	volumeUsedSpace     *prometheus.Desc // 已使用空间
+	volumeFreeSpace     *prometheus.Desc // 剩余空间
+	volumeReservedSpace *prometheus.Desc // dfs.datanode.du.reserved预留的空间
+	// 联邦集群中每个block pool的使用空间 "name": "Hadoop:service=DataNode,name=FSDatasetState"
+	blockPoolUsed *prometheus.Desc
+	// 当前损坏的磁盘数和允许损坏的磁盘数，前者接近后者时DataNode即将退出
+	FailedVolumes          *prometheus.Desc // "name": "Hadoop:service=DataNode,name=FSDatasetState"
+	ToleratedFailedVolumes *prometheus.Desc // 来自配置文件
+	// 非堆内存，包括metaspace和code cache，metaspace泄漏同样会导致OOM "name": "java.lang:type=Memory"
+	nonHeapMemoryUsageCommitted *prometheus.Desc
+	nonHeapMemoryUsageInit      *prometheus.Desc
+	nonHeapMemoryUsageMax       *prometheus.Desc // 未限制时为-1
+	nonHeapMemoryUsageUsed      *prometheus.Desc
+	// 进程信息，用于确认exporter采集的是哪个实例和版本，值固定为1
+	HadoopInfo *prometheus.Desc
+	// 后端的JMX是否采集成功，一个进程采集多个后端时可以区分是哪个不可达
+	BackendUp *prometheus.Desc
+	// 毫秒时间戳距离采集时的秒数，需要开启time.as-age
+	StartTimeAge *prometheus.Desc
+	// JvmMetrics中的GC汇总指标，与按收集器区分的GC指标互补 "name": "Hadoop:service=DataNode,name=JvmMetrics"
+	JvmGcCount                    *prometheus.Desc // GC次数，累计值
+	JvmGcTimeMillis               *prometheus.Desc // GC累计耗时，单位为毫秒
+	JvmGcNumWarnThresholdExceeded *prometheus.Desc // JvmPauseMonitor检测到超过告警阈值的停顿次数，累计值
+	// 最近一段时间内GC耗时的占比，需要开启GcTimeMonitor，接近100说明JVM一直在GC
+	JvmGcTimePercentage *prometheus.Desc
+	// 本次采集返回的bean数量，指标缺失时先确认bean是否存在
+	beanCount *prometheus.Desc
+	// 采集是否超时
+	scrapeTimeout *prometheus.Desc
+	// 配置的dfs.http.policy与实际协议不一致时为1，得到明确结果前每次采集都检测，之后不再请求
+	schemeMismatch   *prometheus.Desc
+	schemeMutex      sync.Mutex
+	schemeChecked    bool
+	schemeMismatched bool
 }
 
-//读取XML配置文件，返回一个XMLConf结构体
-func ReadXml(path string) *XMLConf {
-	xmlFile, err := os.Open(path)
-	if err != nil {
-		log.Error("Error opening file: %s", path)
-		os.Exit(1)
+// 是否为任意一个NameNode配置了lifeline地址，HA时配置项为dfs.namenode.lifeline.rpc-address.<nameservice>.<namenode>
+// DataNode向dfs.internal.nameservices中的nameservice汇报，未配置时使用dfs.nameservices
+func lifelineEnabled(e *common.XMLConf) bool {
+	nameServices := common.SplitIDs(common.SearchConf("dfs.internal.nameservices", e))
+	if len(nameServices) == 0 {
+		nameServices = common.SplitIDs(common.SearchConf("dfs.nameservices", e))
 	}
-	defer xmlFile.Close()
-	var x XMLConf
-	data, err := ioutil.ReadAll(xmlFile)
-	if err != nil {
-		log.Error("Error reading file: %s", path)
-		os.Exit(1)
-	}
-	err = xml.Unmarshal(data, &x)
-	if err != nil {
-		log.Error("Error unmarshal xml.")
-		os.Exit(1)
+	// 非HA时配置项不带后缀
+	nameServices = append(nameServices, "")
+	for _, ns := range nameServices {
+		ids := common.SplitIDs(common.SearchConf(common.HAKey("dfs.ha.namenodes", ns), e))
+		for _, id := range append(ids, "") {
+			if common.SearchConf(common.HAKey("dfs.namenode.lifeline.rpc-address", ns, id), e) != "" {
+				return true
+			}
+		}
 	}
-	return &x
+	return false
 }
 
-//生成采集器使用的配置项
-func CreateHDFSConf(e *XMLConf) *HDFSConf {
+// 生成采集器使用的配置项
+func CreateHDFSConf(e *common.XMLConf) *HDFSConf {
 	c := HDFSConf{}
-	h, err := os.Hostname()
-	if err != nil {
-		panic(err)
-	}
-	t, err := net.ResolveIPAddr("ip", h)
-	if err != nil {
-		panic(err)
-	}
 	// c.HostName = h
 	c.HostName = ""
-	c.ServerIP = t.IP.String()
-	c.RpcPort = strings.Split(SearchConf("dfs.datanode.ipc.address", e), ":")[1]
+	c.ServerIP = common.LocalIP(*localIPAddr)
+	// Hadoop 2.x和3.x的默认端口不同，通过数据传输端口判断版本
+	defaultHttpPort, defaultHttpsPort, defaultIpcPort := "9864", "9865", "9867"
+	if strings.HasSuffix(common.SearchConf("dfs.datanode.address", e), ":50010") {
+		defaultHttpPort, defaultHttpsPort, defaultIpcPort = "50075", "50475", "50020"
+	}
+	c.RpcPort = common.ConfPort("dfs.datanode.ipc.address", e, defaultIpcPort)
 	// 默认关闭https
 	c.HttpsOpen = httpsmode
 	// 判断是否开启HTTPS，并获取端口
-	if v := SearchConf("dfs.http.policy", e); v == "HTTPS_ONLY" {
+	if v := common.SearchConf("dfs.http.policy", e); v == "HTTPS_ONLY" {
 		c.HttpsOpen = true
-		c.HttpsPort = strings.Split(SearchConf("dfs.datanode.https.address", e), ":")[1]
+		c.HttpsPort = common.ConfPort("dfs.datanode.https.address", e, defaultHttpsPort)
 	} else {
-		c.HttpPort = strings.Split(SearchConf("dfs.datanode.http.address", e), ":")[1]
+		c.HttpPort = common.ConfPort("dfs.datanode.http.address", e, defaultHttpPort)
+	}
+	if v := common.SearchConf("dfs.datanode.failed.volumes.tolerated", e); v != "" {
+		tolerated, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			log.Warnf("Invalid dfs.datanode.failed.volumes.tolerated %q, use default 0", v)
+		}
+		c.FailedVolumesTolerated = tolerated
 	}
+	if v := strings.TrimSpace(common.SearchConf("dfs.block.scanner.volume.bytes.per.second", e)); v == "0" {
+		c.BlockScannerDisabled = true
+	}
+	if v, err := strconv.Atoi(strings.TrimSpace(common.SearchConf("dfs.datanode.scan.period.hours", e))); err == nil && v < 0 {
+		c.BlockScannerDisabled = true
+	}
+	c.LifelineEnabled = lifelineEnabled(e)
 
+	applyEnvConf(&c)
 	return &c
 }
 
 //指标格式定义：metrics_name{job="XX",ip="10.30.108.2"}
 
-//创建指标
-func NewExporter(url string, c *HDFSConf) *Exporter {
+// 创建指标
+func NewExporter(url string, c *HDFSConf, namespace string) *Exporter {
+	t, err := strconv.Atoi(*timeout)
+	if err != nil {
+		log.Warnf("Invalid get.timeout-seconds %s, use 5 seconds", *timeout)
+		t = 5
+	}
 	return &Exporter{
 		url: url,
 		c:   *c,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(t * int(time.Second)),
+		},
 		XceiverCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_XceiverCount",
 			Help:        "XceiverCount",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		VolumeFailures: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_VolumeFailures",
 			Help:        "VolumeFailures",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		CapacityTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_CapacityTotal",
 			Help:        "CapacityTotal",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		CapacityUsed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_CapacityUsed",
 			Help:        "CapacityUsed",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		CapacityRemaining: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_CapacityRemaining",
 			Help:        "CapacityRemaining",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		DatanodeNetworkErrors: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_DatanodeNetworkErrors",
 			Help:        "DatanodeNetworkErrors",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		WritesFromRemoteClient: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_WritesFromRemoteClient",
 			Help:        "WritesFromRemoteClient",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		WritesFromLocalClient: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_WritesFromLocalClient",
 			Help:        "WritesFromLocalClient",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		ReadsFromRemoteClient: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_ReadsFromRemoteClient",
 			Help:        "ReadsFromRemoteClient",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		ReadsFromLocalClient: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_ReadsFromLocalClient",
 			Help:        "ReadsFromLocalClient",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		ReadBlockOpAvgTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_ReadBlockOpAvgTime",
 			Help:        "ReadBlockOpAvgTime",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		WriteBlockOpAvgTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_WriteBlockOpAvgTime",
 			Help:        "WriteBlockOpAvgTime",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
+		ReadBlockOpLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Name:                        "DataNode_ReadBlockOpLatency",
+			Help:                        "ReadBlockOp latency in milliseconds accumulated across scrapes",
+			ConstLabels:                 map[string]string{"serverip": c.ServerIP},
+			Buckets:                     blockOpBuckets,
+			NativeHistogramBucketFactor: 1.1,
+		}),
+		WriteBlockOpLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:                   namespace,
+			Name:                        "DataNode_WriteBlockOpLatency",
+			Help:                        "WriteBlockOp latency in milliseconds accumulated across scrapes",
+			ConstLabels:                 map[string]string{"serverip": c.ServerIP},
+			Buckets:                     blockOpBuckets,
+			NativeHistogramBucketFactor: 1.1,
+		}),
+		lastBlockOps: make(map[string]float64),
 		heapMemoryUsageCommitted: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_heapMemoryUsageCommitted",
 			Help:        "heapMemoryUsageCommitted",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		heapMemoryUsageInit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_heapMemoryUsageInit",
 			Help:        "heapMemoryUsageInit",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		heapMemoryUsageMax: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_heapMemoryUsageMax",
 			Help:        "heapMemoryUsageMax",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		heapMemoryUsageUsed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_heapMemoryUsageUsed",
 			Help:        "heapMemoryUsageUsed",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		RpcQueueTimeNumOps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_RpcQueueTimeNumOps",
 			Help:        "RpcQueueTimeNumOps",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		RpcQueueTimeAvgTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_RpcQueueTimeAvgTime",
 			Help:        "RpcQueueTimeAvgTime",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		RpcProcessingTimeNumOps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_RpcProcessingTimeNumOps",
 			Help:        "RpcProcessingTimeNumOps",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		RpcProcessingTimeAvgTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_RpcProcessingTimeAvgTime",
 			Help:        "RpcProcessingTimeAvgTime",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		NumOpenConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_NumOpenConnections",
 			Help:        "NumOpenConnections",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		ReceivedBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_ReceivedBytes",
 			Help:        "ReceivedBytes",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		SentBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_SentBytes",
 			Help:        "SentBytes",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		StartTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_StartTime",
 			Help:        "StartTime",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		SystemLoadAverage: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_SystemLoadAverage",
 			Help:        "SystemLoadAverage",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		OpenFileDescriptorCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_OpenFileDescriptorCount",
 			Help:        "OpenFileDescriptorCount",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		MaxFileDescriptorCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_MaxFileDescriptorCount",
 			Help:        "MaxFileDescriptorCount",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		TotalPhysicalMemorySize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_TotalPhysicalMemorySize",
 			Help:        "TotalPhysicalMemorySize",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		FreePhysicalMemorySize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_FreePhysicalMemorySize",
 			Help:        "FreePhysicalMemorySize",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		AvailableProcessors: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_AvailableProcessors",
 			Help:        "AvailableProcessors",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
 		ServerActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "DataNode_ServerActive",
 			Help:        "ServerActive",
 			ConstLabels: map[string]string{"serverip": c.ServerIP},
 		}),
+		BlocksReplicated: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_BlocksReplicated_total"),
+			"BlocksReplicated",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		BlocksRemoved: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_BlocksRemoved_total"),
+			"BlocksRemoved",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		BlocksVerified: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_BlocksVerified_total"),
+			"BlocksVerified",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		HeartbeatsTotalNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_HeartbeatsTotalNumOps_total"),
+			"HeartbeatsTotalNumOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		HeartbeatsTotalAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_HeartbeatsTotalAvgTime"),
+			"HeartbeatsTotalAvgTime",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		LifelinesNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_LifelinesNumOps_total"),
+			"LifelinesNumOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		IncrementalBlockReportsNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_IncrementalBlockReportsNumOps_total"),
+			"IncrementalBlockReportsNumOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		IncrementalBlockReportsAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_IncrementalBlockReportsAvgTime"),
+			"IncrementalBlockReportsAvgTime",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		BlockReportsNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_BlockReportsNumOps_total"),
+			"BlockReportsNumOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		BlockReportsAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_BlockReportsAvgTime"),
+			"BlockReportsAvgTime",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		BytesRead: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_BytesRead_total"),
+			"BytesRead",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		BytesWritten: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_BytesWritten_total"),
+			"BytesWritten",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		ReadThroughputBytesPerSec: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_ReadThroughputBytesPerSec"),
+			"The bytes read per second between the last two scrapes",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		WriteThroughputBytesPerSec: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_WriteThroughputBytesPerSec"),
+			"The bytes written per second between the last two scrapes",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		lastBytes: make(map[string]counterSample),
+		BlocksVerifiedInLastHour: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_BlocksVerifiedInLastHour"),
+			"The number of blocks verified by the block scanner in the last hour, partial until the exporter has run for an hour",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		BlockVerificationFailures: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_BlockVerificationFailures_total"),
+			"BlockVerificationFailures",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		WriteBlockOpNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_WriteBlockOpNumOps_total"),
+			"WriteBlockOpNumOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		ReadBlockOpNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_ReadBlockOpNumOps_total"),
+			"ReadBlockOpNumOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		FsyncNanosAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_FsyncNanosAvgTime"),
+			"FsyncNanosAvgTime",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		ReadBlockOpPercentileLatency: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_ReadBlockOpPercentileLatency"),
+			"ReadBlockOp percentile latency",
+			[]string{"percentile", "interval"},
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		WriteBlockOpPercentileLatency: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_WriteBlockOpPercentileLatency"),
+			"WriteBlockOp percentile latency",
+			[]string{"percentile", "interval"},
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		FsyncNanosPercentileLatency: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_FsyncNanosPercentileLatency"),
+			"FsyncNanos percentile latency in nanoseconds",
+			[]string{"percentile", "interval"},
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		volumeReadIoMeanTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_VolumeReadIoMeanTime"),
+			"The mean time of read io on the volume in milliseconds",
+			[]string{"volume"},
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		volumeWriteIoMeanTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_VolumeWriteIoMeanTime"),
+			"The mean time of write io on the volume in milliseconds",
+			[]string{"volume"},
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		slowDisk: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_SlowDisk"),
+			"Whether the volume io mean time exceeds the slow disk threshold, 1 slow, 0 normal",
+			[]string{"volume"},
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		volumeUsedSpace: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_VolumeUsedSpace"),
+			"usedSpace",
+			[]string{"volume"},
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		volumeFreeSpace: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_VolumeFreeSpace"),
+			"freeSpace",
+			[]string{"volume"},
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		volumeReservedSpace: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_VolumeReservedSpace"),
+			"reservedSpace",
+			[]string{"volume"},
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		blockPoolUsed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_BlockPoolUsed"),
+			"The space used by each block pool",
+			[]string{"blockpool"},
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		FailedVolumes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_FailedVolumes"),
+			"The number of currently failed volumes",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		ToleratedFailedVolumes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_ToleratedFailedVolumes"),
+			"The value of dfs.datanode.failed.volumes.tolerated, -1 means at least one volume must be available",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		nonHeapMemoryUsageCommitted: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_nonHeapMemoryUsageCommitted"),
+			"nonHeapMemoryUsageCommitted",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		nonHeapMemoryUsageInit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_nonHeapMemoryUsageInit"),
+			"nonHeapMemoryUsageInit",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		nonHeapMemoryUsageMax: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_nonHeapMemoryUsageMax"),
+			"nonHeapMemoryUsageMax",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		nonHeapMemoryUsageUsed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_nonHeapMemoryUsageUsed"),
+			"nonHeapMemoryUsageUsed",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		HadoopInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_info"),
+			"Information about the Hadoop daemon, value is always 1",
+			[]string{"hostname", "version", "hadoop_version"},
+			prometheus.Labels{"serverip": c.ServerIP, "role": "datanode"},
+		),
+		StartTimeAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_StartTime_age_seconds"),
+			"The seconds since the DataNode started",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		BackendUp: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_backend_up"),
+			"Whether the last JMX request to the backend succeeded, 1 success, 0 failure",
+			[]string{"target"},
+			prometheus.Labels{"serverip": c.ServerIP, "role": "datanode"},
+		),
+		JvmGcCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_GcCount_total"),
+			"GcCount",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		JvmGcTimeMillis: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_GcTimeMillis_total"),
+			"GcTimeMillis",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		JvmGcNumWarnThresholdExceeded: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_GcNumWarnThresholdExceeded"),
+			"GcNumWarnThresholdExceeded",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		JvmGcTimePercentage: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "DataNode_GcTimePercentage"),
+			"GcTimePercentage",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		beanCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_beans"),
+			"The number of JMX beans returned in the last scrape",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		scrapeTimeout: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_scrape_timeout"),
+			"Whether the last scrape exceeded collect.timeout and returned partial metrics, 1 timeout, 0 normal",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		schemeMismatch: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_scheme_mismatch"),
+			"Whether the configured dfs.http.policy does not match the scheme the server actually uses, 1 mismatch, 0 match",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
 	}
 }
 
 // 定义指标的描述
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	e.VolumeFailures.Describe(ch)
+	e.CapacityTotal.Describe(ch)
+	e.CapacityUsed.Describe(ch)
+	e.CapacityRemaining.Describe(ch)
+	e.XceiverCount.Describe(ch)
+	e.DatanodeNetworkErrors.Describe(ch)
+	e.WritesFromRemoteClient.Describe(ch)
+	e.WritesFromLocalClient.Describe(ch)
+	e.ReadsFromRemoteClient.Describe(ch)
+	e.ReadsFromLocalClient.Describe(ch)
+	e.ReadBlockOpAvgTime.Describe(ch)
+	e.WriteBlockOpAvgTime.Describe(ch)
+	e.ReadBlockOpLatency.Describe(ch)
+	e.WriteBlockOpLatency.Describe(ch)
+	e.heapMemoryUsageCommitted.Describe(ch)
+	e.heapMemoryUsageInit.Describe(ch)
+	e.heapMemoryUsageMax.Describe(ch)
+	e.heapMemoryUsageUsed.Describe(ch)
+	e.RpcQueueTimeNumOps.Describe(ch)
+	e.RpcQueueTimeAvgTime.Describe(ch)
+	e.RpcProcessingTimeNumOps.Describe(ch)
+	e.RpcProcessingTimeAvgTime.Describe(ch)
+	e.NumOpenConnections.Describe(ch)
+	e.ReceivedBytes.Describe(ch)
+	e.SentBytes.Describe(ch)
+	e.StartTime.Describe(ch)
+	e.SystemLoadAverage.Describe(ch)
+	e.MaxFileDescriptorCount.Describe(ch)
+	e.OpenFileDescriptorCount.Describe(ch)
+	e.TotalPhysicalMemorySize.Describe(ch)
+	e.FreePhysicalMemorySize.Describe(ch)
+	e.AvailableProcessors.Describe(ch)
+	e.ServerActive.Describe(ch)
+	ch <- e.BlocksReplicated
+	ch <- e.BlocksRemoved
+	ch <- e.BlocksVerified
+	ch <- e.BlockVerificationFailures
+	ch <- e.BlocksVerifiedInLastHour
+	ch <- e.HeartbeatsTotalNumOps
+	ch <- e.HeartbeatsTotalAvgTime
+	ch <- e.LifelinesNumOps
+	ch <- e.IncrementalBlockReportsNumOps
+	ch <- e.IncrementalBlockReportsAvgTime
+	ch <- e.BlockReportsNumOps
+	ch <- e.BlockReportsAvgTime
+	ch <- e.BytesRead
+	ch <- e.BytesWritten
+	ch <- e.ReadThroughputBytesPerSec
+	ch <- e.WriteThroughputBytesPerSec
+	ch <- e.WriteBlockOpNumOps
+	ch <- e.ReadBlockOpNumOps
+	ch <- e.FsyncNanosAvgTime
+	ch <- e.ReadBlockOpPercentileLatency
+	ch <- e.WriteBlockOpPercentileLatency
+	ch <- e.FsyncNanosPercentileLatency
+	ch <- e.volumeReadIoMeanTime
+	ch <- e.volumeWriteIoMeanTime
+	ch <- e.slowDisk
+	ch <- e.volumeUsedSpace
+	ch <- e.volumeFreeSpace
+	ch <- e.volumeReservedSpace
+	ch <- e.blockPoolUsed
+	ch <- e.FailedVolumes
+	ch <- e.ToleratedFailedVolumes
+	ch <- e.nonHeapMemoryUsageCommitted
+	ch <- e.nonHeapMemoryUsageInit
+	ch <- e.nonHeapMemoryUsageMax
+	ch <- e.nonHeapMemoryUsageUsed
+	ch <- e.HadoopInfo
+	ch <- e.BackendUp
+	ch <- e.StartTimeAge
+	ch <- e.JvmGcCount
+	ch <- e.JvmGcTimeMillis
+	ch <- e.JvmGcNumWarnThresholdExceeded
+	ch <- e.JvmGcTimePercentage
+	ch <- e.beanCount
+	ch <- e.scrapeTimeout
+	ch <- e.schemeMismatch
+}
+
+// 输出每个数据目录的空间，FSDatasetState缺少Capacity、DfsUsed或Remaining时用各目录的值求和
+// 目录的总空间为已使用和剩余空间之和，与FSDatasetState的Capacity一样不包含预留空间
+func (e *Exporter) collectVolumeSpace(volumes map[string]map[string]interface{}, capacityFound map[string]bool, ch chan<- prometheus.Metric) {
+	if len(volumes) == 0 {
+		return
+	}
+	sums := make(map[string]float64)
+	for dir, info := range volumes {
+		used, hasUsed := common.GetFloat(info, "usedSpace")
+		free, hasFree := common.GetFloat(info, "freeSpace")
+		if hasUsed {
+			ch <- prometheus.MustNewConstMetric(e.volumeUsedSpace, prometheus.GaugeValue, used, dir)
+		}
+		if hasFree {
+			ch <- prometheus.MustNewConstMetric(e.volumeFreeSpace, prometheus.GaugeValue, free, dir)
+		}
+		if v, ok := common.GetFloat(info, "reservedSpace"); ok {
+			ch <- prometheus.MustNewConstMetric(e.volumeReservedSpace, prometheus.GaugeValue, v, dir)
+		}
+		sums["DfsUsed"] += used
+		sums["Remaining"] += free
+		sums["Capacity"] += used + free
+	}
+	for key, g := range map[string]prometheus.Gauge{
+		"Capacity":  e.CapacityTotal,
+		"DfsUsed":   e.CapacityUsed,
+		"Remaining": e.CapacityRemaining,
+	} {
+		if !capacityFound[key] {
+			g.Set(sums[key])
+		}
+	}
+}
+
+// 输出本次采集到的bean数量，debug日志中列出没有匹配beanPatterns的bean，用于排查指标缺失，例如RPC端口与配置不同
+func (e *Exporter) collectBeanCount(beans []interface{}, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(e.beanCount, prometheus.GaugeValue, float64(len(beans)))
+	var unmatched []string
+	patterns := e.beanPatterns()
+	for _, bean := range beans {
+		m, _ := bean.(map[string]interface{})
+		name, _ := m["name"].(string)
+		matched := false
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			unmatched = append(unmatched, name)
+		}
+	}
+	if len(unmatched) > 0 {
+		log.Debugf("Beans not used by the collector: %s", strings.Join(unmatched, ", "))
+	}
+}
+
+// 开启jmx.use-query时需要查询的bean
+func (e *Exporter) jmxQueries() []string {
+	if !*jmxUseQuery {
+		return nil
+	}
+	return e.beanPatterns()
+}
 
+// Collect中用到的bean，新增bean的处理时需要同时加到这里
+func (e *Exporter) beanPatterns() []string {
+	return []string{
+		"Hadoop:service=DataNode,name=DataNodeActivity-*",
+		"Hadoop:service=DataNode,name=DataNodeVolume-*",
+		"Hadoop:service=DataNode,name=DataNodeInfo",
+		"Hadoop:service=DataNode,name=FSDatasetState",
+		"Hadoop:service=DataNode,name=JvmMetrics",
+		"Hadoop:service=DataNode,name=RpcActivityForPort" + e.c.RpcPort,
+		"java.lang:type=Memory",
+		"java.lang:type=OperatingSystem",
+		"java.lang:type=Runtime",
+	}
 }
 
-//采集器方法
+// 检测配置的协议与实际是否一致，后端不可达时无法判断，下次采集再检测
+func (e *Exporter) collectSchemeMismatch(ctx context.Context, client *http.Client, ch chan<- prometheus.Metric) {
+	e.schemeMutex.Lock()
+	defer e.schemeMutex.Unlock()
+	if !e.schemeChecked {
+		// 不跟随重定向，直接检查Location的协议，跟随后https的证书错误会掩盖重定向
+		probe := *client
+		probe.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		target := e.url + "?qry=" + url.QueryEscape("java.lang:type=Runtime")
+		resp, err := common.Get(ctx, &probe, target)
+		if err == nil {
+			resp.Body.Close()
+		}
+		e.schemeMismatched = common.SchemeMismatch(target, resp, err)
+		e.schemeChecked = err == nil || e.schemeMismatched
+		if e.schemeMismatched {
+			log.Errorf("Scheme of %s does not match the server, check dfs.http.policy", e.url)
+		}
+	}
+	v := 0.0
+	if e.schemeMismatched {
+		v = 1
+	}
+	ch <- prometheus.MustNewConstMetric(e.schemeMismatch, prometheus.GaugeValue, v)
+}
+
+// 采集器方法，超过collect.timeout时取消未完成的请求，只输出已读取到的指标，并将hadoop_exporter_scrape_timeout置为1
+// 请求在超时后立即返回，采集在本次Collect内结束，不会与下一次采集重叠
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	common.CollectWithTimeout(*collectTimeout, e.scrapeTimeout, ch, func(ctx context.Context) {
+		e.collect(ctx, ch)
+	})
+}
+
+// 采集器方法
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	e.collectSchemeMismatch(ctx, e.client, ch)
 	e.ServerActive.Set(0)
-	resp, err := http.Get(e.url)
+	nameList, _, err := common.FetchBeans(ctx, e.client, e.url, e.jmxQueries())
+	backendUp := 1.0
 	if err != nil {
-		log.Error(err)
-		e.ServerActive.Collect(ch)
-		return
-	}
-	defer resp.Body.Close()
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Error(err)
+		backendUp = 0
 	}
-	var f interface{}
-	err = json.Unmarshal(data, &f)
+	ch <- prometheus.MustNewConstMetric(e.BackendUp, prometheus.GaugeValue, backendUp, e.url)
 	if err != nil {
 		log.Error(err)
+		e.ServerActive.Collect(ch)
+		return
 	}
-	m := f.(map[string]interface{})
-	var nameList = m["beans"].([]interface{})
-	// 先设置一下Hostname，如果存在就不设置了
-	if e.c.HostName == "" {
-		for _, nameData := range nameList {
-			nameDataMap := nameData.(map[string]interface{})
-			if nameDataMap["name"] == "Hadoop:service=DataNode,name=DataNodeInfo" {
-				e.c.HostName = nameDataMap["DatanodeHostname"].(string)
-				e.c.ServerPort = nameDataMap["DataPort"].(string)
-			}
-		}
-	}
+	// 汇总所有DataNodeActivity-*的bean，不再根据主机名和端口拼接bean名称，避免获取失败时采集不到
+	activity := make(map[string]float64)
+	var hostname, version, hadoopVersion string
+	// FSDatasetState中存在的汇总字段，缺失的字段根据VolumeInfo求和
+	capacityFound := make(map[string]bool)
+	var volumes map[string]map[string]interface{}
+	e.collectBeanCount(nameList, ch)
 	for _, nameData := range nameList {
 		nameDataMap := nameData.(map[string]interface{})
+		if v, ok := nameDataMap["tag.Hostname"].(string); ok && hostname == "" {
+			hostname = v
+		}
 		if nameDataMap["name"] == "Hadoop:service=DataNode,name=DataNodeInfo" {
-			e.XceiverCount.Set(nameDataMap["XceiverCount"].(float64))
+			if v, ok := common.GetFloat(nameDataMap, "XceiverCount"); ok {
+				e.XceiverCount.Set(v)
+			}
+			// VolumeInfo可能是对象，也可能是JSON字符串，格式为{"目录":{"usedSpace":X,"freeSpace":X,"reservedSpace":X}}
+			if v, ok := nameDataMap["VolumeInfo"].(string); ok {
+				if err := json.Unmarshal([]byte(v), &volumes); err != nil {
+					log.Error(err)
+				}
+			} else if m, ok := nameDataMap["VolumeInfo"].(map[string]interface{}); ok {
+				volumes = make(map[string]map[string]interface{})
+				for dir, info := range m {
+					volumes[dir], _ = info.(map[string]interface{})
+				}
+			}
+			version, hadoopVersion = versionInfo(nameDataMap)
 		}
 		if nameDataMap["name"] == "Hadoop:service=DataNode,name=FSDatasetState" {
-			e.CapacityTotal.Set(nameDataMap["Capacity"].(float64))
-			e.CapacityUsed.Set(nameDataMap["DfsUsed"].(float64))
-			e.CapacityRemaining.Set(nameDataMap["Remaining"].(float64))
-		}
-		if nameDataMap["name"] == "Hadoop:service=DataNode,name=DataNodeActivity-"+e.c.HostName+"-"+e.c.ServerPort {
-			e.VolumeFailures.Set(nameDataMap["VolumeFailures"].(float64))
-			e.ReadBlockOpAvgTime.Set(nameDataMap["ReadBlockOpAvgTime"].(float64))
-			e.WriteBlockOpAvgTime.Set(nameDataMap["WriteBlockOpAvgTime"].(float64))
-			e.WritesFromRemoteClient.Set(nameDataMap["WritesFromRemoteClient"].(float64))
-			e.WritesFromLocalClient.Set(nameDataMap["WritesFromLocalClient"].(float64))
-			e.ReadsFromRemoteClient.Set(nameDataMap["ReadsFromRemoteClient"].(float64))
-			e.ReadsFromLocalClient.Set(nameDataMap["ReadsFromLocalClient"].(float64))
-			e.DatanodeNetworkErrors.Set(nameDataMap["DatanodeNetworkErrors"].(float64))
+			for key, g := range map[string]prometheus.Gauge{
+				"Capacity":  e.CapacityTotal,
+				"DfsUsed":   e.CapacityUsed,
+				"Remaining": e.CapacityRemaining,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					g.Set(v)
+					capacityFound[key] = true
+				}
+			}
+			if v, ok := common.GetFloat(nameDataMap, "NumFailedVolumes"); ok {
+				ch <- prometheus.MustNewConstMetric(e.FailedVolumes, prometheus.GaugeValue, v)
+			}
+			// BlockPoolUsed可能是对象，也可能是JSON字符串，格式为{"BP-XX":已用空间}，没有时只输出汇总的DfsUsed
+			blockPools, _ := nameDataMap["BlockPoolUsed"].(map[string]interface{})
+			if v, ok := nameDataMap["BlockPoolUsed"].(string); ok {
+				if err := json.Unmarshal([]byte(v), &blockPools); err != nil {
+					log.Error(err)
+				}
+			}
+			for bp := range blockPools {
+				if used, ok := common.GetFloat(blockPools, bp); ok {
+					ch <- prometheus.MustNewConstMetric(e.blockPoolUsed, prometheus.GaugeValue, used, bp)
+				}
+			}
+		}
+		if name, _ := nameDataMap["name"].(string); strings.HasPrefix(name, "Hadoop:service=DataNode,name=DataNodeActivity-") {
+			for key := range nameDataMap {
+				v, ok := common.GetFloat(nameDataMap, key)
+				if !ok {
+					continue
+				}
+				// 平均耗时和分位数不能累加，取最大值
+				if strings.HasSuffix(key, "AvgTime") || strings.HasSuffix(key, "PercentileLatency") {
+					activity[key] = math.Max(activity[key], v)
+				} else {
+					activity[key] += v
+				}
+			}
+		}
+		if name, _ := nameDataMap["name"].(string); strings.HasPrefix(name, "Hadoop:service=DataNode,name=DataNodeVolume-") {
+			volume := strings.TrimPrefix(name, "Hadoop:service=DataNode,name=DataNodeVolume-")
+			slow := 0.0
+			if v, ok := common.GetFloat(nameDataMap, "ReadIoMeanTime"); ok {
+				ch <- prometheus.MustNewConstMetric(e.volumeReadIoMeanTime, prometheus.GaugeValue, v, volume)
+				if v > *slowDiskThreshold {
+					slow = 1
+				}
+			}
+			if v, ok := common.GetFloat(nameDataMap, "WriteIoMeanTime"); ok {
+				ch <- prometheus.MustNewConstMetric(e.volumeWriteIoMeanTime, prometheus.GaugeValue, v, volume)
+				if v > *slowDiskThreshold {
+					slow = 1
+				}
+			}
+			if *slowDiskThreshold > 0 {
+				ch <- prometheus.MustNewConstMetric(e.slowDisk, prometheus.GaugeValue, slow, volume)
+			}
 		}
 		if nameDataMap["name"] == "Hadoop:service=DataNode,name=RpcActivityForPort"+e.c.RpcPort {
-			e.RpcQueueTimeNumOps.Set(nameDataMap["RpcQueueTimeNumOps"].(float64))
-			e.RpcQueueTimeAvgTime.Set(nameDataMap["RpcQueueTimeAvgTime"].(float64))
-			e.RpcProcessingTimeNumOps.Set(nameDataMap["RpcProcessingTimeNumOps"].(float64))
-			e.RpcProcessingTimeAvgTime.Set(nameDataMap["RpcProcessingTimeAvgTime"].(float64))
-			e.ReceivedBytes.Set(nameDataMap["ReceivedBytes"].(float64))
-			e.SentBytes.Set(nameDataMap["SentBytes"].(float64))
-			e.NumOpenConnections.Set(nameDataMap["NumOpenConnections"].(float64))
+			for key, gauge := range map[string]prometheus.Gauge{
+				"RpcQueueTimeNumOps":       e.RpcQueueTimeNumOps,
+				"RpcQueueTimeAvgTime":      e.RpcQueueTimeAvgTime,
+				"RpcProcessingTimeNumOps":  e.RpcProcessingTimeNumOps,
+				"RpcProcessingTimeAvgTime": e.RpcProcessingTimeAvgTime,
+				"ReceivedBytes":            e.ReceivedBytes,
+				"SentBytes":                e.SentBytes,
+				"NumOpenConnections":       e.NumOpenConnections,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					gauge.Set(v)
+				}
+			}
+		}
+		if nameDataMap["name"] == "Hadoop:service=DataNode,name=JvmMetrics" {
+			for key, desc := range map[string]*prometheus.Desc{
+				"GcCount":                    e.JvmGcCount,
+				"GcTimeMillis":               e.JvmGcTimeMillis,
+				"GcNumWarnThresholdExceeded": e.JvmGcNumWarnThresholdExceeded,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v)
+				}
+			}
+			if v, ok := common.GetFloat(nameDataMap, "GcTimePercentage"); ok {
+				ch <- prometheus.MustNewConstMetric(e.JvmGcTimePercentage, prometheus.GaugeValue, v)
+			}
 		}
 		if nameDataMap["name"] == "java.lang:type=Memory" {
-			heapMemoryUsage := nameDataMap["HeapMemoryUsage"].(map[string]interface{})
-			e.heapMemoryUsageCommitted.Set(heapMemoryUsage["committed"].(float64))
-			e.heapMemoryUsageInit.Set(heapMemoryUsage["init"].(float64))
-			e.heapMemoryUsageMax.Set(heapMemoryUsage["max"].(float64))
-			e.heapMemoryUsageUsed.Set(heapMemoryUsage["used"].(float64))
+			heapMemoryUsage, _ := nameDataMap["HeapMemoryUsage"].(map[string]interface{})
+			for key, gauge := range map[string]prometheus.Gauge{
+				"committed": e.heapMemoryUsageCommitted,
+				"init":      e.heapMemoryUsageInit,
+				"max":       e.heapMemoryUsageMax,
+				"used":      e.heapMemoryUsageUsed,
+			} {
+				if v, ok := common.GetFloat(heapMemoryUsage, key); ok {
+					gauge.Set(v)
+				}
+			}
+			nonHeapMemoryUsage, _ := nameDataMap["NonHeapMemoryUsage"].(map[string]interface{})
+			for key, desc := range map[string]*prometheus.Desc{
+				"committed": e.nonHeapMemoryUsageCommitted,
+				"init":      e.nonHeapMemoryUsageInit,
+				"max":       e.nonHeapMemoryUsageMax,
+				"used":      e.nonHeapMemoryUsageUsed,
+			} {
+				if v, ok := common.GetFloat(nonHeapMemoryUsage, key); ok {
+					ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+				}
+			}
 		}
 		if nameDataMap["name"] == "java.lang:type=Runtime" {
-			e.StartTime.Set(nameDataMap["StartTime"].(float64))
+			if v, ok := common.GetFloat(nameDataMap, "StartTime"); ok {
+				e.StartTime.Set(v)
+				if *timeAsAge && v > 0 {
+					ch <- prometheus.MustNewConstMetric(e.StartTimeAge, prometheus.GaugeValue, ageSeconds(v))
+				}
+			}
 		}
 		if nameDataMap["name"] == "java.lang:type=OperatingSystem" {
-			e.SystemLoadAverage.Set(nameDataMap["SystemLoadAverage"].(float64))
-			e.OpenFileDescriptorCount.Set(nameDataMap["OpenFileDescriptorCount"].(float64))
-			e.TotalPhysicalMemorySize.Set(nameDataMap["TotalPhysicalMemorySize"].(float64))
-			e.FreePhysicalMemorySize.Set(nameDataMap["FreePhysicalMemorySize"].(float64))
-			e.MaxFileDescriptorCount.Set(nameDataMap["MaxFileDescriptorCount"].(float64))
-			e.AvailableProcessors.Set(nameDataMap["AvailableProcessors"].(float64))
+			for key, gauge := range map[string]prometheus.Gauge{
+				"SystemLoadAverage":       e.SystemLoadAverage,
+				"OpenFileDescriptorCount": e.OpenFileDescriptorCount,
+				"TotalPhysicalMemorySize": e.TotalPhysicalMemorySize,
+				"FreePhysicalMemorySize":  e.FreePhysicalMemorySize,
+				"MaxFileDescriptorCount":  e.MaxFileDescriptorCount,
+				"AvailableProcessors":     e.AvailableProcessors,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					gauge.Set(v)
+				}
+			}
+		}
+	}
+	e.VolumeFailures.Set(activity["VolumeFailures"])
+	e.ReadBlockOpAvgTime.Set(activity["ReadBlockOpAvgTime"])
+	e.WriteBlockOpAvgTime.Set(activity["WriteBlockOpAvgTime"])
+	e.WritesFromRemoteClient.Set(activity["WritesFromRemoteClient"])
+	e.WritesFromLocalClient.Set(activity["WritesFromLocalClient"])
+	e.ReadsFromRemoteClient.Set(activity["ReadsFromRemoteClient"])
+	e.ReadsFromLocalClient.Set(activity["ReadsFromLocalClient"])
+	e.DatanodeNetworkErrors.Set(activity["DatanodeNetworkErrors"])
+	if v, ok := activity["BlocksReplicated"]; ok {
+		ch <- prometheus.MustNewConstMetric(e.BlocksReplicated, prometheus.CounterValue, v)
+	}
+	if v, ok := activity["BlocksRemoved"]; ok {
+		ch <- prometheus.MustNewConstMetric(e.BlocksRemoved, prometheus.CounterValue, v)
+	}
+	if v, ok := activity["HeartbeatsTotalNumOps"]; ok {
+		ch <- prometheus.MustNewConstMetric(e.HeartbeatsTotalNumOps, prometheus.CounterValue, v)
+	}
+	if v, ok := activity["HeartbeatsTotalAvgTime"]; ok {
+		ch <- prometheus.MustNewConstMetric(e.HeartbeatsTotalAvgTime, prometheus.GaugeValue, v)
+	}
+	if v, ok := activity["LifelinesNumOps"]; ok && e.c.LifelineEnabled {
+		ch <- prometheus.MustNewConstMetric(e.LifelinesNumOps, prometheus.CounterValue, v)
+	}
+	for key, desc := range map[string]*prometheus.Desc{
+		"IncrementalBlockReportsNumOps": e.IncrementalBlockReportsNumOps,
+		"BlockReportsNumOps":            e.BlockReportsNumOps,
+	} {
+		if v, ok := activity[key]; ok {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v)
+		}
+	}
+	for key, desc := range map[string]*prometheus.Desc{
+		"IncrementalBlockReportsAvgTime": e.IncrementalBlockReportsAvgTime,
+		"BlockReportsAvgTime":            e.BlockReportsAvgTime,
+	} {
+		if v, ok := activity[key]; ok {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+		}
+	}
+	for key, desc := range map[string][2]*prometheus.Desc{
+		"BytesRead":    {e.BytesRead, e.ReadThroughputBytesPerSec},
+		"BytesWritten": {e.BytesWritten, e.WriteThroughputBytesPerSec},
+	} {
+		v, ok := activity[key]
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(desc[0], prometheus.CounterValue, v)
+		if !*deriveThroughput {
+			continue
 		}
+		if rate, ok := e.bytesPerSecond(key, v); ok {
+			ch <- prometheus.MustNewConstMetric(desc[1], prometheus.GaugeValue, rate)
+		}
+	}
+	// 关闭块扫描时这两个值一直为0，不输出，避免误以为扫描正常但没有校验任何块
+	if v, ok := activity["BlocksVerified"]; ok && !e.c.BlockScannerDisabled {
+		ch <- prometheus.MustNewConstMetric(e.BlocksVerified, prometheus.CounterValue, v)
+		ch <- prometheus.MustNewConstMetric(e.BlocksVerifiedInLastHour, prometheus.GaugeValue, e.blocksVerifiedInLastHour(v))
+	}
+	if v, ok := activity["BlockVerificationFailures"]; ok && !e.c.BlockScannerDisabled {
+		ch <- prometheus.MustNewConstMetric(e.BlockVerificationFailures, prometheus.CounterValue, v)
+	}
+	if v, ok := activity["WriteBlockOpNumOps"]; ok {
+		ch <- prometheus.MustNewConstMetric(e.WriteBlockOpNumOps, prometheus.CounterValue, v)
+	}
+	if v, ok := activity["ReadBlockOpNumOps"]; ok {
+		ch <- prometheus.MustNewConstMetric(e.ReadBlockOpNumOps, prometheus.CounterValue, v)
+	}
+	if v, ok := activity["FsyncNanosAvgTime"]; ok {
+		ch <- prometheus.MustNewConstMetric(e.FsyncNanosAvgTime, prometheus.GaugeValue, v)
+	}
+	for key, v := range activity {
+		s := opPercentileRegexp.FindStringSubmatch(key)
+		if s == nil {
+			continue
+		}
+		p, _ := strconv.ParseFloat(s[3], 64)
+		quantile := strconv.FormatFloat(p/100, 'f', -1, 64)
+		desc := e.ReadBlockOpPercentileLatency
+		switch s[1] {
+		case "WriteBlockOp":
+			desc = e.WriteBlockOpPercentileLatency
+		case "FsyncNanos":
+			desc = e.FsyncNanosPercentileLatency
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, quantile, s[2])
+	}
+	if *blockOpHistogram {
+		e.observeBlockOps("ReadBlockOp", e.ReadBlockOpLatency, activity)
+		e.observeBlockOps("WriteBlockOp", e.WriteBlockOpLatency, activity)
+		e.ReadBlockOpLatency.Collect(ch)
+		e.WriteBlockOpLatency.Collect(ch)
 	}
 	e.ServerActive.Set(1)
+	e.collectVolumeSpace(volumes, capacityFound, ch)
+	ch <- prometheus.MustNewConstMetric(e.ToleratedFailedVolumes, prometheus.GaugeValue, e.c.FailedVolumesTolerated)
+	if version != "" {
+		ch <- prometheus.MustNewConstMetric(e.HadoopInfo, prometheus.GaugeValue, 1, hostname, version, hadoopVersion)
+	}
 	e.VolumeFailures.Collect(ch)
 	e.CapacityTotal.Collect(ch)
 	e.CapacityUsed.Collect(ch)
@@ -434,20 +1294,263 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	e.ServerActive.Collect(ch)
 }
 
-func main() {
-	flag.Parse()
-	log.Info("Hadoop Exporter make By Lijiadong(Meepod) (๑•̀ㅂ•́)و✧")
-	conf := CreateHDFSConf(ReadXml(*clientConfFile))
+// 累计值的采样，用于根据两次采集之间的增量计算指标
+type counterSample struct {
+	time  time.Time
+	value float64
+}
+
+// 记录本次的BlocksVerified并返回最近一小时的增量，值变小说明DataNode重启，丢弃之前的采样
+func (e *Exporter) blocksVerifiedInLastHour(v float64) float64 {
+	e.verifiedMutex.Lock()
+	defer e.verifiedMutex.Unlock()
+	now := time.Now()
+	if n := len(e.verifiedSamples); n > 0 && v < e.verifiedSamples[n-1].value {
+		e.verifiedSamples = nil
+	}
+	e.verifiedSamples = append(e.verifiedSamples, counterSample{time: now, value: v})
+	// 保留一小时前的最后一个采样作为基线
+	for len(e.verifiedSamples) > 1 && now.Sub(e.verifiedSamples[1].time) >= time.Hour {
+		e.verifiedSamples = e.verifiedSamples[1:]
+	}
+	return v - e.verifiedSamples[0].value
+}
+
+// 根据与上次采集的差值和间隔计算每秒字节数，首次采集或累计值变小（DataNode重启）时没有结果
+func (e *Exporter) bytesPerSecond(key string, v float64) (float64, bool) {
+	e.throughputMutex.Lock()
+	defer e.throughputMutex.Unlock()
+	now := time.Now()
+	last, ok := e.lastBytes[key]
+	e.lastBytes[key] = counterSample{time: now, value: v}
+	elapsed := now.Sub(last.time).Seconds()
+	if !ok || v < last.value || elapsed <= 0 {
+		return 0, false
+	}
+	return (v - last.value) / elapsed, true
+}
+
+// 把两次采集之间新增的读写块操作记入直方图，有分位数时按分位数分配各次操作的耗时，否则都记为平均耗时
+// 首次采集或次数变小（DataNode重启）时只记录基线
+func (e *Exporter) observeBlockOps(op string, h prometheus.Histogram, activity map[string]float64) {
+	numOps, ok := activity[op+"NumOps"]
+	if !ok {
+		return
+	}
+	e.histogramMutex.Lock()
+	last, ok := e.lastBlockOps[op]
+	e.lastBlockOps[op] = numOps
+	e.histogramMutex.Unlock()
+	if !ok || numOps <= last {
+		return
+	}
+	// 采集中断一段时间后增量可能有几百万，按比例缩减，此时直方图的_count小于实际次数，准确的次数见*NumOps
+	n := int(math.Min(numOps-last, maxBlockOpObservations))
+	// 多个统计周期时使用最短的周期，与本次增量的时间范围最接近
+	type percentile struct{ p, v float64 }
+	var percentiles []percentile
+	interval := 0
+	for key, v := range activity {
+		s := opPercentileRegexp.FindStringSubmatch(key)
+		if s == nil || s[1] != op {
+			continue
+		}
+		i, _ := strconv.Atoi(s[2])
+		p, _ := strconv.ParseFloat(s[3], 64)
+		if interval == 0 || i < interval {
+			interval = i
+			percentiles = nil
+		}
+		if i == interval {
+			percentiles = append(percentiles, percentile{p, v})
+		}
+	}
+	if len(percentiles) == 0 {
+		avg, ok := activity[op+"AvgTime"]
+		if !ok {
+			return
+		}
+		for i := 0; i < n; i++ {
+			h.Observe(avg)
+		}
+		return
+	}
+	// 例如50、75、90、95、99分位数，50%的操作记为p50，25%记为p75，依此类推，剩余的记为最大的分位数
+	sort.Slice(percentiles, func(i, j int) bool { return percentiles[i].p < percentiles[j].p })
+	observed, prev := 0, 0.0
+	for _, q := range percentiles {
+		count := int(math.Round(float64(n) * (q.p - prev) / 100))
+		for i := 0; i < count && observed < n; i++ {
+			h.Observe(q.v)
+			observed++
+		}
+		prev = q.p
+	}
+	for ; observed < n; observed++ {
+		h.Observe(percentiles[len(percentiles)-1].v)
+	}
+}
+
+// 从DataNodeInfo中读取版本，Version带有编译信息，SoftwareVersion只有版本号，旧版本没有SoftwareVersion
+func versionInfo(bean map[string]interface{}) (version, hadoopVersion string) {
+	version, _ = bean["Version"].(string)
+	hadoopVersion, _ = bean["SoftwareVersion"].(string)
+	if hadoopVersion == "" {
+		hadoopVersion = strings.TrimSpace(strings.Split(version, ",")[0])
+	}
+	return version, hadoopVersion
+}
+
+// 毫秒时间戳距离本次采集的秒数
+func ageSeconds(epochMs float64) float64 {
+	return float64(time.Now().UnixNano())/1e9 - epochMs/1000
+}
+
+// 最近一次采集时后端是否可达
+func (e *Exporter) up() bool {
+	var m dto.Metric
+	if err := e.ServerActive.Write(&m); err != nil {
+		return false
+	}
+	return m.GetGauge().GetValue() == 1
+}
+
+// 采集一次指标并以文本格式输出到标准输出，与服务模式使用同一个已注册的采集器
+func oneshot(exporter *Exporter) error {
+	mfs, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(os.Stdout, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	if !exporter.up() {
+		return fmt.Errorf("backend %s is unreachable", exporter.url)
+	}
+	return nil
+}
+
+// 配置文件读取成功并注册采集器后为true，用于/ready
+var ready atomic.Bool
+
+// 环境变量中的配置优先于XML配置
+func applyEnvConf(c *HDFSConf) {
+	for env, v := range map[string]*string{
+		"HADOOP_EXPORTER_RPC_PORT":   &c.RpcPort,
+		"HADOOP_EXPORTER_HTTP_PORT":  &c.HttpPort,
+		"HADOOP_EXPORTER_HTTPS_PORT": &c.HttpsPort,
+	} {
+		if s, ok := os.LookupEnv(env); ok {
+			*v = s
+		}
+	}
+	if s, ok := os.LookupEnv("HADOOP_EXPORTER_HTTPS"); ok {
+		c.HttpsOpen = s == "true"
+	}
+}
+
+// 根据配置生成采集器
+func createExporter(conf *HDFSConf) *Exporter {
 	datanodeJmxUrl := ""
 	if conf.HttpsOpen {
-		datanodeJmxUrl = "https://" + conf.ServerIP + ":" + conf.HttpsPort + "/jmx"
+		datanodeJmxUrl = "https://" + net.JoinHostPort(conf.ServerIP, conf.HttpsPort) + *jmxPath
 	} else {
-		datanodeJmxUrl = "http://" + conf.ServerIP + ":" + conf.HttpPort + "/jmx"
+		datanodeJmxUrl = "http://" + net.JoinHostPort(conf.ServerIP, conf.HttpPort) + *jmxPath
+	}
+	return NewExporter(datanodeJmxUrl, conf, *metricNamespace)
+}
+
+// 收到SIGHUP时重新读取配置，配置变化可能导致标签变化，因此重新注册采集器
+func reloadOnSIGHUP(exporter *Exporter) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		xmlConf, err := common.ReadXmlOrEmpty(*clientConfFile)
+		if err != nil {
+			log.Errorf("Reload config failed: %s", err)
+			continue
+		}
+		newExporter := createExporter(CreateHDFSConf(xmlConf))
+		prometheus.Unregister(exporter)
+		if err := prometheus.Register(newExporter); err != nil {
+			log.Errorf("Register reloaded exporter failed: %s", err)
+			prometheus.Register(exporter)
+			continue
+		}
+		exporter = newExporter
+		log.Info("Config reloaded")
+	}
+}
+
+// /metrics的处理器，同时处理的采集请求超过web.max-requests时返回503
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{MaxRequestsInFlight: *maxRequests}))
+}
+
+// 运行exporter，args为子命令之后的参数
+func Main(args []string) {
+	flags.Parse(args)
+	common.FlagsFromEnv(flags)
+	log.Info("Hadoop Exporter make By Lijiadong(Meepod) (๑•̀ㅂ•́)و✧")
+	// 等待配置期间先启动HTTP服务，/ready返回503，配置读取成功后才注册/metrics
+	serveErr := make(chan error, 1)
+	if *configWait > 0 && !*oneshotMode {
+		go func() {
+			serveErr <- serve()
+		}()
 	}
-	exporter := NewExporter(datanodeJmxUrl, conf)
+	xmlConf, err := common.ReadXmlWithWait(*clientConfFile, *configWait)
+	if err != nil {
+		log.Fatal(err)
+	}
+	transport, err = common.NewTransport(*httpProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *relabelConfigFile != "" {
+		gatherer, err = common.RelabelGatherer(prometheus.DefaultGatherer, *relabelConfigFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	conf := CreateHDFSConf(xmlConf)
+	exporter := createExporter(conf)
 	prometheus.MustRegister(exporter)
-	log.Printf("Starting Server: %s", *listenAddress)
-	http.Handle(*metricsPath, prometheus.Handler())
+	if *oneshotMode {
+		if err := oneshot(exporter); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	go reloadOnSIGHUP(exporter)
+	log.Printf("Starting Server: %s", listenAddress)
+	if *pushGateway != "" {
+		go common.PushMetrics(*pushGateway, *pushInterval, "datanode", conf.ServerIP, gatherer)
+	}
+	http.Handle(*metricsPath, metricsHandler())
+	ready.Store(true)
+	if *configWait > 0 {
+		err = <-serveErr
+	} else {
+		err = serve()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// 启动HTTP服务，/metrics在配置读取成功后单独注册
+func serve() error {
+	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "config is not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK"))
+	})
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 		<head><title>DataNode Exporter</title></head>
@@ -458,8 +1561,11 @@ func main() {
 		</body>
 		</html>`))
 	})
-	err := http.ListenAndServe(*listenAddress, nil)
-	if err != nil {
-		log.Fatal(err)
+	server := &http.Server{}
+	flagConfig := &web.FlagConfig{
+		WebListenAddresses: &listenAddress.Values,
+		WebSystemdSocket:   systemdSocket,
+		WebConfigFile:      webConfigFile,
 	}
+	return web.ListenAndServe(server, flagConfig, kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr)))
 }
@@ -0,0 +1,291 @@
+package datanode
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"hadoop_exporter/internal/common"
+	"hadoop_exporter/internal/testutil"
+)
+
+func TestCollectMixedStringNumber(t *testing.T) {
+	srv := testutil.NewJMXServer(t, `{"beans":[
+		{"name":"Hadoop:service=DataNode,name=DataNodeInfo","XceiverCount":"7"},
+		{"name":"Hadoop:service=DataNode,name=RpcActivityForPort8010","RpcQueueTimeNumOps":"12","NumOpenConnections":3},
+		{"name":"java.lang:type=Memory","HeapMemoryUsage":{"committed":100,"init":"50","max":200,"used":"80"}},
+		{"name":"java.lang:type=Runtime","StartTime":"1700000000000"},
+		{"name":"java.lang:type=OperatingSystem","SystemLoadAverage":"0.5","OpenFileDescriptorCount":42}
+	]}`)
+	e := NewExporter(srv.URL, &HDFSConf{ServerIP: "127.0.0.1", RpcPort: "8010"}, "")
+	mfs := testutil.Gather(t, e)
+	for name, want := range map[string]float64{
+		"DataNode_XceiverCount":            7,
+		"DataNode_RpcQueueTimeNumOps":      12,
+		"DataNode_heapMemoryUsageUsed":     80,
+		"DataNode_StartTime":               1700000000000,
+		"DataNode_SystemLoadAverage":       0.5,
+		"DataNode_OpenFileDescriptorCount": 42,
+	} {
+		if got, ok := testutil.MetricValue(mfs, name, nil); !ok || got != want {
+			t.Errorf("%s = %v (found %v), want %v", name, got, ok, want)
+		}
+	}
+}
+
+func TestCollectMissingHeapMemoryUsage(t *testing.T) {
+	srv := testutil.NewJMXServer(t, `{"beans":[{"name":"java.lang:type=Memory","HeapMemoryUsage":"n/a"}]}`)
+	e := NewExporter(srv.URL, &HDFSConf{ServerIP: "127.0.0.1"}, "")
+	testutil.Gather(t, e)
+}
+
+// 包含Collect中处理的所有bean
+const dataNodeJMX = `{"beans":[
+	{"name":"Hadoop:service=DataNode,name=DataNodeInfo","tag.Hostname":"dn1","Version":"3.1.1, rabc","SoftwareVersion":"3.1.1","XceiverCount":5,
+		"VolumeInfo":"{\"/data1/dfs/dn/current\":{\"usedSpace\":100,\"freeSpace\":900,\"reservedSpace\":0},\"/data2/dfs/dn/current\":{\"usedSpace\":200,\"freeSpace\":800,\"reservedSpace\":0}}"},
+	{"name":"Hadoop:service=DataNode,name=FSDatasetState","Capacity":2000,"DfsUsed":300,"Remaining":1700,"NumFailedVolumes":0,"BlockPoolUsed":{"BP-1":300}},
+	{"name":"Hadoop:service=DataNode,name=DataNodeActivity-dn1-9866","VolumeFailures":0,"ReadBlockOpNumOps":10,"ReadBlockOpAvgTime":1,"WriteBlockOpNumOps":20,"WriteBlockOpAvgTime":2,
+		"WritesFromRemoteClient":3,"WritesFromLocalClient":4,"ReadsFromRemoteClient":5,"ReadsFromLocalClient":6,"DatanodeNetworkErrors":0,"BlocksReplicated":1,"BlocksRemoved":2,
+		"HeartbeatsTotalNumOps":100,"HeartbeatsTotalAvgTime":0.5,"LifelinesNumOps":10,"IncrementalBlockReportsNumOps":7,"IncrementalBlockReportsAvgTime":0.1,"BlockReportsNumOps":1,"BlockReportsAvgTime":20,
+		"BytesRead":1024,"BytesWritten":2048,"BlocksVerified":8,"BlockVerificationFailures":0,"FsyncNanosNumOps":3,"FsyncNanosAvgTime":1000,
+		"ReadBlockOp60s99thPercentileLatency":5,"WriteBlockOp60s99thPercentileLatency":8,"FsyncNanos60s99thPercentileLatency":2000},
+	{"name":"Hadoop:service=DataNode,name=DataNodeVolume-/data1/dfs/dn","ReadIoMeanTime":1,"WriteIoMeanTime":2},
+	{"name":"Hadoop:service=DataNode,name=RpcActivityForPort8010","RpcQueueTimeNumOps":10,"RpcQueueTimeAvgTime":0.1,"RpcProcessingTimeNumOps":10,"RpcProcessingTimeAvgTime":0.2,
+		"ReceivedBytes":100,"SentBytes":200,"NumOpenConnections":1},
+	{"name":"Hadoop:service=DataNode,name=JvmMetrics","LogError":0,"GcCount":6,"GcTimeMillis":150},
+	{"name":"java.lang:type=Memory","HeapMemoryUsage":{"committed":100,"init":50,"max":200,"used":80}},
+	{"name":"java.lang:type=Runtime","StartTime":1700000000000},
+	{"name":"java.lang:type=OperatingSystem","SystemLoadAverage":0.5,"OpenFileDescriptorCount":100,"TotalPhysicalMemorySize":1000,"FreePhysicalMemorySize":500,"MaxFileDescriptorCount":65536,"AvailableProcessors":4}
+]}`
+
+// 开启所有可选指标后，采集到的指标都要在Describe中声明
+func TestPedanticRegistry(t *testing.T) {
+	testutil.SetFlag(t, flags, "time.as-age", "true")
+	testutil.SetFlag(t, flags, "datanode.slow-disk-threshold-ms", "10")
+	testutil.SetFlag(t, flags, "datanode.derive-throughput", "true")
+	testutil.SetFlag(t, flags, "datanode.block-op-histogram", "true")
+	srv := testutil.NewJMXServer(t, dataNodeJMX)
+	e := NewExporter(srv.URL+"/jmx", &HDFSConf{RpcPort: "8010", ServerIP: "127.0.0.1", LifelineEnabled: true}, "")
+	// 两次采集，覆盖根据增量计算的指标
+	testutil.Gather(t, e)
+	mfs := testutil.Gather(t, e)
+	for name, want := range map[string]float64{
+		"DataNode_ServerActive":  1,
+		"DataNode_XceiverCount":  5,
+		"DataNode_CapacityTotal": 2000,
+	} {
+		if got, ok := testutil.MetricValue(mfs, name, nil); !ok || got != want {
+			t.Errorf("%s = %v (found %v), want %v", name, got, ok, want)
+		}
+	}
+}
+
+// 设置--metric.namespace后所有指标都带前缀，包括hadoop_exporter_*等自身的指标
+func TestMetricNamespace(t *testing.T) {
+	srv := testutil.NewJMXServer(t, dataNodeJMX)
+	e := NewExporter(srv.URL+"/jmx", &HDFSConf{RpcPort: "8010", ServerIP: "127.0.0.1"}, "test")
+	testutil.CheckNamespace(t, testutil.Gather(t, e), "test")
+}
+
+// 同时处理的请求超过web.max-requests时直接返回503
+func TestMaxRequests(t *testing.T) {
+	testutil.SetFlag(t, flags, "web.max-requests", "1")
+	testutil.CheckMaxRequests(t, func(g prometheus.Gatherer) http.Handler {
+		old := gatherer
+		gatherer = g
+		t.Cleanup(func() { gatherer = old })
+		return metricsHandler()
+	})
+}
+
+// 反向代理在请求没有Accept-Encoding时也可能返回gzip压缩的内容，此时需要自行解压
+func TestCollectGzipBody(t *testing.T) {
+	// 关闭Transport的自动解压，模拟Transport没有处理压缩的情况
+	old := transport
+	transport = &http.Transport{DisableCompression: true}
+	t.Cleanup(func() { transport = old })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(dataNodeJMX))
+		gz.Close()
+	}))
+	t.Cleanup(srv.Close)
+	mfs := testutil.Gather(t, NewExporter(srv.URL+"/jmx", &HDFSConf{RpcPort: "8010", ServerIP: "127.0.0.1"}, ""))
+	if v, ok := testutil.MetricValue(mfs, "DataNode_CapacityTotal", nil); !ok || v != 2000 {
+		t.Errorf("DataNode_CapacityTotal = %v (found %v), want 2000", v, ok)
+	}
+}
+
+// 配置缺失或没有端口时按Hadoop版本使用默认端口
+func TestCreateHDFSConfDefaultPorts(t *testing.T) {
+	testutil.SetFlag(t, flags, "local.ip", "127.0.0.1")
+	conf := func(kv ...string) *common.XMLConf {
+		x := &common.XMLConf{}
+		for i := 0; i < len(kv); i += 2 {
+			x.NameValue = append(x.NameValue, common.NameValue{Name: kv[i], Value: kv[i+1]})
+		}
+		return x
+	}
+	for _, c := range []struct {
+		name                         string
+		conf                         *common.XMLConf
+		https                        bool
+		httpPort, httpsPort, ipcPort string
+	}{
+		{"empty", conf(), false, "9864", "", "9867"},
+		{"no port", conf("dfs.datanode.http.address", "0.0.0.0", "dfs.datanode.ipc.address", "0.0.0.0:"), false, "9864", "", "9867"},
+		{"hadoop2 no port", conf("dfs.datanode.address", "0.0.0.0:50010", "dfs.datanode.http.address", "dn1"), false, "50075", "", "50020"},
+		{"https no port", conf("dfs.http.policy", "HTTPS_ONLY", "dfs.datanode.https.address", "0.0.0.0"), true, "", "9865", "9867"},
+		{"configured", conf("dfs.datanode.http.address", "0.0.0.0:1006", "dfs.datanode.ipc.address", "0.0.0.0:1019"), false, "1006", "", "1019"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got := CreateHDFSConf(c.conf)
+			if got.HttpsOpen != c.https || got.HttpPort != c.httpPort || got.HttpsPort != c.httpsPort || got.RpcPort != c.ipcPort {
+				t.Errorf("CreateHDFSConf() = https %v, ports %q %q %q, want %v, %q %q %q",
+					got.HttpsOpen, got.HttpPort, got.HttpsPort, got.RpcPort, c.https, c.httpPort, c.httpsPort, c.ipcPort)
+			}
+		})
+	}
+}
+
+// HA时lifeline地址的配置项带有nameservice和namenode后缀
+func TestCreateHDFSConf(t *testing.T) {
+	testutil.SetFlag(t, flags, "local.ip", "127.0.0.1")
+	conf := func(kv ...string) *common.XMLConf {
+		x := &common.XMLConf{}
+		for i := 0; i < len(kv); i += 2 {
+			x.NameValue = append(x.NameValue, common.NameValue{Name: kv[i], Value: kv[i+1]})
+		}
+		return x
+	}
+	for _, c := range []struct {
+		name string
+		conf *common.XMLConf
+		want bool
+	}{
+		{"no lifeline", conf("dfs.nameservices", "ns1", "dfs.ha.namenodes.ns1", "nn1,nn2"), false},
+		{"non-HA", conf("dfs.namenode.lifeline.rpc-address", "nn1:8050"), true},
+		{"HA", conf("dfs.nameservices", "ns1", "dfs.ha.namenodes.ns1", "nn1, nn2",
+			"dfs.namenode.lifeline.rpc-address.ns1.nn2", "nn2:8050"), true},
+		{"HA internal nameservices", conf("dfs.nameservices", "ns1,ns2", "dfs.internal.nameservices", "ns2",
+			"dfs.ha.namenodes.ns2", "nn1", "dfs.namenode.lifeline.rpc-address.ns2.nn1", "nn1:8050"), true},
+		{"HA other nameservice", conf("dfs.nameservices", "ns1,ns2", "dfs.internal.nameservices", "ns1",
+			"dfs.ha.namenodes.ns1", "nn1", "dfs.ha.namenodes.ns2", "nn1", "dfs.namenode.lifeline.rpc-address.ns2.nn1", "nn1:8050"), false},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			if got := CreateHDFSConf(c.conf).LifelineEnabled; got != c.want {
+				t.Errorf("LifelineEnabled = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// IPv6地址需要加上方括号
+func TestCreateExporterIPv6(t *testing.T) {
+	if e := createExporter(&HDFSConf{ServerIP: "fe80::1", HttpPort: "9864"}); e.url != "http://[fe80::1]:9864/jmx" {
+		t.Errorf("url = %q", e.url)
+	}
+	if e := createExporter(&HDFSConf{ServerIP: "fe80::1", HttpsOpen: true, HttpsPort: "9865"}); e.url != "https://[fe80::1]:9865/jmx" {
+		t.Errorf("url = %q", e.url)
+	}
+	testutil.SetFlag(t, flags, "local.ip", "fe80::1")
+	for addr, want := range map[string]string{
+		"[::]:1006": "1006",
+		"[::1]":     "9864",
+	} {
+		c := CreateHDFSConf(&common.XMLConf{NameValue: []common.NameValue{{Name: "dfs.datanode.http.address", Value: addr}}})
+		if c.HttpPort != want {
+			t.Errorf("HttpPort for %s = %q, want %q", addr, c.HttpPort, want)
+		}
+	}
+}
+
+// JMX无响应时请求在get.timeout-seconds后返回，DataNode_ServerActive为0
+func TestCollectClientTimeout(t *testing.T) {
+	testutil.SetFlag(t, flags, "get.timeout-seconds", "1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(srv.Close)
+	start := time.Now()
+	mfs := testutil.Gather(t, NewExporter(srv.URL+"/jmx", &HDFSConf{ServerIP: "127.0.0.1"}, ""))
+	if d := time.Since(start); d > 4*time.Second {
+		t.Errorf("collect took %s, want about 1s", d)
+	}
+	if v, ok := testutil.MetricValue(mfs, "DataNode_ServerActive", nil); !ok || v != 0 {
+		t.Errorf("DataNode_ServerActive = %v (found %v), want 0", v, ok)
+	}
+}
+
+// 多个DataNodeActivity bean（例如多端口或主机名与DataNodeInfo不一致）时累加计数，平均耗时取最大值
+func TestCollectDataNodeActivityAggregate(t *testing.T) {
+	srv := testutil.NewJMXServer(t, `{"beans":[
+		{"name":"Hadoop:service=DataNode,name=DataNodeInfo","DatanodeHostname":"dn1.example.com","DataPort":"9866"},
+		{"name":"Hadoop:service=DataNode,name=DataNodeActivity-dn1.example.com-9866","WritesFromRemoteClient":3,"ReadBlockOpAvgTime":2,"BlocksRemoved":1},
+		{"name":"Hadoop:service=DataNode,name=DataNodeActivity-dn1-internal-1004","WritesFromRemoteClient":"4","ReadBlockOpAvgTime":5,"BlocksRemoved":2}
+	]}`)
+	mfs := testutil.Gather(t, NewExporter(srv.URL+"/jmx", &HDFSConf{ServerIP: "127.0.0.1"}, ""))
+	for name, want := range map[string]float64{
+		"DataNode_WritesFromRemoteClient": 7,
+		"DataNode_ReadBlockOpAvgTime":     5,
+		"DataNode_BlocksRemoved_total":    3,
+	} {
+		if got, ok := testutil.MetricValue(mfs, name, nil); !ok || got != want {
+			t.Errorf("%s = %v (found %v), want %v", name, got, ok, want)
+		}
+	}
+}
+
+// 指定--local.ip后不解析主机名，原样用于serverip标签
+func TestCreateHDFSConfLocalIP(t *testing.T) {
+	testutil.SetFlag(t, flags, "local.ip", "10.1.2.3")
+	if got := CreateHDFSConf(&common.XMLConf{}).ServerIP; got != "10.1.2.3" {
+		t.Errorf("ServerIP = %q, want 10.1.2.3", got)
+	}
+}
+
+// FSDatasetState缺少Capacity时用VolumeInfo中各目录的空间求和，已有的DfsUsed保持不变
+func TestCollectVolumeCapacitySum(t *testing.T) {
+	for name, volumeInfo := range map[string]string{
+		"string": `"{\"/data1\":{\"usedSpace\":100,\"freeSpace\":900,\"reservedSpace\":10},\"/data2\":{\"usedSpace\":50,\"freeSpace\":450,\"reservedSpace\":10}}"`,
+		"object": `{"/data1":{"usedSpace":100,"freeSpace":900,"reservedSpace":10},"/data2":{"usedSpace":"50","freeSpace":450}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv := testutil.NewJMXServer(t, `{"beans":[
+				{"name":"Hadoop:service=DataNode,name=DataNodeInfo","VolumeInfo":`+volumeInfo+`},
+				{"name":"Hadoop:service=DataNode,name=FSDatasetState","DfsUsed":160}
+			]}`)
+			mfs := testutil.Gather(t, NewExporter(srv.URL+"/jmx", &HDFSConf{ServerIP: "127.0.0.1"}, ""))
+			for name, want := range map[string]float64{
+				"DataNode_CapacityTotal":     1500,
+				"DataNode_CapacityRemaining": 1350,
+				"DataNode_CapacityUsed":      160,
+			} {
+				if got, ok := testutil.MetricValue(mfs, name, nil); !ok || got != want {
+					t.Errorf("%s = %v (found %v), want %v", name, got, ok, want)
+				}
+			}
+			if got, ok := testutil.MetricValue(mfs, "DataNode_VolumeUsedSpace", map[string]string{"volume": "/data2"}); !ok || got != 50 {
+				t.Errorf("DataNode_VolumeUsedSpace{volume=/data2} = %v (found %v), want 50", got, ok)
+			}
+		})
+	}
+}
+
+// 超过collect.timeout时取消未完成的请求，采集在超时后返回，不会等到get.timeout-seconds
+func TestCollectTimeoutCancelsRequests(t *testing.T) {
+	testutil.SetFlag(t, flags, "collect.timeout", "300ms")
+	testutil.SetFlag(t, flags, "get.timeout-seconds", "30")
+	testutil.CheckCollectTimeout(t, func(url string) prometheus.Collector {
+		return NewExporter(url+"/jmx", &HDFSConf{ServerIP: "127.0.0.1", RpcPort: "8010"}, "")
+	})
+}
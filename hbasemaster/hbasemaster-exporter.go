@@ -0,0 +1,153 @@
+package hbasemaster
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"hadoop_exporter/internal/common"
+	"hadoop_exporter/internal/hbase"
+)
+
+const (
+	defaultInfoPort = "16010" // Master默认的info端口
+)
+
+// 子命令，参数、HTTP服务和JMX请求与RegionServer共用
+var cmd = hbase.NewCommand(hbase.Role{
+	Name:            "hbasemaster",
+	Title:           "HBase Master",
+	MetricPrefix:    "Master",
+	InfoPortKey:     "hbase.master.info.port",
+	DefaultInfoPort: defaultInfoPort,
+	ListenPort:      "9078",
+	JMXQuery:        "Hadoop:service=HBase,name=Master,*",
+	JMXPathExample:  "/gateway/default/hbase/webui/jmx",
+	NewMetrics:      newMetrics,
+})
+
+// 子命令的参数，由hadoop-exporter hbasemaster之后的参数解析
+var flags = cmd.Flags
+
+type HBaseConf = hbase.Conf
+
+type metrics struct {
+	// Master指标 "name": "Hadoop:service=HBase,name=Master,sub=Server"
+	isActive             *prometheus.Desc // 是否是Active的，backup master为0
+	numRegionServers     *prometheus.Desc // 存活的RegionServer数量
+	numDeadRegionServers *prometheus.Desc // 死亡的RegionServer数量
+	// RIT指标，只有active master有 "name": "Hadoop:service=HBase,name=Master,sub=AssignmentManager"
+	ritCount              *prometheus.Desc // 处于RIT的region数量
+	ritCountOverThreshold *prometheus.Desc // RIT时间超过hbase.metrics.rit.stuck.warning.threshold的region数量
+	ritOldestAge          *prometheus.Desc // 最久的RIT持续时间，单位为毫秒
+}
+
+// 生成采集器使用的配置项
+func CreateHBaseConf(e *common.XMLConf) *HBaseConf {
+	return cmd.CreateConf(e)
+}
+
+// 创建指标
+func NewExporter(url string, c *HBaseConf, namespace string) *hbase.Exporter {
+	return cmd.NewExporter(url, c, namespace)
+}
+
+// 指标格式定义：metrics_name{serverip="10.30.108.2"}
+func newMetrics(namespace, serverIP string) hbase.Metrics {
+	return &metrics{
+		isActive: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hbase_master_is_active"),
+			"Whether the master is the active master, 1 active, 0 backup",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+		numRegionServers: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "Master_numRegionServers"),
+			"numRegionServers",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+		numDeadRegionServers: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "Master_numDeadRegionServers"),
+			"numDeadRegionServers",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+		ritCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "Master_ritCount"),
+			"ritCount",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+		ritCountOverThreshold: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "Master_ritCountOverThreshold"),
+			"ritCountOverThreshold",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+		ritOldestAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "Master_ritOldestAge"),
+			"ritOldestAge",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+	}
+}
+
+// 定义指标的描述
+func (m *metrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.isActive
+	ch <- m.numRegionServers
+	ch <- m.numDeadRegionServers
+	ch <- m.ritCount
+	ch <- m.ritCountOverThreshold
+	ch <- m.ritOldestAge
+}
+
+// 处理JMX返回的bean，返回没有处理的bean名称
+func (m *metrics) Collect(beans []map[string]interface{}, ch chan<- prometheus.Metric) []string {
+	active := false
+	var assignment map[string]interface{}
+	var unmatched []string
+	for _, bean := range beans {
+		switch bean["name"] {
+		case "Hadoop:service=HBase,name=Master,sub=Server":
+			// tag.isActiveMaster是字符串"true"或"false"
+			active = bean["tag.isActiveMaster"] == "true"
+			isActive := 0.0
+			if active {
+				isActive = 1
+			}
+			ch <- prometheus.MustNewConstMetric(m.isActive, prometheus.GaugeValue, isActive)
+			if v, ok := common.GetFloat(bean, "numRegionServers"); ok {
+				ch <- prometheus.MustNewConstMetric(m.numRegionServers, prometheus.GaugeValue, v)
+			}
+			if v, ok := common.GetFloat(bean, "numDeadRegionServers"); ok {
+				ch <- prometheus.MustNewConstMetric(m.numDeadRegionServers, prometheus.GaugeValue, v)
+			}
+		// HBase 1.x中bean名称拼写为AssignmentManger
+		case "Hadoop:service=HBase,name=Master,sub=AssignmentManager", "Hadoop:service=HBase,name=Master,sub=AssignmentManger":
+			assignment = bean
+		default:
+			name, _ := bean["name"].(string)
+			unmatched = append(unmatched, name)
+		}
+	}
+	// backup master没有分配信息，不输出RIT指标
+	if !active || assignment == nil {
+		return unmatched
+	}
+	for key, desc := range map[string]*prometheus.Desc{
+		"ritCount":              m.ritCount,
+		"ritCountOverThreshold": m.ritCountOverThreshold,
+		"ritOldestAge":          m.ritOldestAge,
+	} {
+		if v, ok := common.GetFloat(assignment, key); ok {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+		}
+	}
+	return unmatched
+}
+
+// 运行exporter，args为子命令之后的参数
+func Main(args []string) {
+	cmd.Main(args)
+}
@@ -0,0 +1,60 @@
+package hbasemaster
+
+import (
+	"testing"
+
+	"hadoop_exporter/internal/common"
+	"hadoop_exporter/internal/testutil"
+)
+
+// 包含Collect中处理的所有bean
+const masterJMX = `{"beans":[
+	{"name":"Hadoop:service=HBase,name=Master,sub=Server","tag.isActiveMaster":"true","numRegionServers":3,"numDeadRegionServers":0},
+	{"name":"Hadoop:service=HBase,name=Master,sub=AssignmentManager","ritCount":1,"ritCountOverThreshold":0,"ritOldestAge":100}
+]}`
+
+// 采集到的指标都要在Describe中声明
+func TestPedanticRegistry(t *testing.T) {
+	srv := testutil.NewJMXServer(t, masterJMX)
+	mfs := testutil.Gather(t, NewExporter(srv.URL+"/jmx", &HBaseConf{ServerIP: "127.0.0.1"}, ""))
+	for name, want := range map[string]float64{
+		"Master_ServerActive":    1,
+		"hbase_master_is_active": 1,
+		"Master_ritCount":        1,
+	} {
+		if got, ok := testutil.MetricValue(mfs, name, nil); !ok || got != want {
+			t.Errorf("%s = %v (found %v), want %v", name, got, ok, want)
+		}
+	}
+}
+
+// 设置--metric.namespace后所有指标都带前缀，包括hadoop_exporter_*等自身的指标
+func TestMetricNamespace(t *testing.T) {
+	srv := testutil.NewJMXServer(t, masterJMX)
+	e := NewExporter(srv.URL+"/jmx", &HBaseConf{ServerIP: "127.0.0.1"}, "test")
+	testutil.CheckNamespace(t, testutil.Gather(t, e), "test")
+}
+
+// 以该名称开头的其他配置项（例如.auto）排在前面时仍然读取完全匹配的端口
+func TestCreateHBaseConfExactName(t *testing.T) {
+	testutil.SetFlag(t, flags, "local.ip", "127.0.0.1")
+	c := CreateHBaseConf(&common.XMLConf{NameValue: []common.NameValue{
+		{Name: "hbase.master.info.port.auto", Value: "true"},
+		{Name: " hbase.master.info.port ", Value: "16999"},
+	}})
+	if c.InfoPort != "16999" {
+		t.Errorf("InfoPort = %q, want 16999", c.InfoPort)
+	}
+	c = CreateHBaseConf(&common.XMLConf{NameValue: []common.NameValue{{Name: "hbase.master.info.port.auto", Value: "true"}}})
+	if c.InfoPort != defaultInfoPort {
+		t.Errorf("InfoPort = %q, want default %s", c.InfoPort, defaultInfoPort)
+	}
+}
+
+// 指定--local.ip后不解析主机名，原样用于serverip标签
+func TestCreateHBaseConfLocalIP(t *testing.T) {
+	testutil.SetFlag(t, flags, "local.ip", "10.1.2.3")
+	if got := CreateHBaseConf(&common.XMLConf{}).ServerIP; got != "10.1.2.3" {
+		t.Errorf("ServerIP = %q, want 10.1.2.3", got)
+	}
+}
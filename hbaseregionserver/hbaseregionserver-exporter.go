@@ -0,0 +1,153 @@
+package hbaseregionserver
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"hadoop_exporter/internal/common"
+	"hadoop_exporter/internal/hbase"
+)
+
+const (
+	defaultInfoPort = "16030" // RegionServer默认的info端口
+)
+
+// 子命令，参数、HTTP服务和JMX请求与Master共用
+var cmd = hbase.NewCommand(hbase.Role{
+	Name:            "hbaseregionserver",
+	Title:           "HBase RegionServer",
+	MetricPrefix:    "RegionServer",
+	InfoPortKey:     "hbase.regionserver.info.port",
+	DefaultInfoPort: defaultInfoPort,
+	ListenPort:      "9079",
+	JMXQuery:        "Hadoop:service=HBase,name=RegionServer,sub=Server",
+	JMXPathExample:  "/gateway/default/hbase/regionserver/jmx",
+	NewMetrics:      newMetrics,
+})
+
+// 子命令的参数，由hadoop-exporter hbaseregionserver之后的参数解析
+var flags = cmd.Flags
+
+type HBaseConf = hbase.Conf
+
+type metrics struct {
+	// RegionServer指标 "name": "Hadoop:service=HBase,name=RegionServer,sub=Server"
+	regionCount           *prometheus.Desc // region数量
+	storeCount            *prometheus.Desc // store数量
+	memStoreSize          *prometheus.Desc // memstore大小
+	blockCacheHitPercent  *prometheus.Desc // 块缓存命中率
+	readRequestCount      *prometheus.Desc // 读请求数，累计值
+	writeRequestCount     *prometheus.Desc // 写请求数，累计值
+	compactionQueueLength *prometheus.Desc // compaction队列长度
+	flushQueueLength      *prometheus.Desc // flush队列长度
+}
+
+// 生成采集器使用的配置项
+func CreateHBaseConf(e *common.XMLConf) *HBaseConf {
+	return cmd.CreateConf(e)
+}
+
+// 创建指标
+func NewExporter(url string, c *HBaseConf, namespace string) *hbase.Exporter {
+	return cmd.NewExporter(url, c, namespace)
+}
+
+// 指标格式定义：metrics_name{serverip="10.30.108.2"}
+func newMetrics(namespace, serverIP string) hbase.Metrics {
+	return &metrics{
+		regionCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "RegionServer_regionCount"),
+			"regionCount",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+		storeCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "RegionServer_storeCount"),
+			"storeCount",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+		memStoreSize: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "RegionServer_memStoreSize"),
+			"memStoreSize",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+		blockCacheHitPercent: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "RegionServer_blockCacheHitPercent"),
+			"blockCacheHitPercent",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+		readRequestCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "RegionServer_readRequestCount_total"),
+			"readRequestCount",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+		writeRequestCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "RegionServer_writeRequestCount_total"),
+			"writeRequestCount",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+		compactionQueueLength: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "RegionServer_compactionQueueLength"),
+			"compactionQueueLength",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+		flushQueueLength: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "RegionServer_flushQueueLength"),
+			"flushQueueLength",
+			nil,
+			prometheus.Labels{"serverip": serverIP},
+		),
+	}
+}
+
+// 定义指标的描述
+func (m *metrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.regionCount
+	ch <- m.storeCount
+	ch <- m.memStoreSize
+	ch <- m.blockCacheHitPercent
+	ch <- m.readRequestCount
+	ch <- m.writeRequestCount
+	ch <- m.compactionQueueLength
+	ch <- m.flushQueueLength
+}
+
+// 处理JMX返回的bean，返回没有处理的bean名称
+func (m *metrics) Collect(beans []map[string]interface{}, ch chan<- prometheus.Metric) []string {
+	var unmatched []string
+	for _, bean := range beans {
+		if bean["name"] != "Hadoop:service=HBase,name=RegionServer,sub=Server" {
+			name, _ := bean["name"].(string)
+			unmatched = append(unmatched, name)
+			continue
+		}
+		for key, metric := range map[string]struct {
+			desc      *prometheus.Desc
+			valueType prometheus.ValueType
+		}{
+			"regionCount":           {m.regionCount, prometheus.GaugeValue},
+			"storeCount":            {m.storeCount, prometheus.GaugeValue},
+			"memStoreSize":          {m.memStoreSize, prometheus.GaugeValue},
+			"blockCacheHitPercent":  {m.blockCacheHitPercent, prometheus.GaugeValue},
+			"readRequestCount":      {m.readRequestCount, prometheus.CounterValue},
+			"writeRequestCount":     {m.writeRequestCount, prometheus.CounterValue},
+			"compactionQueueLength": {m.compactionQueueLength, prometheus.GaugeValue},
+			"flushQueueLength":      {m.flushQueueLength, prometheus.GaugeValue},
+		} {
+			if v, ok := common.GetFloat(bean, key); ok {
+				ch <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, v)
+			}
+		}
+	}
+	return unmatched
+}
+
+// 运行exporter，args为子命令之后的参数
+func Main(args []string) {
+	cmd.Main(args)
+}
@@ -0,0 +1,35 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 按collect.timeout限制单次采集，超时后ctx被取消，collect中使用ctx的请求随之返回，只输出已采集到的指标
+// timeout为0时不限制，采集结束后按ctx是否超时输出desc
+func CollectWithTimeout(timeout time.Duration, desc *prometheus.Desc, ch chan<- prometheus.Metric, collect func(ctx context.Context)) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	collect(ctx)
+	timedOut := 0.0
+	if ctx.Err() != nil {
+		timedOut = 1
+	}
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, timedOut)
+}
+
+// 发起使用ctx的GET请求，采集超时后请求被取消
+func Get(ctx context.Context, client *http.Client, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
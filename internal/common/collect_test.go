@@ -0,0 +1,54 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// 超时后ctx被取消，后端的请求随之结束，超时指标为1；timeout为0时不限制，超时指标为0
+func TestCollectWithTimeout(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fast" {
+			return
+		}
+		<-r.Context().Done()
+		canceled <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	desc := prometheus.NewDesc("hadoop_exporter_scrape_timeout", "Whether the scrape timed out", nil, nil)
+	timedOut := func(timeout time.Duration, path string) float64 {
+		ch := make(chan prometheus.Metric, 1)
+		CollectWithTimeout(timeout, desc, ch, func(ctx context.Context) {
+			if resp, err := Get(ctx, http.DefaultClient, srv.URL+path); err == nil {
+				resp.Body.Close()
+			}
+		})
+		var m dto.Metric
+		if err := (<-ch).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetGauge().GetValue()
+	}
+	start := time.Now()
+	if v := timedOut(100*time.Millisecond, "/slow"); v != 1 {
+		t.Errorf("scrape_timeout = %v, want 1", v)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("collect took %s, want about 100ms", d)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Error("request was not canceled after the timeout")
+	}
+	if v := timedOut(0, "/fast"); v != 0 {
+		t.Errorf("scrape_timeout = %v, want 0", v)
+	}
+}
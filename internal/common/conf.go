@@ -0,0 +1,151 @@
+package common
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/log"
+)
+
+// 读取配置，从客户端配置中读取需要的信息
+type XMLConf struct {
+	XMLName   xml.Name    `xml:"configuration"`
+	NameValue []NameValue `xml:"property"`
+}
+
+type NameValue struct {
+	Name  string `xml:"name"`
+	Value string `xml:"value"`
+	Final string `xml:"final"`
+}
+
+// 读取XML配置文件，返回一个XMLConf结构体，出错时由调用方决定退出还是重试
+func ReadXml(path string) (*XMLConf, error) {
+	xmlFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening file %s: %s", path, err)
+	}
+	defer xmlFile.Close()
+	var x XMLConf
+	data, err := ioutil.ReadAll(xmlFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading file %s: %s", path, err)
+	}
+	err = xml.Unmarshal(data, &x)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshal xml %s: %s", path, err)
+	}
+	return &x, nil
+}
+
+// 配置文件不存在时返回空配置，此时配置项只能来自环境变量，用于不挂载配置文件的容器部署
+func ReadXmlOrEmpty(path string) (*XMLConf, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		log.Warnf("%s does not exist, read config from environment variables", path)
+		return &XMLConf{}, nil
+	}
+	return ReadXml(path)
+}
+
+// 在wait内每5秒重试读取配置文件，超时或wait为0时与ReadXmlOrEmpty相同
+func ReadXmlWithWait(path string, wait time.Duration) (*XMLConf, error) {
+	if wait <= 0 {
+		return ReadXmlOrEmpty(path)
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		x, err := ReadXml(path)
+		if err == nil {
+			return x, nil
+		}
+		// 超时后与不等待时相同，文件不存在时只使用环境变量中的配置
+		if time.Now().After(deadline) {
+			return ReadXmlOrEmpty(path)
+		}
+		log.Warnf("Config is not ready, retry in 5s: %s", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// 用于搜索配置值
+func SearchConf(name string, x *XMLConf) string {
+	for _, v := range x.NameValue {
+		// 按名称完全匹配，避免rm1匹配到rm10，或者匹配到以该名称开头的其他配置项，例如hbase.regionserver.info.port.auto
+		if strings.TrimSpace(v.Name) == name {
+			return strings.TrimSpace(v.Value)
+		}
+	}
+	return ""
+}
+
+// 按逗号拆分HA的id列表，去掉每个id两边的空白和空项，例如"rm1, rm2"
+func SplitIDs(s string) []string {
+	var ids []string
+	for _, id := range strings.Split(s, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// 拼接带HA后缀的配置项名，后缀为空时不加点，非HA时直接使用不带后缀的配置项
+func HAKey(name string, suffixes ...string) string {
+	for _, s := range suffixes {
+		if s != "" {
+			name += "." + s
+		}
+	}
+	return name
+}
+
+// 从host:port格式的配置中取出端口，配置缺失或没有端口时使用默认端口
+func ConfPort(name string, e *XMLConf, defaultPort string) string {
+	v := SearchConf(name, e)
+	// 按冒号切分会把没有端口的IPv6地址（例如[::1]）的最后一段当作端口
+	if _, port, err := net.SplitHostPort(v); err == nil && port != "" {
+		return port
+	}
+	log.Warnf("No port found in %s=%q, use default port %s", name, v, defaultPort)
+	return defaultPort
+}
+
+// 配置的地址是否指向本机，地址为主机名或host:port，按IP或主机名完全匹配，避免node1匹配到node10
+// 配置为FQDN而本机主机名为短名时（或相反）只比较第一段
+func IsLocalAddress(addr, hostname, ip string) bool {
+	host := strings.TrimSpace(addr)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		return false
+	}
+	if host == ip || strings.EqualFold(host, hostname) {
+		return true
+	}
+	short := func(s string) string {
+		return strings.ToLower(strings.SplitN(s, ".", 2)[0])
+	}
+	return net.ParseIP(host) == nil && short(host) == short(hostname)
+}
+
+// 本机IP，设置了local.ip时直接使用override，否则解析主机名
+func LocalIP(override string) string {
+	if override != "" {
+		return override
+	}
+	h, err := os.Hostname()
+	if err != nil {
+		panic(err)
+	}
+	t, err := net.ResolveIPAddr("ip", h)
+	if err != nil {
+		panic(err)
+	}
+	return t.IP.String()
+}
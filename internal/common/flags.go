@@ -0,0 +1,64 @@
+package common
+
+import (
+	"flag"
+	"os"
+	"strings"
+
+	"github.com/prometheus/log"
+)
+
+// 生成子命令的参数集合，并注册prometheus/log在flag.CommandLine上的log.level
+// 子命令只解析自己的FlagSet，不注册时-log.level会报错，debug日志也无法打开
+func NewFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	if f := flag.CommandLine.Lookup("log.level"); f != nil {
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	return fs
+}
+
+// 可重复指定的参数，第一次指定时覆盖默认值
+type StringsFlag struct {
+	Values []string
+	isSet  bool
+}
+
+func (f *StringsFlag) String() string {
+	return strings.Join(f.Values, ",")
+}
+
+func (f *StringsFlag) Set(v string) error {
+	if !f.isSet {
+		f.Values = nil
+		f.isSet = true
+	}
+	f.Values = append(f.Values, v)
+	return nil
+}
+
+// 在fs中注册可重复指定的参数
+func StringsVar(fs *flag.FlagSet, name, value, usage string) *StringsFlag {
+	f := &StringsFlag{Values: []string{value}}
+	fs.Var(f, name, usage)
+	return f
+}
+
+// 未在命令行中指定的参数，使用环境变量HADOOP_EXPORTER_<参数名>的值，例如jmx.url对应HADOOP_EXPORTER_JMX_URL
+func FlagsFromEnv(fs *flag.FlagSet) {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	replacer := strings.NewReplacer(".", "_", "-", "_")
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] {
+			return
+		}
+		if v, ok := os.LookupEnv("HADOOP_EXPORTER_" + strings.ToUpper(replacer.Replace(f.Name))); ok {
+			if err := f.Value.Set(v); err != nil {
+				log.Fatalf("Invalid value %s for %s: %s", v, f.Name, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package common
+
+import (
+	"flag"
+	"testing"
+)
+
+// 子命令的FlagSet需要接受prometheus/log注册在flag.CommandLine上的log.level
+func TestNewFlagSetLogLevel(t *testing.T) {
+	fs := NewFlagSet("hadoop-exporter namenode")
+	fs.Init(fs.Name(), flag.ContinueOnError)
+	level := flag.CommandLine.Lookup("log.level").Value
+	old := level.String()
+	t.Cleanup(func() { level.Set(old) })
+	if err := fs.Parse([]string{"-log.level=debug"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := level.String(); got != "debug" {
+		t.Errorf("log.level = %s, want debug", got)
+	}
+	if err := fs.Parse([]string{"-log.level=verbose"}); err == nil {
+		t.Error("invalid log.level accepted")
+	}
+}
+
+// 第一次指定时覆盖默认值，之后追加
+func TestStringsVar(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := StringsVar(fs, "web.listen-address", ":9070", "")
+	if got := f.String(); got != ":9070" {
+		t.Errorf("default = %s", got)
+	}
+	if err := fs.Parse([]string{"-web.listen-address=:1", "-web.listen-address=:2"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.String(); got != ":1,:2" {
+		t.Errorf("got %s, want :1,:2", got)
+	}
+}
@@ -0,0 +1,119 @@
+package common
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/log"
+)
+
+// 根据http.proxy生成请求后端使用的Transport，为空时使用环境变量中的代理设置
+func NewTransport(proxy string) (http.RoundTripper, error) {
+	if proxy == "" {
+		return http.DefaultTransport, nil
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyURL(proxyURL)
+	return t, nil
+}
+
+// 读取响应体，部分反向代理会返回gzip压缩的内容，此时需要手动解压
+func ReadBody(resp *http.Response) ([]byte, error) {
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return ioutil.ReadAll(gz)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// 读取JMX的bean列表，queries不为空时按bean逐个使用qry参数查询再合并，结果与完整的/jmx格式相同
+// ctx超时后不再发起查询，已读取到bean时返回这部分bean，由调用方输出部分指标
+func FetchBeans(ctx context.Context, client *http.Client, target string, queries []string) ([]interface{}, int, error) {
+	urls := []string{target}
+	if len(queries) > 0 {
+		urls = nil
+		for _, q := range queries {
+			urls = append(urls, target+"?qry="+url.QueryEscape(q))
+		}
+	}
+	var beans []interface{}
+	for _, u := range urls {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil && len(beans) > 0 {
+				log.Warnf("Collect timeout, only %d beans read from %s", len(beans), target)
+				return beans, 200, nil
+			}
+			return nil, 0, err
+		}
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			return nil, resp.StatusCode, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, u)
+		}
+		data, err := ReadBody(resp)
+		resp.Body.Close()
+		if err != nil {
+			return nil, resp.StatusCode, err
+		}
+		var f struct {
+			Beans []interface{} `json:"beans"`
+		}
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, resp.StatusCode, err
+		}
+		beans = append(beans, f.Beans...)
+	}
+	return beans, 200, nil
+}
+
+// 从bean中读取数值型指标，字段不存在或无法解析时返回false
+// 部分版本的bean会把数值编码成字符串，例如"DataPort":"9866"
+func GetFloat(m map[string]interface{}, key string) (float64, bool) {
+	switch v := m[key].(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// 根据请求结果判断配置的协议与实际是否不一致：http请求被重定向到https，或者向https端口发送了http请求，反之亦然
+func SchemeMismatch(target string, resp *http.Response, err error) bool {
+	if err != nil {
+		// 向https端口发送http请求时收到的是TLS的alert，向http端口发起TLS握手时收到的不是TLS记录
+		var recordErr tls.RecordHeaderError
+		return errors.As(err, &recordErr) || strings.Contains(err.Error(), "malformed HTTP response")
+	}
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return false
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	location, err := resp.Location()
+	return err == nil && location.Scheme != u.Scheme
+}
@@ -0,0 +1,51 @@
+// Package common 各角色exporter共用的代码
+package common
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/log"
+)
+
+// role已经是指标的常量标签时，推送时去掉，由分组标签role补上，否则Pushgateway会拒绝重复的标签
+// 没有可变标签的指标，m.Label与Desc中的常量标签共用底层数组，原地过滤会改坏Desc，因此使用新的切片
+func GatherWithoutRole(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		for _, mf := range mfs {
+			for _, m := range mf.Metric {
+				labels := make([]*dto.LabelPair, 0, len(m.Label))
+				for _, l := range m.Label {
+					if l.GetName() != "role" {
+						labels = append(labels, l)
+					}
+				}
+				m.Label = labels
+			}
+		}
+		return mfs, err
+	})
+}
+
+// 推送到Pushgateway的Pusher，role为角色名
+// serverip已经是指标的标签，Pushgateway不允许分组标签与指标标签重复，因此使用instance作为分组标签
+func NewPusher(gateway, role, serverIP string, g prometheus.Gatherer) *push.Pusher {
+	return push.New(gateway, "hadoop_exporter").
+		Gatherer(GatherWithoutRole(g)).
+		Grouping("role", role).
+		Grouping("instance", serverIP)
+}
+
+// 定时推送指标到Pushgateway，用于无法被及时拉取的节点
+func PushMetrics(gateway string, interval time.Duration, role, serverIP string, g prometheus.Gatherer) {
+	pusher := NewPusher(gateway, role, serverIP, g)
+	for {
+		if err := pusher.Push(); err != nil {
+			log.Error(err)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 推送时去掉role标签不能改动Desc中的常量标签，推送之后的采集仍然正常
+func TestPushThenGather(t *testing.T) {
+	r := prometheus.NewPedanticRegistry()
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "NameNode_CapacityTotal",
+		Help:        "CapacityTotal",
+		ConstLabels: prometheus.Labels{"serverip": "127.0.0.1", "nameservice": "ns1", "role": "namenode"},
+	})
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "NameNode_CreateFileOps_total",
+		Help:        "CreateFileOps",
+		ConstLabels: prometheus.Labels{"serverip": "127.0.0.1", "role": "namenode"},
+	}, []string{"op"})
+	c.WithLabelValues("create").Inc()
+	r.MustRegister(g, c)
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(gw.Close)
+	for i := 0; i < 2; i++ {
+		if _, err := r.Gather(); err != nil {
+			t.Fatalf("gather %d: %s", i, err)
+		}
+		if err := NewPusher(gw.URL, "namenode", "127.0.0.1", r).Push(); err != nil {
+			t.Fatalf("push %d: %s", i, err)
+		}
+	}
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatalf("gather after push: %s", err)
+	}
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			if n := len(m.Label); n < 2 {
+				t.Errorf("%s has %d labels after push", mf.GetName(), n)
+			}
+		}
+	}
+}
+
+func TestGatherWithoutRole(t *testing.T) {
+	r := prometheus.NewPedanticRegistry()
+	r.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "DataNode_XceiverCount",
+		Help:        "XceiverCount",
+		ConstLabels: prometheus.Labels{"serverip": "127.0.0.1", "role": "datanode"},
+	}))
+	mfs, err := GatherWithoutRole(r).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels := mfs[0].Metric[0].Label; len(labels) != 1 || labels[0].GetName() != "serverip" {
+		t.Errorf("labels = %v, want only serverip", labels)
+	}
+}
@@ -0,0 +1,115 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v2"
+)
+
+// 指标过滤规则，name和regex都需要完整匹配
+type relabelRule struct {
+	Action string `yaml:"action"` // keep只保留匹配的样本，drop丢弃匹配的样本
+	Name   string `yaml:"name"`   // 指标名的正则，为空时匹配所有指标
+	Label  string `yaml:"label"`  // 标签名，为空时只按指标名匹配
+	Regex  string `yaml:"regex"`  // 标签值的正则，样本没有该标签时按空字符串匹配
+
+	nameRe  *regexp.Regexp
+	valueRe *regexp.Regexp
+}
+
+// 编译完整匹配的正则，为空时匹配任意值
+func anchoredRegexp(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		expr = ".*"
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// 读取metric.relabel-config指定的过滤规则
+func loadRelabelRules(path string) ([]*relabelRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var conf struct {
+		Rules []*relabelRule `yaml:"rules"`
+	}
+	if err := yaml.UnmarshalStrict(data, &conf); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	for i, r := range conf.Rules {
+		if r.Action != "keep" && r.Action != "drop" {
+			return nil, fmt.Errorf("%s: rule %d: unknown action %q", path, i, r.Action)
+		}
+		if r.nameRe, err = anchoredRegexp(r.Name); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %s", path, i, err)
+		}
+		if r.valueRe, err = anchoredRegexp(r.Regex); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %s", path, i, err)
+		}
+	}
+	return conf.Rules, nil
+}
+
+func (r *relabelRule) match(name string, m *dto.Metric) bool {
+	if !r.nameRe.MatchString(name) {
+		return false
+	}
+	if r.Label == "" {
+		return true
+	}
+	value := ""
+	for _, l := range m.Label {
+		if l.GetName() == r.Label {
+			value = l.GetValue()
+			break
+		}
+	}
+	return r.valueRe.MatchString(value)
+}
+
+// 在暴露和推送前按规则依次过滤样本，没有剩余样本的指标整个去掉
+type relabelGatherer struct {
+	prometheus.Gatherer
+	rules []*relabelRule
+}
+
+// 按path中的规则过滤g的样本，用于metric.relabel-config
+func RelabelGatherer(g prometheus.Gatherer, path string) (prometheus.Gatherer, error) {
+	rules, err := loadRelabelRules(path)
+	if err != nil {
+		return nil, err
+	}
+	return relabelGatherer{Gatherer: g, rules: rules}, nil
+}
+
+func (g relabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	result := mfs[:0]
+	for _, mf := range mfs {
+		metrics := mf.Metric[:0]
+		for _, m := range mf.Metric {
+			if g.keep(mf.GetName(), m) {
+				metrics = append(metrics, m)
+			}
+		}
+		mf.Metric = metrics
+		if len(metrics) > 0 {
+			result = append(result, mf)
+		}
+	}
+	return result, err
+}
+
+func (g relabelGatherer) keep(name string, m *dto.Metric) bool {
+	for _, r := range g.rules {
+		if r.match(name, m) != (r.Action == "keep") {
+			return false
+		}
+	}
+	return true
+}
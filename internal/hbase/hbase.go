@@ -0,0 +1,392 @@
+// Package hbase HBase Master和RegionServer共用的exporter，各角色只提供JMX查询和bean的处理
+package hbase
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/exporter-toolkit/web"
+	"github.com/prometheus/log"
+
+	"hadoop_exporter/internal/common"
+)
+
+// 角色之间不同的部分
+type Role struct {
+	Name            string // 子命令名，也是推送时的role标签，例如hbasemaster
+	Title           string // 日志和首页中的名称，例如HBase Master
+	MetricPrefix    string // 指标名前缀，例如Master
+	InfoPortKey     string // hbase-site.xml中info端口的配置项
+	DefaultInfoPort string // 没有配置info端口时使用的端口
+	ListenPort      string // 默认的监听端口
+	JMXQuery        string // JMX的qry参数，只请求需要的bean
+	JMXPathExample  string // jmx.path的说明中网关路径的例子
+	// 生成角色的指标，serverIP用于serverip标签
+	NewMetrics func(namespace, serverIP string) Metrics
+}
+
+// 角色的指标，Collect处理JMX返回的bean，返回没有处理的bean名称，用于debug日志
+type Metrics interface {
+	Describe(ch chan<- *prometheus.Desc)
+	Collect(beans []map[string]interface{}, ch chan<- prometheus.Metric) []string
+}
+
+// 一个角色的子命令，包括参数、采集器和HTTP服务
+type Command struct {
+	role Role
+	// 子命令的参数，由hadoop-exporter <角色>之后的参数解析
+	Flags *flag.FlagSet
+
+	listenAddress  *common.StringsFlag
+	metricsPath    *string
+	systemdSocket  *bool
+	webConfigFile  *string
+	clientConfFile *string
+	timeout        *string
+	pushGateway    *string
+	pushInterval   *time.Duration
+	// 指标名前缀，设置后指标名为<namespace>_<MetricPrefix>_XXX
+	metricNamespace *string
+	// 只采集一次，用于调试和在CI中校验配置
+	oneshotMode *bool
+	// 访问后端的代理，为空时使用环境变量中的代理设置
+	httpProxy *string
+	// 后端很慢时避免整个/metrics阻塞到Prometheus超时
+	collectTimeout *time.Duration
+	// 在exporter端按规则保留或丢弃指标，用于控制高基数指标
+	relabelConfigFile *string
+	// 容器中主机名可能解析到其他网卡的IP或无法解析
+	localIPAddr *string
+	// 多个Prometheus同时采集时每次采集都会请求后端，限制并发避免放大后端的压力
+	maxRequests *int
+	// Kubernetes中configmap可能晚于容器挂载，等待配置文件而不是直接退出
+	configWait *time.Duration
+	// 通过Knox等网关访问时JMX不在/jmx下
+	jmxPath *string
+
+	// 请求后端使用的Transport，在Main中根据http.proxy设置
+	transport http.RoundTripper
+	// 暴露和推送指标使用的Gatherer，在Main中根据metric.relabel-config设置
+	gatherer prometheus.Gatherer
+	// 配置文件读取成功并注册采集器后为true，用于/ready
+	ready atomic.Bool
+}
+
+// 生成角色的子命令并注册参数
+func NewCommand(role Role) *Command {
+	c := &Command{
+		role:      role,
+		Flags:     common.NewFlagSet("hadoop-exporter " + role.Name),
+		transport: http.DefaultTransport,
+		gatherer:  prometheus.DefaultGatherer,
+	}
+	fs := c.Flags
+	c.listenAddress = common.StringsVar(fs, "web.listen-address", ":"+role.ListenPort, "暴露指标的监听地址，可重复指定以监听多个地址，默认"+role.ListenPort+".")
+	c.metricsPath = fs.String("web.telemetry-path", "/metrics", "暴露指标的路由.")
+	c.systemdSocket = fs.Bool("web.systemd-socket", false, "使用systemd socket activation提供的监听，而不是web.listen-address.")
+	c.webConfigFile = fs.String("web.config.file", "", "开启TLS或basic auth的配置文件路径，为空时使用HTTP.")
+	c.clientConfFile = fs.String("hbase-site.path", "/etc/hbase/conf/hbase-site.xml", "HBase的客户端配置路径，支持绝对路径和相对路径")
+	c.timeout = fs.String("get.timeout-seconds", "5", "请求超时的时间")
+	c.pushGateway = fs.String("push.gateway", "", "Pushgateway地址，设置后定时推送指标.")
+	c.pushInterval = fs.Duration("push.interval", 15*time.Second, "推送到Pushgateway的间隔.")
+	c.metricNamespace = fs.String("metric.namespace", "", "指标名前缀，为空时保持原有指标名.")
+	c.oneshotMode = fs.Bool("oneshot", false, "采集一次指标并以文本格式输出到标准输出后退出，后端不可达时返回非0.")
+	c.httpProxy = fs.String("http.proxy", "", "请求后端时使用的代理地址，为空时使用HTTP_PROXY、HTTPS_PROXY和NO_PROXY环境变量.")
+	c.collectTimeout = fs.Duration("collect.timeout", 0, "单次采集的超时时间，超时后只返回已采集到的指标，为0时不限制.")
+	c.relabelConfigFile = fs.String("metric.relabel-config", "", "指标过滤规则文件路径，按指标名和标签值保留或丢弃指标，为空时不过滤.")
+	c.localIPAddr = fs.String("local.ip", "", "本机IP，原样用于serverip标签和判断本机是否为active节点，为空时通过解析主机名得到.")
+	c.maxRequests = fs.Int("web.max-requests", 4, "同时处理的采集请求数上限，超过时返回503，为0时不限制.")
+	c.configWait = fs.Duration("config.wait", 0, "配置文件不存在或无法解析时重试的最长时间，等待期间/ready返回503，为0时不等待.")
+	c.jmxPath = fs.String("jmx.path", "/jmx", "JMX接口的路径，通过Knox等网关访问时改为网关中的路径，例如"+role.JMXPathExample+".")
+	return c
+}
+
+type Conf struct {
+	ServerIP  string // 本机IP
+	HttpsOpen bool   // 是否开启https
+	InfoPort  string // info端口，JMX通过该端口暴露
+}
+
+// 生成采集器使用的配置项
+func (c *Command) CreateConf(e *common.XMLConf) *Conf {
+	conf := Conf{}
+	conf.ServerIP = common.LocalIP(*c.localIPAddr)
+	conf.HttpsOpen = common.SearchConf("hbase.ssl.enabled", e) == "true"
+	conf.InfoPort = common.SearchConf(c.role.InfoPortKey, e)
+	if conf.InfoPort == "" {
+		conf.InfoPort = c.role.DefaultInfoPort
+	}
+	applyEnvConf(&conf)
+	return &conf
+}
+
+// 环境变量中的配置优先于XML配置
+func applyEnvConf(c *Conf) {
+	for env, v := range map[string]*string{
+		"HADOOP_EXPORTER_INFO_PORT": &c.InfoPort,
+	} {
+		if s, ok := os.LookupEnv(env); ok {
+			*v = s
+		}
+	}
+	if s, ok := os.LookupEnv("HADOOP_EXPORTER_HTTPS"); ok {
+		c.HttpsOpen = s == "true"
+	}
+}
+
+type Exporter struct {
+	url     string
+	cmd     *Command
+	client  *http.Client // 复用的http客户端，带超时，避免JMX无响应时卡住采集
+	metrics Metrics
+	// 服务状态
+	ServerActive prometheus.Gauge
+	// 本次采集返回的bean数量，指标缺失时先确认bean是否存在
+	beanCount *prometheus.Desc
+	// 采集是否超时
+	scrapeTimeout *prometheus.Desc
+}
+
+// 指标格式定义：metrics_name{serverip="10.30.108.2"}
+
+// 创建指标
+func (c *Command) NewExporter(url string, conf *Conf, namespace string) *Exporter {
+	t, err := strconv.Atoi(*c.timeout)
+	if err != nil {
+		log.Warnf("Invalid get.timeout-seconds %s, use 5 seconds", *c.timeout)
+		t = 5
+	}
+	return &Exporter{
+		url: url,
+		cmd: c,
+		client: &http.Client{
+			Transport: c.transport,
+			Timeout:   time.Duration(t * int(time.Second)),
+		},
+		metrics: c.role.NewMetrics(namespace, conf.ServerIP),
+		ServerActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        c.role.MetricPrefix + "_ServerActive",
+			Help:        "ServerActive",
+			ConstLabels: map[string]string{"serverip": conf.ServerIP},
+		}),
+		beanCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_beans"),
+			"The number of JMX beans returned in the last scrape",
+			nil,
+			prometheus.Labels{"serverip": conf.ServerIP},
+		),
+		scrapeTimeout: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_scrape_timeout"),
+			"Whether the last scrape exceeded collect.timeout and returned partial metrics, 1 timeout, 0 normal",
+			nil,
+			prometheus.Labels{"serverip": conf.ServerIP},
+		),
+	}
+}
+
+// 定义指标的描述
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.metrics.Describe(ch)
+	e.ServerActive.Describe(ch)
+	ch <- e.beanCount
+	ch <- e.scrapeTimeout
+}
+
+// 采集器方法，超过collect.timeout时取消未完成的请求，只输出已读取到的指标，并将hadoop_exporter_scrape_timeout置为1
+// 请求在超时后立即返回，采集在本次Collect内结束，不会与下一次采集重叠
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	common.CollectWithTimeout(*e.cmd.collectTimeout, e.scrapeTimeout, ch, func(ctx context.Context) {
+		e.collect(ctx, ch)
+	})
+}
+
+// 采集器方法
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	e.ServerActive.Set(0)
+	defer e.ServerActive.Collect(ch)
+	resp, err := common.Get(ctx, e.client, e.url+"?qry="+url.QueryEscape(e.cmd.role.JMXQuery))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+	data, err := common.ReadBody(resp)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	var f struct {
+		Beans []map[string]interface{} `json:"beans"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		log.Error(err)
+		return
+	}
+	e.ServerActive.Set(1)
+	ch <- prometheus.MustNewConstMetric(e.beanCount, prometheus.GaugeValue, float64(len(f.Beans)))
+	// 没有处理的bean只在debug日志中列出，用于排查指标缺失
+	if unmatched := e.metrics.Collect(f.Beans, ch); len(unmatched) > 0 {
+		log.Debugf("Beans not used by the collector: %s", strings.Join(unmatched, ", "))
+	}
+}
+
+// 最近一次采集时后端是否可达
+func (e *Exporter) up() bool {
+	var m dto.Metric
+	if err := e.ServerActive.Write(&m); err != nil {
+		return false
+	}
+	return m.GetGauge().GetValue() == 1
+}
+
+// 采集一次指标并以文本格式输出到标准输出，与服务模式使用同一个已注册的采集器
+func (c *Command) oneshot(exporter *Exporter) error {
+	mfs, err := c.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(os.Stdout, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	if !exporter.up() {
+		return fmt.Errorf("backend %s is unreachable", exporter.url)
+	}
+	return nil
+}
+
+// 根据配置生成采集器
+func (c *Command) createExporter(conf *Conf) *Exporter {
+	jmxURL := "http://" + net.JoinHostPort(conf.ServerIP, conf.InfoPort) + *c.jmxPath
+	if conf.HttpsOpen {
+		jmxURL = "https://" + net.JoinHostPort(conf.ServerIP, conf.InfoPort) + *c.jmxPath
+	}
+	return c.NewExporter(jmxURL, conf, *c.metricNamespace)
+}
+
+// 收到SIGHUP时重新读取配置，配置变化可能导致标签变化，因此重新注册采集器
+func (c *Command) reloadOnSIGHUP(exporter *Exporter) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		xmlConf, err := common.ReadXmlOrEmpty(*c.clientConfFile)
+		if err != nil {
+			log.Errorf("Reload config failed: %s", err)
+			continue
+		}
+		newExporter := c.createExporter(c.CreateConf(xmlConf))
+		prometheus.Unregister(exporter)
+		if err := prometheus.Register(newExporter); err != nil {
+			log.Errorf("Register reloaded exporter failed: %s", err)
+			prometheus.Register(exporter)
+			continue
+		}
+		exporter = newExporter
+		log.Info("Config reloaded")
+	}
+}
+
+// /metrics的处理器，同时处理的采集请求超过web.max-requests时返回503
+func (c *Command) metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(c.gatherer, promhttp.HandlerOpts{MaxRequestsInFlight: *c.maxRequests}))
+}
+
+// 运行exporter，args为子命令之后的参数
+func (c *Command) Main(args []string) {
+	c.Flags.Parse(args)
+	common.FlagsFromEnv(c.Flags)
+	log.Infof("%s Exporter make By Lijiadong(Meepod) (๑•̀ㅂ•́)و✧", c.role.Title)
+	// 等待配置期间先启动HTTP服务，/ready返回503，配置读取成功后才注册/metrics
+	serveErr := make(chan error, 1)
+	if *c.configWait > 0 && !*c.oneshotMode {
+		go func() {
+			serveErr <- c.serve()
+		}()
+	}
+	xmlConf, err := common.ReadXmlWithWait(*c.clientConfFile, *c.configWait)
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.transport, err = common.NewTransport(*c.httpProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *c.relabelConfigFile != "" {
+		c.gatherer, err = common.RelabelGatherer(prometheus.DefaultGatherer, *c.relabelConfigFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	conf := c.CreateConf(xmlConf)
+	exporter := c.createExporter(conf)
+	prometheus.MustRegister(exporter)
+	if *c.oneshotMode {
+		if err := c.oneshot(exporter); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	go c.reloadOnSIGHUP(exporter)
+	log.Printf("Starting Server: %s", c.listenAddress)
+	if *c.pushGateway != "" {
+		go common.PushMetrics(*c.pushGateway, *c.pushInterval, c.role.Name, conf.ServerIP, c.gatherer)
+	}
+	http.Handle(*c.metricsPath, c.metricsHandler())
+	c.ready.Store(true)
+	if *c.configWait > 0 {
+		err = <-serveErr
+	} else {
+		err = c.serve()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// 启动HTTP服务，/metrics在配置读取成功后单独注册
+func (c *Command) serve() error {
+	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !c.ready.Load() {
+			http.Error(w, "config is not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK"))
+	})
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html>
+		<head><title>` + c.role.Title + ` Exporter</title></head>
+		<body>
+		<h1>` + c.role.Title + ` Exporter By Meepo</h1>
+		<h2>The greatest test of courage on earth is to bear defeat without losing heart</h2>
+		<p><a href="` + *c.metricsPath + `">Metrics</a></p>
+		</body>
+		</html>`))
+	})
+	server := &http.Server{}
+	flagConfig := &web.FlagConfig{
+		WebListenAddresses: &c.listenAddress.Values,
+		WebSystemdSocket:   c.systemdSocket,
+		WebConfigFile:      c.webConfigFile,
+	}
+	return web.ListenAndServe(server, flagConfig, kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr)))
+}
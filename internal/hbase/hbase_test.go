@@ -0,0 +1,104 @@
+package hbase
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"hadoop_exporter/internal/testutil"
+)
+
+// 只记录收到的bean名称的指标
+type testMetrics struct {
+	desc  *prometheus.Desc
+	names []string
+}
+
+func (m *testMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.desc
+}
+
+func (m *testMetrics) Collect(beans []map[string]interface{}, ch chan<- prometheus.Metric) []string {
+	for _, bean := range beans {
+		name, _ := bean["name"].(string)
+		m.names = append(m.names, name)
+	}
+	ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, float64(len(beans)))
+	return nil
+}
+
+// 与RegionServer相同的查询，qry中的逗号和等号需要转义
+func newTestCommand() *Command {
+	return NewCommand(Role{
+		Name:            "hbasetest",
+		Title:           "HBase Test",
+		MetricPrefix:    "Test",
+		InfoPortKey:     "hbase.test.info.port",
+		DefaultInfoPort: "16030",
+		ListenPort:      "9079",
+		JMXQuery:        "Hadoop:service=HBase,name=RegionServer,sub=Server",
+		JMXPathExample:  "/gateway/default/hbase/test/jmx",
+		NewMetrics: func(namespace, serverIP string) Metrics {
+			return &testMetrics{desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "Test_beans"), "beans", nil, prometheus.Labels{"serverip": serverIP})}
+		},
+	})
+}
+
+// qry参数经过转义，后端解析出的查询与角色定义的查询一致
+func TestCollectQueryEscaped(t *testing.T) {
+	c := newTestCommand()
+	var rawQuery, qry string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawQuery = r.URL.RawQuery
+		qry = r.URL.Query().Get("qry")
+		w.Write([]byte(`{"beans":[{"name":"Hadoop:service=HBase,name=RegionServer,sub=Server"}]}`))
+	}))
+	t.Cleanup(srv.Close)
+	mfs := testutil.Gather(t, c.NewExporter(srv.URL+"/jmx", &Conf{ServerIP: "127.0.0.1"}, ""))
+	if qry != c.role.JMXQuery {
+		t.Errorf("qry = %q, want %q", qry, c.role.JMXQuery)
+	}
+	if strings.ContainsAny(strings.TrimPrefix(rawQuery, "qry="), ",=:") {
+		t.Errorf("raw query %q is not escaped", rawQuery)
+	}
+	if v, ok := testutil.MetricValue(mfs, "Test_ServerActive", nil); !ok || v != 1 {
+		t.Errorf("Test_ServerActive = %v (found %v), want 1", v, ok)
+	}
+}
+
+// 同时处理的请求超过web.max-requests时直接返回503
+func TestMaxRequests(t *testing.T) {
+	c := newTestCommand()
+	testutil.SetFlag(t, c.Flags, "web.max-requests", "1")
+	testutil.CheckMaxRequests(t, func(g prometheus.Gatherer) http.Handler {
+		c.gatherer = g
+		return c.metricsHandler()
+	})
+}
+
+// 通过网关访问时使用--jmx.path指定的路径
+func TestCreateExporterJMXPath(t *testing.T) {
+	c := newTestCommand()
+	conf := &Conf{ServerIP: "127.0.0.1", InfoPort: "16010"}
+	if e := c.createExporter(conf); e.url != "http://127.0.0.1:16010/jmx" {
+		t.Errorf("url = %q", e.url)
+	}
+	testutil.SetFlag(t, c.Flags, "jmx.path", "/gateway/default/hbase/jmx")
+	conf.HttpsOpen = true
+	if e := c.createExporter(conf); e.url != "https://127.0.0.1:16010/gateway/default/hbase/jmx" {
+		t.Errorf("url = %q", e.url)
+	}
+}
+
+// 超过collect.timeout时取消未完成的请求，采集在超时后返回，不会等到get.timeout-seconds
+func TestCollectTimeoutCancelsRequests(t *testing.T) {
+	c := newTestCommand()
+	testutil.SetFlag(t, c.Flags, "collect.timeout", "300ms")
+	testutil.SetFlag(t, c.Flags, "get.timeout-seconds", "30")
+	testutil.CheckCollectTimeout(t, func(url string) prometheus.Collector {
+		return c.NewExporter(url+"/jmx", &Conf{ServerIP: "127.0.0.1"}, "")
+	})
+}
@@ -0,0 +1,193 @@
+// Package testutil 各角色的测试共用的采集、指标检查和模拟后端，只在测试中引用
+package testutil
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil/promlint"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// promlint中允许的提示，其余提示都作为测试失败
+// 指标名和标签名沿用JMX字段的驼峰命名（NameNode_XX、applicationID等），看板依赖这些名称，因此不修改
+var lintAllowlist = []string{
+	"metric names should be written in 'snake_case' not 'camelCase'",
+	"label names should be written in 'snake_case' not 'camelCase'",
+}
+
+// 注册到独立的registry中采集一次，同时校验Describe和Collect一致，并用promlint检查采集到的指标
+func Gather(t *testing.T, c prometheus.Collector) []*dto.MetricFamily {
+	t.Helper()
+	r := prometheus.NewPedanticRegistry()
+	if err := r.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	Lint(t, mfs)
+	return mfs
+}
+
+// 用promlint检查指标，忽略lintAllowlist中的提示
+func Lint(t *testing.T, mfs []*dto.MetricFamily) {
+	t.Helper()
+	problems, err := promlint.NewWithMetricFamilies(mfs).Lint()
+	if err != nil {
+		t.Fatal(err)
+	}
+next:
+	for _, p := range problems {
+		for _, allowed := range lintAllowlist {
+			if p.Text == allowed {
+				continue next
+			}
+		}
+		t.Errorf("lint %s: %s", p.Metric, p.Text)
+	}
+}
+
+// 设置--metric.namespace后所有指标都以<namespace>_开头，包括hadoop_exporter_*等自身的指标
+func CheckNamespace(t *testing.T, mfs []*dto.MetricFamily, namespace string) {
+	t.Helper()
+	if len(mfs) == 0 {
+		t.Fatal("no metrics gathered")
+	}
+	for _, mf := range mfs {
+		if !strings.HasPrefix(mf.GetName(), namespace+"_") {
+			t.Errorf("metric %s does not start with namespace %s", mf.GetName(), namespace)
+		}
+	}
+}
+
+// 修改参数，测试结束后恢复
+func SetFlag(t *testing.T, fs *flag.FlagSet, name, value string) {
+	t.Helper()
+	old := fs.Lookup(name).Value.String()
+	if err := fs.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Set(name, old) })
+}
+
+// 查找指标名和标签都匹配的第一个指标的值
+func MetricValue(mfs []*dto.MetricFamily, name string, labels map[string]string) (float64, bool) {
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+	next:
+		for _, m := range mf.GetMetric() {
+			for k, v := range labels {
+				found := false
+				for _, l := range m.GetLabel() {
+					if l.GetName() == k && l.GetValue() == v {
+						found = true
+					}
+				}
+				if !found {
+					continue next
+				}
+			}
+			switch {
+			case m.Gauge != nil:
+				return m.GetGauge().GetValue(), true
+			case m.Counter != nil:
+				return m.GetCounter().GetValue(), true
+			default:
+				return m.GetUntyped().GetValue(), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// 所有请求都返回同一份JMX的后端
+func NewJMXServer(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// 检查超过collect.timeout时取消未完成的请求，采集在超时后返回，不会等到get.timeout-seconds
+// 调用前需要将collect.timeout设置为300ms，get.timeout-seconds设置为远大于它的值，newCollector根据后端地址生成采集器
+func CheckCollectTimeout(t *testing.T, newCollector func(url string) prometheus.Collector) {
+	t.Helper()
+	var open atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		open.Add(1)
+		defer open.Add(-1)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	start := time.Now()
+	mfs := Gather(t, newCollector(srv.URL))
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("collect took %s, want about 300ms", d)
+	}
+	if v, ok := MetricValue(mfs, "hadoop_exporter_scrape_timeout", nil); !ok || v != 1 {
+		t.Errorf("hadoop_exporter_scrape_timeout = %v (found %v), want 1", v, ok)
+	}
+	for deadline := time.Now().Add(2 * time.Second); open.Load() > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests still open after the timeout", open.Load())
+		}
+	}
+}
+
+// 阻塞到release关闭的Gatherer，用于模拟耗时的采集
+type blockingGatherer struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g blockingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.started <- struct{}{}
+	<-g.release
+	return nil, nil
+}
+
+// 检查同时处理的请求超过web.max-requests时直接返回503
+// 调用前需要将web.max-requests设置为1，newHandler使用传入的Gatherer生成/metrics的处理器
+func CheckMaxRequests(t *testing.T, newHandler func(g prometheus.Gatherer) http.Handler) {
+	t.Helper()
+	g := blockingGatherer{started: make(chan struct{}), release: make(chan struct{})}
+	srv := httptest.NewServer(newHandler(g))
+	t.Cleanup(srv.Close)
+	done := make(chan int)
+	go func() {
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			done <- 0
+			return
+		}
+		resp.Body.Close()
+		done <- resp.StatusCode
+	}()
+	<-g.started
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Error(err)
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("status code = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+		}
+	}
+	close(g.release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("status code of the first request = %d, want %d", code, http.StatusOK)
+	}
+}
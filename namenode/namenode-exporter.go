@@ -1,41 +1,86 @@
-package main
+package namenode
 
 import (
+	"context"
 	"encoding/json"
-	"encoding/xml"
-	"flag"
-	"io/ioutil"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	kitlog "github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/exporter-toolkit/web"
 	"github.com/prometheus/log"
+
+	"hadoop_exporter/internal/common"
 )
 
 const (
 	httpsmode = false
 )
 
+// 子命令的参数，由hadoop-exporter namenode之后的参数解析
+var flags = common.NewFlagSet("hadoop-exporter namenode")
+
 var (
-	listenAddress = flag.String("web.listen-address", ":9070", "暴露指标的监听地址，默认9070.") //设置成ip:port的格式，似乎更容易进行更改
-	metricsPath   = flag.String("web.telemetry-path", "/metrics", "暴露指标的路由.")
-	//namenodeJmxUrl = flag.String("namenode.jmx.url", "http://localhost:50070/jmx", "Hadoop JMX URL.")
-	clientConfFile = flag.String("hdfs-site.path", "/etc/hadoop/conf/hdfs-site.xml", "")
+	listenAddress = common.StringsVar(flags, "web.listen-address", ":9070", "暴露指标的监听地址，可重复指定以监听多个地址，默认9070.") //设置成ip:port的格式，似乎更容易进行更改
+	metricsPath   = flags.String("web.telemetry-path", "/metrics", "暴露指标的路由.")
+	systemdSocket = flags.Bool("web.systemd-socket", false, "使用systemd socket activation提供的监听，而不是web.listen-address.")
+	webConfigFile = flags.String("web.config.file", "", "开启TLS或basic auth的配置文件路径，为空时使用HTTP.")
+	//namenodeJmxUrl = flags.String("namenode.jmx.url", "http://localhost:50070/jmx", "Hadoop JMX URL.")
+	clientConfFile = flags.String("hdfs-site.path", "/etc/hadoop/conf/hdfs-site.xml", "")
+	jmxURL         = flags.String("jmx.url", "", "NameNode的JMX地址，多个地址用逗号分隔，为空时根据hdfs-site.xml生成本机地址.")
+	pushGateway    = flags.String("push.gateway", "", "Pushgateway地址，设置后定时推送指标.")
+	pushInterval   = flags.Duration("push.interval", 15*time.Second, "推送到Pushgateway的间隔.")
+	// 指标名前缀，设置后指标名为<namespace>_NameNode_XXX
+	metricNamespace = flags.String("metric.namespace", "", "指标名前缀，为空时保持原有指标名.")
+	// 按bean查询JMX，减少NameNode的CPU和传输量
+	jmxUseQuery = flags.Bool("jmx.use-query", false, "按需要的bean使用qry参数分别查询JMX，而不是获取完整的/jmx.")
+	// 只采集一次，用于调试和在CI中校验配置
+	oneshotMode = flags.Bool("oneshot", false, "采集一次指标并以文本格式输出到标准输出后退出，后端不可达时返回非0.")
+	// 访问后端的代理，为空时使用环境变量中的代理设置
+	httpProxy = flags.String("http.proxy", "", "请求后端时使用的代理地址，为空时使用HTTP_PROXY、HTTPS_PROXY和NO_PROXY环境变量.")
+	// 通过Knox等网关访问时JMX不在/jmx下
+	jmxPath = flags.String("jmx.path", "/jmx", "JMX接口的路径，通过Knox等网关访问时改为网关中的路径，例如/gateway/default/hdfs/jmx.")
+	// 后端很慢时避免整个/metrics阻塞到Prometheus超时
+	collectTimeout = flags.Duration("collect.timeout", 0, "单次采集的超时时间，超时后只返回已采集到的指标，为0时不限制.")
+	// JMX无响应时避免请求一直阻塞
+	timeout = flags.String("get.timeout-seconds", "5", "请求超时的时间")
+	// 在exporter端按规则保留或丢弃指标，用于控制高基数指标
+	relabelConfigFile = flags.String("metric.relabel-config", "", "指标过滤规则文件路径，按指标名和标签值保留或丢弃指标，为空时不过滤.")
+	// 容器中主机名可能解析到其他网卡的IP或无法解析
+	localIPAddr = flags.String("local.ip", "", "本机IP，原样用于serverip标签和判断本机是否为active节点，为空时通过解析主机名得到.")
+	// 毫秒时间戳在面板中经常被误当作秒，开启后额外输出距离采集时的秒数
+	timeAsAge = flags.Bool("time.as-age", false, "为StartTime等毫秒时间戳指标额外输出距离采集时的秒数*_age_seconds，原指标保持不变.")
+	// 调用方数量没有上限，只输出调用量最大的前N个
+	callerTopN = flags.Int("namenode.caller-top-n", 0, "输出DecayRpcScheduler中调用量最大的前N个调用方的NameNode_CallerContextOps，为0时不输出.")
+	// 多个Prometheus同时采集时每次采集都会请求后端，限制并发避免放大后端的压力
+	maxRequests = flags.Int("web.max-requests", 4, "同时处理的采集请求数上限，超过时返回503，为0时不限制.")
+	// Kubernetes中configmap可能晚于容器挂载，等待配置文件而不是直接退出
+	configWait = flags.Duration("config.wait", 0, "配置文件不存在或无法解析时重试的最长时间，等待期间/ready返回503，为0时不等待.")
+	// 大量删除后删除队列不下降说明DataNode没有执行删除
+	deletionStuckWindow = flags.Duration("block-deletion-stuck-window", 0, "PendingDeletionBlocks大于0且在该时间内没有下降时NameNode_PendingDeletionBlocksStuck为1，为0时不输出.")
 )
 
-//读取配置，从客户端配置中读取需要的信息
-type XMLConf struct {
-	XMLName   xml.Name    `xml:"configuration"`
-	NameValue []NameValue `xml:"property"`
-}
+// 请求后端使用的Transport，在main中根据http.proxy设置
+var transport http.RoundTripper = http.DefaultTransport
 
-type NameValue struct {
-	Name  string `xml:"name"`
-	Value string `xml:"value"`
-	Final string `xml:"final"`
-}
+// 暴露和推送指标使用的Gatherer，在main中根据metric.relabel-config设置
+var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
 
 type HDFSConf struct {
 	RpcPort     string //RPC端口
@@ -48,8 +93,9 @@ type HDFSConf struct {
 }
 
 type Exporter struct {
-	url string
-	c   HDFSConf
+	url    string
+	c      HDFSConf
+	client *http.Client // 复用的http客户端，带超时，避免JMX无响应时卡住采集
 	//文件系统指标
 	MissingBlocks         prometheus.Gauge //缺失块
 	CapacityTotal         prometheus.Gauge //配置的HDFS空间
@@ -103,312 +149,753 @@ type Exporter struct {
 	//其他健康指标
 	isActive             prometheus.Gauge //是否是Active的
 	LastHATransitionTime prometheus.Gauge //上次主备切换时间，毫秒时间戳
+	HAState              prometheus.Gauge //HA状态，1为active，0为standby，2为observer，3为其他状态，bean缺失时为-1
+	haStateMisses        prometheus.Counter
+	//元数据目录指标 "name": "Hadoop:service=NameNode,name=NameNodeInfo"
+	NameDirActive prometheus.Gauge //正常的元数据目录数量
+	NameDirFailed prometheus.Gauge //失败的元数据目录数量
+	nameDirStatus *prometheus.Desc //每个元数据目录的状态，1为正常，0为失败
+	//块删除的开始时间，启动后延迟dfs.namenode.startup.delay.block.deletion.sec才开始删除，旧版本没有时不输出
+	BlockDeletionStartTime *prometheus.Desc
+	//PendingDeletionBlocks在block-deletion-stuck-window内没有下降时为1
+	PendingDeletionBlocksStuck *prometheus.Desc
+	deletionMutex              sync.Mutex
+	deletionSamples            []timeSample
+	//全局锁指标，仅在NameNode暴露时采集 "name": "Hadoop:service=NameNode,name=FSNamesystem"
+	LockQueueLength     *prometheus.Desc //等待全局锁的线程数
+	FSNWriteLockAvgTime *prometheus.Desc //写锁平均持有时间
+	FSNReadLockAvgTime  *prometheus.Desc //读锁平均持有时间
+	//超时未完成的副本复制请求，非0说明有复制请求一直没有完成
+	NumTimedOutPendingReconstructions *prometheus.Desc
+	//从配置文件解析出的信息，必需项为空时值为0，用于发现自动识别配置出错
+	configValid *prometheus.Desc
+	//下线进度指标，下线节点待复制的块来自NameNodeInfo的DecomNodes
+	PendingDataNodeMessageCount *prometheus.Desc
+	decomUnderReplicatedBlocks  *prometheus.Desc
+	//被其他DataNode报告为慢节点的DataNode，值为报告的节点数，需要开启dfs.datanode.peer.stats.enabled "name": "Hadoop:service=NameNode,name=NameNodeStatus"
+	SlowPeerReported *prometheus.Desc
+	//RPC认证鉴权失败次数，累计值，开启Kerberos时突增通常说明票据或token有问题
+	RpcAuthenticationFailures *prometheus.Desc
+	RpcAuthorizationFailures  *prometheus.Desc
+	//DataNode心跳超时次数，累计值
+	ExpiredHeartbeats *prometheus.Desc
+	//客户端泄漏文件句柄时这两个值会持续增长
+	NumFilesUnderConstruction *prometheus.Desc //正在写入的文件数，不同版本的字段名和所在bean不同
+	NumOpenConnections        *prometheus.Desc //RPC打开的连接数 "name": "Hadoop:service=NameNode,name=RpcActivityForPortXX"
+	//元数据操作指标 "name": "Hadoop:service=NameNode,name=NameNodeActivity"
+	GetListingOps     *prometheus.Desc //ls操作次数，累计值
+	GetListingAvgTime *prometheus.Desc //ls操作平均耗时
+	CreateFileOps     *prometheus.Desc //创建文件次数，累计值
+	DeleteFileOps     *prometheus.Desc //删除文件次数，累计值
+	//getContentSummary会持有读锁遍历整个目录树，du和配额工具频繁调用时会导致NameNode卡顿
+	//部分版本的NameNodeActivity没有这两个字段，此时使用"name": "Hadoop:service=NameNode,name=RpcDetailedActivityForPortXX"
+	GetContentSummaryOps     *prometheus.Desc //getContentSummary次数，累计值
+	GetContentSummaryAvgTime *prometheus.Desc //getContentSummary平均耗时
+	//editlog写入指标，SyncsAvgTime高通常是JournalNode慢，会直接拖慢所有写操作
+	TransactionsNumOps *prometheus.Desc //editlog事务数，累计值，可以估算审计日志的量
+	SyncsNumOps        *prometheus.Desc //editlog sync次数，累计值
+	SyncsAvgTime       *prometheus.Desc //editlog sync平均耗时
+	//fsimage指标，JMX中没有fsimage文件大小，只能用事务ID的增长估算元数据的增长
+	FsImageLoadTimeMs        *prometheus.Desc //启动时加载fsimage的耗时 "name": "Hadoop:service=NameNode,name=NameNodeActivity"
+	MostRecentCheckpointTxId *prometheus.Desc //最近一次checkpoint的事务ID "name": "Hadoop:service=NameNode,name=NameNodeInfo"
+	LastAppliedOrWrittenTxId *prometheus.Desc //最新写入或应用的事务ID
+	//严重的数据损坏指标，旧版本没有这些字段时不输出 "name": "Hadoop:service=NameNode,name=NameNodeInfo"
+	MissingBlocksWithReplicationFactorOne *prometheus.Desc //单副本文件缺失的块，无法恢复，需要比MissingBlocks更高级别的告警
+	BytesWithFutureGenerationStamps       *prometheus.Desc //generation stamp大于NameNode记录的块的字节数，通常是用旧的元数据启动导致
+	//按调用方统计的RPC调用量，开启FairCallQueue和DecayRpcScheduler后才有 "name": "Hadoop:service=NameNode,name=DecayRpcSchedulerMetrics2.ipc.XX"
+	CallerContextOps *prometheus.Desc
+	// 非堆内存，包括metaspace和code cache，metaspace泄漏同样会导致OOM "name": "java.lang:type=Memory"
+	nonHeapMemoryUsageCommitted *prometheus.Desc
+	nonHeapMemoryUsageInit      *prometheus.Desc
+	nonHeapMemoryUsageMax       *prometheus.Desc // 未限制时为-1
+	nonHeapMemoryUsageUsed      *prometheus.Desc
+	// 进程信息，用于确认exporter采集的是哪个实例和版本，值固定为1
+	HadoopInfo *prometheus.Desc
+	// 后端的JMX是否采集成功，一个进程采集多个后端时可以区分是哪个不可达
+	BackendUp *prometheus.Desc
+	// 毫秒时间戳距离采集时的秒数，需要开启time.as-age
+	LastCheckpointTimeAge   *prometheus.Desc
+	LastHATransitionTimeAge *prometheus.Desc
+	// JvmMetrics中的GC汇总指标，与按收集器区分的GC指标互补 "name": "Hadoop:service=NameNode,name=JvmMetrics"
+	JvmGcCount                    *prometheus.Desc // GC次数，累计值
+	JvmGcTimeMillis               *prometheus.Desc // GC累计耗时，单位为毫秒
+	JvmGcNumWarnThresholdExceeded *prometheus.Desc // JvmPauseMonitor检测到超过告警阈值的停顿次数，累计值
+	// 最近一段时间内GC耗时的占比，需要开启GcTimeMonitor，接近100说明JVM一直在GC
+	JvmGcTimePercentage *prometheus.Desc
+	// 本次采集返回的bean数量，指标缺失时先确认bean是否存在
+	beanCount *prometheus.Desc
+	// 采集是否超时
+	scrapeTimeout *prometheus.Desc
+	// 配置的dfs.http.policy与实际协议不一致时为1，得到明确结果前每次采集都检测，之后不再请求
+	schemeMismatch   *prometheus.Desc
+	schemeMutex      sync.Mutex
+	schemeChecked    bool
+	schemeMismatched bool
 }
 
-//用于搜索配置值，支持任意返回值类型
-func SearchConf(name string, x *XMLConf) string {
-	for _, v := range x.NameValue {
-		//匹配配置项
-		if strings.Contains(v.Name, name) {
-			return v.Value
-		}
-	}
-	return ""
-}
-
-//读取XML配置文件，返回一个XMLConf结构体
-func ReadXml(path string) *XMLConf {
-	xmlFile, err := os.Open(path)
-	if err != nil {
-		log.Error("Error opening file: %s", path)
-		os.Exit(1)
-	}
-	defer xmlFile.Close()
-	var x XMLConf
-	data, err := ioutil.ReadAll(xmlFile)
-	if err != nil {
-		log.Error("Error reading file: %s", path)
-		os.Exit(1)
-	}
-	err = xml.Unmarshal(data, &x)
-	if err != nil {
-		log.Error("Error unmarshal xml.")
-		os.Exit(1)
-	}
-	return &x
-}
-
-//生成采集器使用的配置项
-func CreateHDFSConf(e *XMLConf) *HDFSConf {
+// 生成采集器使用的配置项
+func CreateHDFSConf(e *common.XMLConf) *HDFSConf {
 	c := HDFSConf{}
 	h, err := os.Hostname()
 	if err != nil {
 		panic(err)
 	}
-	t, err := net.ResolveIPAddr("ip", h)
-	if err != nil {
-		panic(err)
-	}
-	c.ServerIP = t.IP.String()
+	c.ServerIP = common.LocalIP(*localIPAddr)
 	// 默认关闭https
 	c.HttpsOpen = httpsmode
-	c.NameService = SearchConf("dfs.internal.nameservices", e)
-	for _, id := range strings.Split(SearchConf("dfs.ha.namenodes."+c.NameService, e), ",") {
-		r := "dfs.namenode.rpc-address." + c.NameService + "." + id
-		if v := SearchConf(r, e); strings.Contains(v, h) {
+	c.NameService = common.SearchConf("dfs.internal.nameservices", e)
+	ids := common.SplitIDs(common.SearchConf("dfs.ha.namenodes."+c.NameService, e))
+	// 非HA时没有dfs.ha.namenodes，配置项不带namenodeid后缀
+	if len(ids) == 0 {
+		ids = []string{""}
+	}
+	for _, id := range ids {
+		r := common.HAKey("dfs.namenode.rpc-address", c.NameService, id)
+		// 非HA时只有一个NameNode，不需要匹配主机名
+		if id == "" || common.IsLocalAddress(common.SearchConf(r, e), h, c.ServerIP) {
 			c.NameNodeID = id
-			c.RpcPort = strings.Split(SearchConf(r, e), ":")[1]
+			c.RpcPort = common.ConfPort(r, e, "8020")
 			break
 		}
 	}
-	// 判断是否开启HTTPS，并获取端口
-	if v := SearchConf("dfs.http.policy", e); v == "HTTPS_ONLY" {
+	// 判断是否开启HTTPS，并获取端口，没有配置时使用Hadoop 3.x的默认端口
+	if v := common.SearchConf("dfs.http.policy", e); v == "HTTPS_ONLY" {
 		c.HttpsOpen = true
-		c.HttpsPort = strings.Split(SearchConf("dfs.namenode.https-address."+c.NameService+"."+c.NameNodeID, e), ":")[1]
+		c.HttpsPort = common.ConfPort(common.HAKey("dfs.namenode.https-address", c.NameService, c.NameNodeID), e, "9871")
 	} else {
-		c.HttpPort = strings.Split(SearchConf("dfs.namenode.http-address."+c.NameService+"."+c.NameNodeID, e), ":")[1]
+		c.HttpPort = common.ConfPort(common.HAKey("dfs.namenode.http-address", c.NameService, c.NameNodeID), e, "9870")
 	}
 
+	applyEnvConf(&c)
 	return &c
 }
 
+// 根据指定的JMX地址生成对应NameNode的配置项，namenodeid通过匹配http(s)-address得到
+func CreateTargetConf(target string, c *HDFSConf, e *common.XMLConf) *HDFSConf {
+	t := *c
+	t.NameNodeID = ""
+	t.RpcPort = ""
+	u, err := url.Parse(target)
+	if err != nil {
+		log.Error(err)
+		return &t
+	}
+	host := u.Hostname()
+	t.ServerIP = host
+	if ip, err := net.ResolveIPAddr("ip", host); err == nil {
+		t.ServerIP = ip.IP.String()
+	}
+	for _, id := range common.SplitIDs(common.SearchConf("dfs.ha.namenodes."+c.NameService, e)) {
+		for _, r := range []string{"dfs.namenode.http-address", "dfs.namenode.https-address"} {
+			h, p, err := net.SplitHostPort(common.SearchConf(common.HAKey(r, c.NameService, id), e))
+			if err != nil || p != u.Port() {
+				continue
+			}
+			if ip, err := net.ResolveIPAddr("ip", h); h == host || (err == nil && ip.IP.String() == t.ServerIP) {
+				t.NameNodeID = id
+				if _, rpcPort, err := net.SplitHostPort(common.SearchConf(common.HAKey("dfs.namenode.rpc-address", c.NameService, id), e)); err == nil {
+					t.RpcPort = rpcPort
+				}
+				return &t
+			}
+		}
+	}
+	log.Warnf("No namenode id matches jmx url %s", target)
+	return &t
+}
+
 //指标格式定义：metrics_name{job="XX",ip="10.30.108.2",nameservice=""}
 
-//创建指标
-func NewExporter(url string, c *HDFSConf) *Exporter {
+// 创建指标
+func NewExporter(url string, c *HDFSConf, namespace string) *Exporter {
+	t, err := strconv.Atoi(*timeout)
+	if err != nil {
+		log.Warnf("Invalid get.timeout-seconds %s, use 5 seconds", *timeout)
+		t = 5
+	}
 	return &Exporter{
 		url: url,
 		c:   *c,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(t * int(time.Second)),
+		},
 		MissingBlocks: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_MissingBlocks",
 			Help:        "MissingBlocks",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		CapacityTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_CapacityTotal",
 			Help:        "CapacityTotal",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		CapacityUsed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_CapacityUsed",
 			Help:        "CapacityUsed",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		CapacityRemaining: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_CapacityRemaining",
 			Help:        "CapacityRemaining",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		CapacityUsedNonDFS: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_CapacityUsedNonDFS",
 			Help:        "CapacityUsedNonDFS",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		BlocksTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_BlocksTotal",
 			Help:        "BlocksTotal",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		FilesTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_FilesTotal",
 			Help:        "FilesTotal",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		CorruptBlocks: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_CorruptBlocks",
 			Help:        "CorruptBlocks",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		UnderReplicatedBlocks: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_UnderReplicatedBlocks",
 			Help:        "UnderReplicatedBlocks",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		ExcessBlocks: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_ExcessBlocks",
 			Help:        "ExcessBlocks",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		PendingDeletionBlocks: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_PendingDeletionBlocks",
 			Help:        "PendingDeletionBlocks",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		NumActiveClients: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_NumActiveClients",
 			Help:        "NumActiveClients",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		LastCheckpointTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_LastCheckpointTime",
 			Help:        "LastCheckpointTime",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		NumLiveDataNodes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_NumLiveDataNodes",
 			Help:        "NameNode_NumLiveDataNodes",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		NumDeadDataNodes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_NumDeadDataNodes",
 			Help:        "NumDeadDataNodes",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		NumDecomLiveDataNodes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_NumDecomLiveDataNodes",
 			Help:        "NumDecomLiveDataNodes",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		NumDecomDeadDataNodes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_NumDecomDeadDataNodes",
 			Help:        "NumDecomDeadDataNodes",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		NumDecommissioningDataNodes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_NumDecommissioningDataNodes",
 			Help:        "NumDecommissioningDataNodes",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		VolumeFailuresTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_VolumeFailuresTotal",
 			Help:        "VolumeFailuresTotal",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		StaleDataNodes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_StaleDataNodes",
 			Help:        "StaleDataNodes",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		RpcQueueTimeNumOps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_RpcQueueTimeNumOps",
 			Help:        "RpcQueueTimeNumOps",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		RpcQueueTimeAvgTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_RpcQueueTimeAvgTime",
 			Help:        "RpcQueueTimeAvgTime",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		RpcProcessingTimeNumOps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_RpcProcessingTimeNumOps",
 			Help:        "RpcProcessingTimeNumOps",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		RpcProcessingTimeAvgTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_RpcProcessingTimeAvgTime",
 			Help:        "RpcProcessingTimeAvgTime",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		pnGcCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_ParNew_CollectionCount",
 			Help:        "ParNew GC Count",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		pnGcTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_ParNew_CollectionTime",
 			Help:        "ParNew GC Time",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		cmsGcCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_ConcurrentMarkSweep_CollectionCount",
 			Help:        "ConcurrentMarkSweep GC Count",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		cmsGcTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_ConcurrentMarkSweep_CollectionTime",
 			Help:        "ConcurrentMarkSweep GC Time",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		heapMemoryUsageCommitted: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_heapMemoryUsageCommitted",
 			Help:        "heapMemoryUsageCommitted",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		heapMemoryUsageInit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_heapMemoryUsageInit",
 			Help:        "heapMemoryUsageInit",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		heapMemoryUsageMax: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_heapMemoryUsageMax",
 			Help:        "heapMemoryUsageMax",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		heapMemoryUsageUsed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_heapMemoryUsageUsed",
 			Help:        "heapMemoryUsageUsed",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		LogFatal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_LogFatal",
 			Help:        "LogFatal",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		LogError: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_LogError",
 			Help:        "LogError",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		LogInfo: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_LogInfo",
 			Help:        "LogInfo",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		LogWarn: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_LogWarn",
 			Help:        "LogWarn",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		Uptime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_Uptime",
 			Help:        "Uptime",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		SystemLoadAverage: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_SystemLoadAverage",
 			Help:        "SystemLoadAverage",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		OpenFileDescriptorCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_OpenFileDescriptorCount",
 			Help:        "OpenFileDescriptorCount",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		MaxFileDescriptorCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_MaxFileDescriptorCount",
 			Help:        "MaxFileDescriptorCount",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		TotalPhysicalMemorySize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_TotalPhysicalMemorySize",
 			Help:        "TotalPhysicalMemorySize",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		FreePhysicalMemorySize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_FreePhysicalMemorySize",
 			Help:        "FreePhysicalMemorySize",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		AvailableProcessors: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_AvailableProcessors",
 			Help:        "AvailableProcessors",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		ServerActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_ServerActive",
 			Help:        "ServerActive",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		isActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_isActive",
 			Help:        "isActive",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
 		LastHATransitionTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "NameNode_LastHATransitionTime",
 			Help:        "LastHATransitionTime",
-			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID},
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		}),
+		HAState: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "NameNode_HAState",
+			Help:        "HA state, 1 active, 0 standby, 2 observer, 3 other, -1 NameNodeStatus bean missing",
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		}),
+		haStateMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "NameNode_HAState_parse_miss_total",
+			Help:        "The number of scrapes in which the NameNodeStatus bean was missing",
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
 		}),
+		NameDirActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "NameNode_NameDirActive",
+			Help:        "NameDirActive",
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		}),
+		NameDirFailed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "NameNode_NameDirFailed",
+			Help:        "NameDirFailed",
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		}),
+		nameDirStatus: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_NameDirStatus"),
+			"The name directory status, 1 active, 0 failed",
+			[]string{"dir", "type"},
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		BlockDeletionStartTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_BlockDeletionStartTime"),
+			"BlockDeletionStartTime",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		PendingDeletionBlocksStuck: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_PendingDeletionBlocksStuck"),
+			"Whether PendingDeletionBlocks has not decreased within block-deletion-stuck-window",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		LockQueueLength: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_LockQueueLength"),
+			"LockQueueLength",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		FSNWriteLockAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_FSNWriteLockAvgTime"),
+			"FSNWriteLockAvgTime",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		FSNReadLockAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_FSNReadLockAvgTime"),
+			"FSNReadLockAvgTime",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		NumTimedOutPendingReconstructions: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_NumTimedOutPendingReconstructions"),
+			"NumTimedOutPendingReconstructions",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		configValid: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_config_valid"),
+			"Whether the exporter parsed all required fields from the config, 1 valid, 0 invalid",
+			[]string{"nameservice", "namenodeid", "rpcport", "httpport"},
+			prometheus.Labels{"serverip": c.ServerIP, "role": "namenode"},
+		),
+		PendingDataNodeMessageCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_PendingDataNodeMessageCount"),
+			"PendingDataNodeMessageCount",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		SlowPeerReported: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_SlowPeerReported"),
+			"The number of datanodes reporting the datanode as a slow peer",
+			[]string{"datanode"},
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		decomUnderReplicatedBlocks: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_DecomNodeUnderReplicatedBlocks"),
+			"The under replicated blocks of each decommissioning datanode",
+			[]string{"node"},
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		RpcAuthenticationFailures: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_RpcAuthenticationFailures_total"),
+			"RpcAuthenticationFailures",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		RpcAuthorizationFailures: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_RpcAuthorizationFailures_total"),
+			"RpcAuthorizationFailures",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		ExpiredHeartbeats: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_ExpiredHeartbeats_total"),
+			"ExpiredHeartbeats",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		NumFilesUnderConstruction: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_NumFilesUnderConstruction"),
+			"NumFilesUnderConstruction",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		NumOpenConnections: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_NumOpenConnections"),
+			"NumOpenConnections",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		GetListingOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_GetListingOps_total"),
+			"GetListingOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		GetListingAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_GetListingAvgTime"),
+			"GetListingAvgTime",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		GetContentSummaryOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_GetContentSummaryOps_total"),
+			"GetContentSummaryOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		GetContentSummaryAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_GetContentSummaryAvgTime"),
+			"GetContentSummaryAvgTime",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		CreateFileOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_CreateFileOps_total"),
+			"CreateFileOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		DeleteFileOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_DeleteFileOps_total"),
+			"DeleteFileOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		TransactionsNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_TransactionsNumOps_total"),
+			"TransactionsNumOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		SyncsNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_SyncsNumOps_total"),
+			"SyncsNumOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		SyncsAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_SyncsAvgTime"),
+			"SyncsAvgTime",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		FsImageLoadTimeMs: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_FsImageLoadTimeMs"),
+			"The time in milliseconds to load the fsimage at startup",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		MostRecentCheckpointTxId: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_MostRecentCheckpointTxId"),
+			"The transaction id of the most recent checkpoint",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		LastAppliedOrWrittenTxId: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_LastAppliedOrWrittenTxId"),
+			"The last applied or written transaction id",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		MissingBlocksWithReplicationFactorOne: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_MissingBlocksWithReplicationFactorOne"),
+			"The number of missing blocks with replication factor 1, which are unrecoverable",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		BytesWithFutureGenerationStamps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_BytesWithFutureGenerationStamps"),
+			"The total bytes of blocks with future generation stamps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		CallerContextOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_CallerContextOps"),
+			"The decayed RPC call volume of the caller in DecayRpcScheduler",
+			[]string{"context"},
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		nonHeapMemoryUsageCommitted: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_nonHeapMemoryUsageCommitted"),
+			"nonHeapMemoryUsageCommitted",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		nonHeapMemoryUsageInit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_nonHeapMemoryUsageInit"),
+			"nonHeapMemoryUsageInit",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		nonHeapMemoryUsageMax: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_nonHeapMemoryUsageMax"),
+			"nonHeapMemoryUsageMax",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		nonHeapMemoryUsageUsed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_nonHeapMemoryUsageUsed"),
+			"nonHeapMemoryUsageUsed",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		HadoopInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_info"),
+			"Information about the Hadoop daemon, value is always 1",
+			[]string{"hostname", "version", "hadoop_version"},
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		LastCheckpointTimeAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_LastCheckpointTime_age_seconds"),
+			"The seconds since the last checkpoint",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		LastHATransitionTimeAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_LastHATransitionTime_age_seconds"),
+			"The seconds since the last HA transition",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		BackendUp: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_backend_up"),
+			"Whether the last JMX request to the backend succeeded, 1 success, 0 failure",
+			[]string{"target"},
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		JvmGcCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_GcCount_total"),
+			"GcCount",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		JvmGcTimeMillis: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_GcTimeMillis_total"),
+			"GcTimeMillis",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		JvmGcNumWarnThresholdExceeded: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_GcNumWarnThresholdExceeded_total"),
+			"GcNumWarnThresholdExceeded",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		JvmGcTimePercentage: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "NameNode_GcTimePercentage"),
+			"GcTimePercentage",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		beanCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_beans"),
+			"The number of JMX beans returned in the last scrape",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		scrapeTimeout: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_scrape_timeout"),
+			"Whether the last scrape exceeded collect.timeout and returned partial metrics, 1 timeout, 0 normal",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
+		schemeMismatch: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_scheme_mismatch"),
+			"Whether the configured dfs.http.policy does not match the scheme the server actually uses, 1 mismatch, 0 match",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "nameservice": c.NameService, "namenodeid": c.NameNodeID, "role": "namenode"},
+		),
 	}
 }
 
@@ -422,8 +909,22 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	e.BlocksTotal.Describe(ch)
 	e.FilesTotal.Describe(ch)
 	e.CorruptBlocks.Describe(ch)
+	e.UnderReplicatedBlocks.Describe(ch)
 	e.ExcessBlocks.Describe(ch)
+	e.PendingDeletionBlocks.Describe(ch)
+	e.NumActiveClients.Describe(ch)
+	e.LastCheckpointTime.Describe(ch)
+	e.NumLiveDataNodes.Describe(ch)
+	e.NumDeadDataNodes.Describe(ch)
+	e.NumDecomLiveDataNodes.Describe(ch)
+	e.NumDecomDeadDataNodes.Describe(ch)
+	e.NumDecommissioningDataNodes.Describe(ch)
+	e.VolumeFailuresTotal.Describe(ch)
 	e.StaleDataNodes.Describe(ch)
+	e.RpcQueueTimeNumOps.Describe(ch)
+	e.RpcQueueTimeAvgTime.Describe(ch)
+	e.RpcProcessingTimeNumOps.Describe(ch)
+	e.RpcProcessingTimeAvgTime.Describe(ch)
 	e.pnGcCount.Describe(ch)
 	e.pnGcTime.Describe(ch)
 	e.cmsGcCount.Describe(ch)
@@ -432,92 +933,351 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	e.heapMemoryUsageInit.Describe(ch)
 	e.heapMemoryUsageMax.Describe(ch)
 	e.heapMemoryUsageUsed.Describe(ch)
+	e.LogFatal.Describe(ch)
+	e.LogError.Describe(ch)
+	e.LogWarn.Describe(ch)
+	e.LogInfo.Describe(ch)
+	e.Uptime.Describe(ch)
+	e.SystemLoadAverage.Describe(ch)
+	e.MaxFileDescriptorCount.Describe(ch)
+	e.OpenFileDescriptorCount.Describe(ch)
+	e.TotalPhysicalMemorySize.Describe(ch)
+	e.FreePhysicalMemorySize.Describe(ch)
+	e.AvailableProcessors.Describe(ch)
+	e.ServerActive.Describe(ch)
 	e.isActive.Describe(ch)
+	e.LastHATransitionTime.Describe(ch)
+	e.HAState.Describe(ch)
+	e.haStateMisses.Describe(ch)
+	e.NameDirActive.Describe(ch)
+	e.NameDirFailed.Describe(ch)
+	ch <- e.nameDirStatus
+	ch <- e.BlockDeletionStartTime
+	ch <- e.PendingDeletionBlocksStuck
+	ch <- e.LockQueueLength
+	ch <- e.FSNWriteLockAvgTime
+	ch <- e.FSNReadLockAvgTime
+	ch <- e.NumTimedOutPendingReconstructions
+	ch <- e.configValid
+	ch <- e.PendingDataNodeMessageCount
+	ch <- e.decomUnderReplicatedBlocks
+	ch <- e.SlowPeerReported
+	ch <- e.RpcAuthenticationFailures
+	ch <- e.RpcAuthorizationFailures
+	ch <- e.ExpiredHeartbeats
+	ch <- e.NumFilesUnderConstruction
+	ch <- e.NumOpenConnections
+	ch <- e.GetListingOps
+	ch <- e.GetListingAvgTime
+	ch <- e.GetContentSummaryOps
+	ch <- e.GetContentSummaryAvgTime
+	ch <- e.CreateFileOps
+	ch <- e.DeleteFileOps
+	ch <- e.TransactionsNumOps
+	ch <- e.SyncsNumOps
+	ch <- e.SyncsAvgTime
+	ch <- e.FsImageLoadTimeMs
+	ch <- e.MostRecentCheckpointTxId
+	ch <- e.LastAppliedOrWrittenTxId
+	ch <- e.MissingBlocksWithReplicationFactorOne
+	ch <- e.BytesWithFutureGenerationStamps
+	ch <- e.CallerContextOps
+	ch <- e.nonHeapMemoryUsageCommitted
+	ch <- e.nonHeapMemoryUsageInit
+	ch <- e.nonHeapMemoryUsageMax
+	ch <- e.nonHeapMemoryUsageUsed
+	ch <- e.HadoopInfo
+	ch <- e.BackendUp
+	ch <- e.LastCheckpointTimeAge
+	ch <- e.LastHATransitionTimeAge
+	ch <- e.JvmGcCount
+	ch <- e.JvmGcTimeMillis
+	ch <- e.JvmGcNumWarnThresholdExceeded
+	ch <- e.JvmGcTimePercentage
+	ch <- e.beanCount
+	ch <- e.scrapeTimeout
+	ch <- e.schemeMismatch
 }
 
-//采集器方法
-func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	resp, err := http.Get(e.url)
-	if err != nil {
-		log.Error(err)
-		e.ServerActive.Set(0)
+// 输出从配置文件解析出的信息，nameservice、namenodeid和端口任一为空都视为无效
+func (e *Exporter) collectConfigValid(ch chan<- prometheus.Metric) {
+	httpPort := e.c.HttpPort
+	if e.c.HttpsOpen {
+		httpPort = e.c.HttpsPort
 	}
-	defer resp.Body.Close()
-	data, err := ioutil.ReadAll(resp.Body)
+	valid := 1.0
+	for _, v := range []string{e.c.NameService, e.c.NameNodeID, e.c.RpcPort, httpPort} {
+		if v == "" {
+			valid = 0
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(e.configValid, prometheus.GaugeValue, valid, e.c.NameService, e.c.NameNodeID, e.c.RpcPort, httpPort)
+}
+
+// 输出本次采集到的bean数量，debug日志中列出没有匹配beanPatterns的bean，用于排查指标缺失，例如RPC端口与配置不同
+func (e *Exporter) collectBeanCount(beans []interface{}, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(e.beanCount, prometheus.GaugeValue, float64(len(beans)))
+	var unmatched []string
+	patterns := e.beanPatterns()
+	for _, bean := range beans {
+		m, _ := bean.(map[string]interface{})
+		name, _ := m["name"].(string)
+		matched := false
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			unmatched = append(unmatched, name)
+		}
+	}
+	if len(unmatched) > 0 {
+		log.Debugf("Beans not used by the collector: %s", strings.Join(unmatched, ", "))
+	}
+}
+
+// 开启jmx.use-query时需要查询的bean
+func (e *Exporter) jmxQueries() []string {
+	if !*jmxUseQuery {
+		return nil
+	}
+	return e.beanPatterns()
+}
+
+// Collect中用到的bean，新增bean的处理时需要同时加到这里
+func (e *Exporter) beanPatterns() []string {
+	return []string{
+		"Hadoop:service=NameNode,name=FSNamesystem",
+		"Hadoop:service=NameNode,name=FSNamesystemState",
+		"Hadoop:service=NameNode,name=JvmMetrics",
+		"Hadoop:service=NameNode,name=NameNodeActivity",
+		"Hadoop:service=NameNode,name=NameNodeInfo",
+		"Hadoop:service=NameNode,name=NameNodeStatus",
+		"Hadoop:service=NameNode,name=RpcActivityForPort" + e.c.RpcPort,
+		"Hadoop:service=NameNode,name=RpcDetailedActivityForPort" + e.c.RpcPort,
+		"Hadoop:service=NameNode,name=DecayRpcSchedulerMetrics2.ipc." + e.c.RpcPort,
+		"java.lang:type=GarbageCollector,name=*",
+		"java.lang:type=Memory",
+		"java.lang:type=OperatingSystem",
+		"java.lang:type=Runtime",
+	}
+}
+
+// 检测配置的协议与实际是否一致，后端不可达时无法判断，下次采集再检测
+func (e *Exporter) collectSchemeMismatch(ctx context.Context, client *http.Client, ch chan<- prometheus.Metric) {
+	e.schemeMutex.Lock()
+	defer e.schemeMutex.Unlock()
+	if !e.schemeChecked {
+		// 不跟随重定向，直接检查Location的协议，跟随后https的证书错误会掩盖重定向
+		probe := *client
+		probe.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		target := e.url + "?qry=" + url.QueryEscape("java.lang:type=Runtime")
+		var resp *http.Response
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err == nil {
+			resp, err = probe.Do(req)
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		e.schemeMismatched = common.SchemeMismatch(target, resp, err)
+		e.schemeChecked = err == nil || e.schemeMismatched
+		if e.schemeMismatched {
+			log.Errorf("Scheme of %s does not match the server, check dfs.http.policy", e.url)
+		}
+	}
+	v := 0.0
+	if e.schemeMismatched {
+		v = 1
+	}
+	ch <- prometheus.MustNewConstMetric(e.schemeMismatch, prometheus.GaugeValue, v)
+}
+
+// 采集器方法，超过collect.timeout时取消未完成的请求，只输出已读取到的指标，并将hadoop_exporter_scrape_timeout置为1
+// 请求在超时后立即返回，采集在本次Collect内结束，不会与下一次采集重叠
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	common.CollectWithTimeout(*collectTimeout, e.scrapeTimeout, ch, func(ctx context.Context) {
+		e.collect(ctx, ch)
+	})
+}
+
+// 采集器方法
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	e.collectSchemeMismatch(ctx, e.client, ch)
+	e.collectConfigValid(ch)
+	nameList, _, err := common.FetchBeans(ctx, e.client, e.url, e.jmxQueries())
+	backendUp := 1.0
 	if err != nil {
-		log.Error(err)
+		backendUp = 0
 	}
-	var f interface{}
-	err = json.Unmarshal(data, &f)
+	ch <- prometheus.MustNewConstMetric(e.BackendUp, prometheus.GaugeValue, backendUp, e.url)
 	if err != nil {
 		log.Error(err)
+		e.ServerActive.Set(0)
+		e.ServerActive.Collect(ch)
+		return
 	}
-	m := f.(map[string]interface{})
-	var nameList = m["beans"].([]interface{})
 	e.ServerActive.Set(1)
+	// NameNodeStatus偶尔会缺失，此时HAState输出-1，避免HA看板断线
+	haState := -1.0
+	filesUnderConstruction, hasFilesUnderConstruction := 0.0, false
+	var hostname, version, hadoopVersion string
+	// NameNodeActivity中的值优先，没有时使用RpcDetailedActivity中的值
+	contentSummary := make(map[*prometheus.Desc]float64)
+	// BlockDeletionStartTime在不同版本中位于FSNamesystem或NameNodeInfo
+	blockDeletionStartTime, hasBlockDeletionStartTime := 0.0, false
+	e.collectBeanCount(nameList, ch)
+	// 字段缺失或不是数值时不输出对应的指标，而不是panic或继续输出上次的值
+	var gauges []prometheus.Gauge
+	setGauge := func(g prometheus.Gauge, bean map[string]interface{}, key string) (float64, bool) {
+		v, ok := common.GetFloat(bean, key)
+		if ok {
+			g.Set(v)
+			gauges = append(gauges, g)
+		}
+		return v, ok
+	}
 	for _, nameData := range nameList {
-		nameDataMap := nameData.(map[string]interface{})
+		nameDataMap, _ := nameData.(map[string]interface{})
+		if v, ok := nameDataMap["tag.Hostname"].(string); ok && hostname == "" {
+			hostname = v
+		}
 		if nameDataMap["name"] == "Hadoop:service=NameNode,name=FSNamesystem" {
-			e.MissingBlocks.Set(nameDataMap["MissingBlocks"].(float64))
-			e.CapacityTotal.Set(nameDataMap["CapacityTotal"].(float64))
-			e.CapacityUsed.Set(nameDataMap["CapacityUsed"].(float64))
-			e.CapacityRemaining.Set(nameDataMap["CapacityRemaining"].(float64))
-			e.CapacityUsedNonDFS.Set(nameDataMap["CapacityUsedNonDFS"].(float64))
-			e.BlocksTotal.Set(nameDataMap["BlocksTotal"].(float64))
-			e.FilesTotal.Set(nameDataMap["FilesTotal"].(float64))
-			e.CorruptBlocks.Set(nameDataMap["CorruptBlocks"].(float64))
-			e.UnderReplicatedBlocks.Set(nameDataMap["UnderReplicatedBlocks"].(float64))
-			e.ExcessBlocks.Set(nameDataMap["ExcessBlocks"].(float64))
-			e.PendingDeletionBlocks.Set(nameDataMap["PendingDeletionBlocks"].(float64))
-			e.NumActiveClients.Set(nameDataMap["NumActiveClients"].(float64))
-			e.LastCheckpointTime.Set(nameDataMap["LastCheckpointTime"].(float64))
+			setGauge(e.MissingBlocks, nameDataMap, "MissingBlocks")
+			setGauge(e.CapacityTotal, nameDataMap, "CapacityTotal")
+			setGauge(e.CapacityUsed, nameDataMap, "CapacityUsed")
+			setGauge(e.CapacityRemaining, nameDataMap, "CapacityRemaining")
+			setGauge(e.CapacityUsedNonDFS, nameDataMap, "CapacityUsedNonDFS")
+			setGauge(e.BlocksTotal, nameDataMap, "BlocksTotal")
+			setGauge(e.FilesTotal, nameDataMap, "FilesTotal")
+			setGauge(e.CorruptBlocks, nameDataMap, "CorruptBlocks")
+			setGauge(e.UnderReplicatedBlocks, nameDataMap, "UnderReplicatedBlocks")
+			setGauge(e.ExcessBlocks, nameDataMap, "ExcessBlocks")
+			if v, ok := setGauge(e.PendingDeletionBlocks, nameDataMap, "PendingDeletionBlocks"); ok && *deletionStuckWindow > 0 {
+				ch <- prometheus.MustNewConstMetric(e.PendingDeletionBlocksStuck, prometheus.GaugeValue, e.pendingDeletionStuck(v))
+			}
+			if v, ok := common.GetFloat(nameDataMap, "BlockDeletionStartTime"); ok {
+				blockDeletionStartTime, hasBlockDeletionStartTime = v, true
+			}
+			setGauge(e.NumActiveClients, nameDataMap, "NumActiveClients")
+			if v, ok := setGauge(e.LastCheckpointTime, nameDataMap, "LastCheckpointTime"); ok && *timeAsAge && v > 0 {
+				ch <- prometheus.MustNewConstMetric(e.LastCheckpointTimeAge, prometheus.GaugeValue, ageSeconds(v))
+			}
+			if v, ok := common.GetFloat(nameDataMap, "LockQueueLength"); ok {
+				ch <- prometheus.MustNewConstMetric(e.LockQueueLength, prometheus.GaugeValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "FSNWriteLockAvgTime"); ok {
+				ch <- prometheus.MustNewConstMetric(e.FSNWriteLockAvgTime, prometheus.GaugeValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "FSNReadLockAvgTime"); ok {
+				ch <- prometheus.MustNewConstMetric(e.FSNReadLockAvgTime, prometheus.GaugeValue, v)
+			}
+			// 2.x中该指标名为NumTimedOutPendingReplications
+			if v, ok := common.GetFloat(nameDataMap, "NumTimedOutPendingReconstructions"); ok {
+				ch <- prometheus.MustNewConstMetric(e.NumTimedOutPendingReconstructions, prometheus.GaugeValue, v)
+			} else if v, ok := common.GetFloat(nameDataMap, "NumTimedOutPendingReplications"); ok {
+				ch <- prometheus.MustNewConstMetric(e.NumTimedOutPendingReconstructions, prometheus.GaugeValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "PendingDataNodeMessageCount"); ok {
+				ch <- prometheus.MustNewConstMetric(e.PendingDataNodeMessageCount, prometheus.GaugeValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "ExpiredHeartbeats"); ok {
+				ch <- prometheus.MustNewConstMetric(e.ExpiredHeartbeats, prometheus.CounterValue, v)
+			}
+		}
+		if nameDataMap["name"] == "Hadoop:service=NameNode,name=FSNamesystem" || nameDataMap["name"] == "Hadoop:service=NameNode,name=FSNamesystemState" {
+			// 新版本为NumFilesUnderConstruction，旧版本为FilesUnderConstruction，两个bean都可能有
+			for _, key := range []string{"NumFilesUnderConstruction", "FilesUnderConstruction"} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok && !hasFilesUnderConstruction {
+					filesUnderConstruction, hasFilesUnderConstruction = v, true
+				}
+			}
 		}
 		if nameDataMap["name"] == "Hadoop:service=NameNode,name=FSNamesystemState" {
-			e.NumLiveDataNodes.Set(nameDataMap["NumLiveDataNodes"].(float64))
-			e.NumDeadDataNodes.Set(nameDataMap["NumDeadDataNodes"].(float64))
-			e.NumDecomLiveDataNodes.Set(nameDataMap["NumDecomLiveDataNodes"].(float64))
-			e.NumDecomDeadDataNodes.Set(nameDataMap["NumDecomDeadDataNodes"].(float64))
-			e.NumDecommissioningDataNodes.Set(nameDataMap["NumDecommissioningDataNodes"].(float64))
-			e.VolumeFailuresTotal.Set(nameDataMap["VolumeFailuresTotal"].(float64))
-			e.StaleDataNodes.Set(nameDataMap["NumStaleDataNodes"].(float64))
+			setGauge(e.NumLiveDataNodes, nameDataMap, "NumLiveDataNodes")
+			setGauge(e.NumDeadDataNodes, nameDataMap, "NumDeadDataNodes")
+			setGauge(e.NumDecomLiveDataNodes, nameDataMap, "NumDecomLiveDataNodes")
+			setGauge(e.NumDecomDeadDataNodes, nameDataMap, "NumDecomDeadDataNodes")
+			setGauge(e.NumDecommissioningDataNodes, nameDataMap, "NumDecommissioningDataNodes")
+			setGauge(e.VolumeFailuresTotal, nameDataMap, "VolumeFailuresTotal")
+			setGauge(e.StaleDataNodes, nameDataMap, "NumStaleDataNodes")
+		}
+		if nameDataMap["name"] == "Hadoop:service=NameNode,name=DecayRpcSchedulerMetrics2.ipc."+e.c.RpcPort && *callerTopN > 0 {
+			e.collectCallerVolume(nameDataMap, ch)
 		}
 		if nameDataMap["name"] == "Hadoop:service=NameNode,name=RpcActivityForPort"+e.c.RpcPort {
-			e.RpcQueueTimeNumOps.Set(nameDataMap["RpcQueueTimeNumOps"].(float64))
-			e.RpcQueueTimeAvgTime.Set(nameDataMap["RpcQueueTimeAvgTime"].(float64))
-			e.RpcProcessingTimeNumOps.Set(nameDataMap["RpcProcessingTimeNumOps"].(float64))
-			e.RpcProcessingTimeAvgTime.Set(nameDataMap["RpcProcessingTimeAvgTime"].(float64))
+			setGauge(e.RpcQueueTimeNumOps, nameDataMap, "RpcQueueTimeNumOps")
+			setGauge(e.RpcQueueTimeAvgTime, nameDataMap, "RpcQueueTimeAvgTime")
+			setGauge(e.RpcProcessingTimeNumOps, nameDataMap, "RpcProcessingTimeNumOps")
+			setGauge(e.RpcProcessingTimeAvgTime, nameDataMap, "RpcProcessingTimeAvgTime")
+			if v, ok := common.GetFloat(nameDataMap, "RpcAuthenticationFailures"); ok {
+				ch <- prometheus.MustNewConstMetric(e.RpcAuthenticationFailures, prometheus.CounterValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "RpcAuthorizationFailures"); ok {
+				ch <- prometheus.MustNewConstMetric(e.RpcAuthorizationFailures, prometheus.CounterValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "NumOpenConnections"); ok {
+				ch <- prometheus.MustNewConstMetric(e.NumOpenConnections, prometheus.GaugeValue, v)
+			}
 		}
 		if nameDataMap["name"] == "java.lang:type=GarbageCollector,name=ParNew" {
-			e.pnGcCount.Set(nameDataMap["CollectionCount"].(float64))
-			e.pnGcTime.Set(nameDataMap["CollectionTime"].(float64))
+			setGauge(e.pnGcCount, nameDataMap, "CollectionCount")
+			setGauge(e.pnGcTime, nameDataMap, "CollectionTime")
 		}
 		if nameDataMap["name"] == "java.lang:type=GarbageCollector,name=ConcurrentMarkSweep" {
-			e.cmsGcCount.Set(nameDataMap["CollectionCount"].(float64))
-			e.cmsGcTime.Set(nameDataMap["CollectionTime"].(float64))
+			setGauge(e.cmsGcCount, nameDataMap, "CollectionCount")
+			setGauge(e.cmsGcTime, nameDataMap, "CollectionTime")
 		}
 		if nameDataMap["name"] == "java.lang:type=Memory" {
-			heapMemoryUsage := nameDataMap["HeapMemoryUsage"].(map[string]interface{})
-			e.heapMemoryUsageCommitted.Set(heapMemoryUsage["committed"].(float64))
-			e.heapMemoryUsageInit.Set(heapMemoryUsage["init"].(float64))
-			e.heapMemoryUsageMax.Set(heapMemoryUsage["max"].(float64))
-			e.heapMemoryUsageUsed.Set(heapMemoryUsage["used"].(float64))
+			heapMemoryUsage, _ := nameDataMap["HeapMemoryUsage"].(map[string]interface{})
+			setGauge(e.heapMemoryUsageCommitted, heapMemoryUsage, "committed")
+			setGauge(e.heapMemoryUsageInit, heapMemoryUsage, "init")
+			setGauge(e.heapMemoryUsageMax, heapMemoryUsage, "max")
+			setGauge(e.heapMemoryUsageUsed, heapMemoryUsage, "used")
+			nonHeapMemoryUsage, _ := nameDataMap["NonHeapMemoryUsage"].(map[string]interface{})
+			for key, desc := range map[string]*prometheus.Desc{
+				"committed": e.nonHeapMemoryUsageCommitted,
+				"init":      e.nonHeapMemoryUsageInit,
+				"max":       e.nonHeapMemoryUsageMax,
+				"used":      e.nonHeapMemoryUsageUsed,
+			} {
+				if v, ok := common.GetFloat(nonHeapMemoryUsage, key); ok {
+					ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+				}
+			}
 		}
 		if nameDataMap["name"] == "Hadoop:service=NameNode,name=JvmMetrics" {
-			e.LogError.Set(nameDataMap["LogError"].(float64))
-			e.LogFatal.Set(nameDataMap["LogFatal"].(float64))
-			e.LogInfo.Set(nameDataMap["LogInfo"].(float64))
-			e.LogWarn.Set(nameDataMap["LogWarn"].(float64))
+			setGauge(e.LogError, nameDataMap, "LogError")
+			setGauge(e.LogFatal, nameDataMap, "LogFatal")
+			setGauge(e.LogInfo, nameDataMap, "LogInfo")
+			setGauge(e.LogWarn, nameDataMap, "LogWarn")
+			for key, desc := range map[string]*prometheus.Desc{
+				"GcCount":                    e.JvmGcCount,
+				"GcTimeMillis":               e.JvmGcTimeMillis,
+				"GcNumWarnThresholdExceeded": e.JvmGcNumWarnThresholdExceeded,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v)
+				}
+			}
+			if v, ok := common.GetFloat(nameDataMap, "GcTimePercentage"); ok {
+				ch <- prometheus.MustNewConstMetric(e.JvmGcTimePercentage, prometheus.GaugeValue, v)
+			}
 		}
 		if nameDataMap["name"] == "java.lang:type=Runtime" {
-			e.Uptime.Set(nameDataMap["Uptime"].(float64))
+			setGauge(e.Uptime, nameDataMap, "Uptime")
 		}
 		if nameDataMap["name"] == "java.lang:type=OperatingSystem" {
-			e.SystemLoadAverage.Set(nameDataMap["SystemLoadAverage"].(float64))
-			e.OpenFileDescriptorCount.Set(nameDataMap["OpenFileDescriptorCount"].(float64))
-			e.TotalPhysicalMemorySize.Set(nameDataMap["TotalPhysicalMemorySize"].(float64))
-			e.FreePhysicalMemorySize.Set(nameDataMap["FreePhysicalMemorySize"].(float64))
-			e.MaxFileDescriptorCount.Set(nameDataMap["MaxFileDescriptorCount"].(float64))
-			e.AvailableProcessors.Set(nameDataMap["AvailableProcessors"].(float64))
+			setGauge(e.SystemLoadAverage, nameDataMap, "SystemLoadAverage")
+			setGauge(e.OpenFileDescriptorCount, nameDataMap, "OpenFileDescriptorCount")
+			setGauge(e.TotalPhysicalMemorySize, nameDataMap, "TotalPhysicalMemorySize")
+			setGauge(e.FreePhysicalMemorySize, nameDataMap, "FreePhysicalMemorySize")
+			setGauge(e.MaxFileDescriptorCount, nameDataMap, "MaxFileDescriptorCount")
+			setGauge(e.AvailableProcessors, nameDataMap, "AvailableProcessors")
 		}
 		if nameDataMap["name"] == "Hadoop:service=NameNode,name=NameNodeStatus" {
 			if nameDataMap["State"] == "active" {
@@ -525,71 +1285,434 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 			} else {
 				e.isActive.Set(0)
 			}
-			e.LastHATransitionTime.Set(nameDataMap["LastHATransitionTime"].(float64))
-		}
-	}
-	e.MissingBlocks.Collect(ch)
-	e.CapacityTotal.Collect(ch)
-	e.CapacityUsed.Collect(ch)
-	e.CapacityRemaining.Collect(ch)
-	e.CapacityUsedNonDFS.Collect(ch)
-	e.BlocksTotal.Collect(ch)
-	e.FilesTotal.Collect(ch)
-	e.CorruptBlocks.Collect(ch)
-	e.UnderReplicatedBlocks.Collect(ch)
-	e.ExcessBlocks.Collect(ch)
-	e.PendingDeletionBlocks.Collect(ch)
-	e.NumActiveClients.Collect(ch)
-	e.LastCheckpointTime.Collect(ch)
-	e.NumLiveDataNodes.Collect(ch)
-	e.NumDeadDataNodes.Collect(ch)
-	e.NumDecomLiveDataNodes.Collect(ch)
-	e.NumDecomDeadDataNodes.Collect(ch)
-	e.NumDecommissioningDataNodes.Collect(ch)
-	e.VolumeFailuresTotal.Collect(ch)
-	e.StaleDataNodes.Collect(ch)
-	e.RpcQueueTimeNumOps.Collect(ch)
-	e.RpcQueueTimeAvgTime.Collect(ch)
-	e.RpcProcessingTimeNumOps.Collect(ch)
-	e.RpcProcessingTimeAvgTime.Collect(ch)
-	e.pnGcCount.Collect(ch)
-	e.pnGcTime.Collect(ch)
-	e.cmsGcCount.Collect(ch)
-	e.cmsGcTime.Collect(ch)
-	e.heapMemoryUsageCommitted.Collect(ch)
-	e.heapMemoryUsageInit.Collect(ch)
-	e.heapMemoryUsageMax.Collect(ch)
-	e.heapMemoryUsageUsed.Collect(ch)
-	e.LogFatal.Collect(ch)
-	e.LogError.Collect(ch)
-	e.LogInfo.Collect(ch)
-	e.LogWarn.Collect(ch)
-	e.Uptime.Collect(ch)
-	e.SystemLoadAverage.Collect(ch)
-	e.MaxFileDescriptorCount.Collect(ch)
-	e.OpenFileDescriptorCount.Collect(ch)
-	e.TotalPhysicalMemorySize.Collect(ch)
-	e.FreePhysicalMemorySize.Collect(ch)
-	e.AvailableProcessors.Collect(ch)
+			switch nameDataMap["State"] {
+			case "active":
+				haState = 1
+			case "standby":
+				haState = 0
+			case "observer":
+				haState = 2
+			default:
+				haState = 3
+			}
+			// SlowPeersReport是JSON字符串，格式为[{"SlowNode":"节点","ReportingNodes":["节点"]}]，未开启时没有该字段或为null
+			if v, ok := nameDataMap["SlowPeersReport"].(string); ok && v != "" {
+				var slowPeers []struct {
+					SlowNode       string   `json:"SlowNode"`
+					ReportingNodes []string `json:"ReportingNodes"`
+				}
+				if err := json.Unmarshal([]byte(v), &slowPeers); err != nil {
+					log.Error(err)
+				}
+				for _, p := range slowPeers {
+					ch <- prometheus.MustNewConstMetric(e.SlowPeerReported, prometheus.GaugeValue, float64(len(p.ReportingNodes)), p.SlowNode)
+				}
+			}
+			if v, ok := setGauge(e.LastHATransitionTime, nameDataMap, "LastHATransitionTime"); ok && *timeAsAge && v > 0 {
+				ch <- prometheus.MustNewConstMetric(e.LastHATransitionTimeAge, prometheus.GaugeValue, ageSeconds(v))
+			}
+		}
+		if nameDataMap["name"] == "Hadoop:service=NameNode,name=NameNodeActivity" {
+			if v, ok := common.GetFloat(nameDataMap, "GetListingOps"); ok {
+				ch <- prometheus.MustNewConstMetric(e.GetListingOps, prometheus.CounterValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "GetListingAvgTime"); ok {
+				ch <- prometheus.MustNewConstMetric(e.GetListingAvgTime, prometheus.GaugeValue, v)
+			}
+			for key, desc := range map[string]*prometheus.Desc{
+				"GetContentSummaryOps":     e.GetContentSummaryOps,
+				"GetContentSummaryAvgTime": e.GetContentSummaryAvgTime,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					contentSummary[desc] = v
+				}
+			}
+			if v, ok := common.GetFloat(nameDataMap, "CreateFileOps"); ok {
+				ch <- prometheus.MustNewConstMetric(e.CreateFileOps, prometheus.CounterValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "DeleteFileOps"); ok {
+				ch <- prometheus.MustNewConstMetric(e.DeleteFileOps, prometheus.CounterValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "TransactionsNumOps"); ok {
+				ch <- prometheus.MustNewConstMetric(e.TransactionsNumOps, prometheus.CounterValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "SyncsNumOps"); ok {
+				ch <- prometheus.MustNewConstMetric(e.SyncsNumOps, prometheus.CounterValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "SyncsAvgTime"); ok {
+				ch <- prometheus.MustNewConstMetric(e.SyncsAvgTime, prometheus.GaugeValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "FsImageLoadTime"); ok {
+				ch <- prometheus.MustNewConstMetric(e.FsImageLoadTimeMs, prometheus.GaugeValue, v)
+			}
+		}
+		if nameDataMap["name"] == "Hadoop:service=NameNode,name=RpcDetailedActivityForPort"+e.c.RpcPort {
+			for key, desc := range map[string]*prometheus.Desc{
+				"GetContentSummaryNumOps":  e.GetContentSummaryOps,
+				"GetContentSummaryAvgTime": e.GetContentSummaryAvgTime,
+			} {
+				if _, found := contentSummary[desc]; found {
+					continue
+				}
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					contentSummary[desc] = v
+				}
+			}
+		}
+		if nameDataMap["name"] == "Hadoop:service=NameNode,name=NameNodeInfo" {
+			version, hadoopVersion = versionInfo(nameDataMap)
+			if v, ok := common.GetFloat(nameDataMap, "BlockDeletionStartTime"); ok {
+				blockDeletionStartTime, hasBlockDeletionStartTime = v, true
+			}
+			// NameDirStatuses是一个JSON字符串，格式为{"active":{"目录":"类型"},"failed":{"目录":"类型"}}
+			if v, ok := nameDataMap["NameDirStatuses"].(string); ok {
+				var nameDirStatuses map[string]map[string]string
+				if err := json.Unmarshal([]byte(v), &nameDirStatuses); err != nil {
+					log.Error(err)
+				} else {
+					e.NameDirActive.Set(float64(len(nameDirStatuses["active"])))
+					e.NameDirFailed.Set(float64(len(nameDirStatuses["failed"])))
+					for dir, dirType := range nameDirStatuses["active"] {
+						ch <- prometheus.MustNewConstMetric(e.nameDirStatus, prometheus.GaugeValue, 1, dir, dirType)
+					}
+					for dir, dirType := range nameDirStatuses["failed"] {
+						ch <- prometheus.MustNewConstMetric(e.nameDirStatus, prometheus.GaugeValue, 0, dir, dirType)
+					}
+				}
+			}
+			// DecomNodes也是JSON字符串，格式为{"节点":{"underReplicatedBlocks":0,...}}，下线进度停滞时该值不再下降
+			if v, ok := nameDataMap["DecomNodes"].(string); ok {
+				var decomNodes map[string]map[string]interface{}
+				if err := json.Unmarshal([]byte(v), &decomNodes); err != nil {
+					log.Error(err)
+				} else {
+					for node, status := range decomNodes {
+						if n, ok := common.GetFloat(status, "underReplicatedBlocks"); ok {
+							ch <- prometheus.MustNewConstMetric(e.decomUnderReplicatedBlocks, prometheus.GaugeValue, n, node)
+						}
+					}
+				}
+			}
+			// JournalTransactionInfo是JSON字符串，事务ID也是字符串，例如{"MostRecentCheckpointTxId":"100"}
+			if v, ok := nameDataMap["JournalTransactionInfo"].(string); ok {
+				var txInfo map[string]string
+				if err := json.Unmarshal([]byte(v), &txInfo); err != nil {
+					log.Error(err)
+				} else {
+					for key, desc := range map[string]*prometheus.Desc{
+						"MostRecentCheckpointTxId": e.MostRecentCheckpointTxId,
+						"LastAppliedOrWrittenTxId": e.LastAppliedOrWrittenTxId,
+					} {
+						if txID, err := strconv.ParseFloat(txInfo[key], 64); err == nil {
+							ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, txID)
+						}
+					}
+				}
+			}
+			if v, ok := common.GetFloat(nameDataMap, "NumberOfMissingBlocksWithReplicationFactorOne"); ok {
+				ch <- prometheus.MustNewConstMetric(e.MissingBlocksWithReplicationFactorOne, prometheus.GaugeValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "BytesWithFutureGenerationStamps"); ok {
+				ch <- prometheus.MustNewConstMetric(e.BytesWithFutureGenerationStamps, prometheus.GaugeValue, v)
+			}
+		}
+	}
+	if haState == -1 {
+		e.haStateMisses.Inc()
+	}
+	if v, ok := contentSummary[e.GetContentSummaryOps]; ok {
+		ch <- prometheus.MustNewConstMetric(e.GetContentSummaryOps, prometheus.CounterValue, v)
+	}
+	if v, ok := contentSummary[e.GetContentSummaryAvgTime]; ok {
+		ch <- prometheus.MustNewConstMetric(e.GetContentSummaryAvgTime, prometheus.GaugeValue, v)
+	}
+	if version != "" {
+		ch <- prometheus.MustNewConstMetric(e.HadoopInfo, prometheus.GaugeValue, 1, hostname, version, hadoopVersion)
+	}
+	if hasBlockDeletionStartTime {
+		ch <- prometheus.MustNewConstMetric(e.BlockDeletionStartTime, prometheus.GaugeValue, blockDeletionStartTime)
+	}
+	e.HAState.Set(haState)
+	if hasFilesUnderConstruction {
+		ch <- prometheus.MustNewConstMetric(e.NumFilesUnderConstruction, prometheus.GaugeValue, filesUnderConstruction)
+	}
+	for _, g := range gauges {
+		g.Collect(ch)
+	}
 	e.ServerActive.Collect(ch)
 	e.isActive.Collect(ch)
-	e.LastHATransitionTime.Collect(ch)
+	e.HAState.Collect(ch)
+	e.haStateMisses.Collect(ch)
+	e.NameDirActive.Collect(ch)
+	e.NameDirFailed.Collect(ch)
+}
+
+// 从NameNodeInfo中读取版本，Version带有编译信息，SoftwareVersion只有版本号，旧版本没有SoftwareVersion
+func versionInfo(bean map[string]interface{}) (version, hadoopVersion string) {
+	version, _ = bean["Version"].(string)
+	hadoopVersion, _ = bean["SoftwareVersion"].(string)
+	if hadoopVersion == "" {
+		hadoopVersion = strings.TrimSpace(strings.Split(version, ",")[0])
+	}
+	return version, hadoopVersion
+}
+
+// 一段时间内的采样，用于判断数值在这段时间内的变化
+type timeSample struct {
+	time  time.Time
+	value float64
+}
+
+// 记录本次的PendingDeletionBlocks，采样覆盖了整个窗口、当前值大于0且窗口内从未下降时返回1
+// 删除的同时有新的删除请求时值也可能不变，只要有一次下降就认为队列在消化
+func (e *Exporter) pendingDeletionStuck(v float64) float64 {
+	e.deletionMutex.Lock()
+	defer e.deletionMutex.Unlock()
+	now := time.Now()
+	e.deletionSamples = append(e.deletionSamples, timeSample{time: now, value: v})
+	// 保留窗口开始前的最后一个采样作为基线
+	for len(e.deletionSamples) > 1 && now.Sub(e.deletionSamples[1].time) >= *deletionStuckWindow {
+		e.deletionSamples = e.deletionSamples[1:]
+	}
+	if v == 0 || now.Sub(e.deletionSamples[0].time) < *deletionStuckWindow {
+		return 0
+	}
+	for i := 1; i < len(e.deletionSamples); i++ {
+		if e.deletionSamples[i].value < e.deletionSamples[i-1].value {
+			return 0
+		}
+	}
+	return 1
+}
+
+// 毫秒时间戳距离本次采集的秒数
+func ageSeconds(epochMs float64) float64 {
+	return float64(time.Now().UnixNano())/1e9 - epochMs/1000
+}
+
+// 最近一次采集时后端是否可达
+func (e *Exporter) up() bool {
+	var m dto.Metric
+	if err := e.ServerActive.Write(&m); err != nil {
+		return false
+	}
+	return m.GetGauge().GetValue() == 1
+}
+
+// 采集一次指标并以文本格式输出到标准输出，与服务模式使用同一个已注册的采集器
+func oneshot(exporters []*Exporter) error {
+	mfs, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(os.Stdout, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	for _, exporter := range exporters {
+		if !exporter.up() {
+			return fmt.Errorf("backend %s is unreachable", exporter.url)
+		}
+	}
+	return nil
+}
+
+// 配置文件读取成功并注册采集器后为true，用于/ready
+var ready atomic.Bool
+
+// 环境变量中的配置优先于XML配置
+func applyEnvConf(c *HDFSConf) {
+	for env, v := range map[string]*string{
+		"HADOOP_EXPORTER_NAMESERVICE": &c.NameService,
+		"HADOOP_EXPORTER_NAMENODE_ID": &c.NameNodeID,
+		"HADOOP_EXPORTER_RPC_PORT":    &c.RpcPort,
+		"HADOOP_EXPORTER_HTTP_PORT":   &c.HttpPort,
+		"HADOOP_EXPORTER_HTTPS_PORT":  &c.HttpsPort,
+	} {
+		if s, ok := os.LookupEnv(env); ok {
+			*v = s
+		}
+	}
+	if s, ok := os.LookupEnv("HADOOP_EXPORTER_HTTPS"); ok {
+		c.HttpsOpen = s == "true"
+	}
+}
+
+// DecayRpcScheduler中每个调用方的字段为Caller(<调用方>).Volume，按调用量从大到小只输出前callerTopN个
+// 调用方默认是用户，配置了基于caller context的IdentityProvider时是caller context
+func (e *Exporter) collectCallerVolume(bean map[string]interface{}, ch chan<- prometheus.Metric) {
+	type caller struct {
+		context string
+		volume  float64
+	}
+	var callers []caller
+	for key := range bean {
+		if !strings.HasPrefix(key, "Caller(") || !strings.HasSuffix(key, ").Volume") {
+			continue
+		}
+		if v, ok := common.GetFloat(bean, key); ok {
+			callers = append(callers, caller{strings.TrimSuffix(strings.TrimPrefix(key, "Caller("), ").Volume"), v})
+		}
+	}
+	sort.Slice(callers, func(i, j int) bool {
+		if callers[i].volume != callers[j].volume {
+			return callers[i].volume > callers[j].volume
+		}
+		return callers[i].context < callers[j].context
+	})
+	if len(callers) > *callerTopN {
+		callers = callers[:*callerTopN]
+	}
+	for _, c := range callers {
+		ch <- prometheus.MustNewConstMetric(e.CallerContextOps, prometheus.GaugeValue, c.volume, c.context)
+	}
+}
+
+// 根据配置生成采集器
+func createExporters(xmlConf *common.XMLConf, conf *HDFSConf) []*Exporter {
+	if *jmxURL == "" {
+		namenodeJmxUrl := ""
+		if conf.HttpsOpen {
+			namenodeJmxUrl = "https://" + net.JoinHostPort(conf.ServerIP, conf.HttpsPort) + *jmxPath
+		} else {
+			namenodeJmxUrl = "http://" + net.JoinHostPort(conf.ServerIP, conf.HttpPort) + *jmxPath
+		}
+		return []*Exporter{NewExporter(namenodeJmxUrl, conf, *metricNamespace)}
+	}
+	// 一个进程采集多个NameNode，比如HA的两个NameNode，每个地址注册一个Exporter
+	var exporters []*Exporter
+	for _, u := range strings.Split(*jmxURL, ",") {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		exporters = append(exporters, NewExporter(u, CreateTargetConf(u, conf, xmlConf), *metricNamespace))
+	}
+	return exporters
+}
+
+// 注册到registry的采集器集合，重新读取配置时整体替换，采集过程中不会出现一部分采集器已注销的情况
+type exporterSet struct {
+	mu        sync.RWMutex
+	exporters []*Exporter
+}
+
+// 配置变化后标签会变化，Desc不固定，因此不发送Desc，作为unchecked采集器注册
+func (s *exporterSet) Describe(ch chan<- *prometheus.Desc) {}
+
+func (s *exporterSet) Collect(ch chan<- prometheus.Metric) {
+	s.mu.RLock()
+	exporters := s.exporters
+	s.mu.RUnlock()
+	for _, exporter := range exporters {
+		exporter.Collect(ch)
+	}
+}
+
+// 先在单独的registry中检查新的采集器能否注册，例如多个地址生成了相同的标签，检查通过后才替换，否则保留原来的采集器
+func (s *exporterSet) replace(exporters []*Exporter) error {
+	r := prometheus.NewRegistry()
+	for _, exporter := range exporters {
+		if err := r.Register(exporter); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.exporters = exporters
+	s.mu.Unlock()
+	return nil
+}
+
+// 收到SIGHUP时重新读取配置，配置变化可能导致标签变化，因此替换全部采集器
+func reloadOnSIGHUP(set *exporterSet) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		xmlConf, err := common.ReadXmlOrEmpty(*clientConfFile)
+		if err != nil {
+			log.Errorf("Reload config failed: %s", err)
+			continue
+		}
+		if err := set.replace(createExporters(xmlConf, CreateHDFSConf(xmlConf))); err != nil {
+			log.Errorf("Register reloaded exporters failed, keep the previous config: %s", err)
+			continue
+		}
+		log.Info("Config reloaded")
+	}
+}
+
+// /metrics的处理器，同时处理的采集请求超过web.max-requests时返回503
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{MaxRequestsInFlight: *maxRequests}))
 }
 
-func main() {
-	flag.Parse()
+// 运行exporter，args为子命令之后的参数
+func Main(args []string) {
+	flags.Parse(args)
+	common.FlagsFromEnv(flags)
 	log.Info("Hadoop Exporter make By Lijiadong(Meepod) (๑•̀ㅂ•́)و✧")
-	conf := CreateHDFSConf(ReadXml(*clientConfFile))
-	namenodeJmxUrl := ""
-	if conf.HttpsOpen {
-		namenodeJmxUrl = "https://" + conf.ServerIP + ":" + conf.HttpsPort + "/jmx"
+	// 等待配置期间先启动HTTP服务，/ready返回503，配置读取成功后才注册/metrics
+	serveErr := make(chan error, 1)
+	if *configWait > 0 && !*oneshotMode {
+		go func() {
+			serveErr <- serve()
+		}()
+	}
+	xmlConf, err := common.ReadXmlWithWait(*clientConfFile, *configWait)
+	if err != nil {
+		log.Fatal(err)
+	}
+	transport, err = common.NewTransport(*httpProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *relabelConfigFile != "" {
+		gatherer, err = common.RelabelGatherer(prometheus.DefaultGatherer, *relabelConfigFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	conf := CreateHDFSConf(xmlConf)
+	exporters := createExporters(xmlConf, conf)
+	set := &exporterSet{}
+	if err := set.replace(exporters); err != nil {
+		log.Fatal(err)
+	}
+	prometheus.MustRegister(set)
+	if *oneshotMode {
+		if err := oneshot(exporters); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	go reloadOnSIGHUP(set)
+	log.Printf("Starting Server: %s", listenAddress)
+	if *pushGateway != "" {
+		go common.PushMetrics(*pushGateway, *pushInterval, "namenode", conf.ServerIP, gatherer)
+	}
+	http.Handle(*metricsPath, metricsHandler())
+	ready.Store(true)
+	if *configWait > 0 {
+		err = <-serveErr
 	} else {
-		namenodeJmxUrl = "http://" + conf.ServerIP + ":" + conf.HttpPort + "/jmx"
+		err = serve()
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
-	exporter := NewExporter(namenodeJmxUrl, conf)
-	prometheus.MustRegister(exporter)
-	log.Printf("Starting Server: %s", *listenAddress)
-	http.Handle(*metricsPath, prometheus.Handler())
+}
+
+// 启动HTTP服务，/metrics在配置读取成功后单独注册
+func serve() error {
+	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "config is not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK"))
+	})
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 		<head><title>NameNode Exporter</title></head>
@@ -600,8 +1723,11 @@ func main() {
 		</body>
 		</html>`))
 	})
-	err := http.ListenAndServe(*listenAddress, nil)
-	if err != nil {
-		log.Fatal(err)
+	server := &http.Server{}
+	flagConfig := &web.FlagConfig{
+		WebListenAddresses: &listenAddress.Values,
+		WebSystemdSocket:   systemdSocket,
+		WebConfigFile:      webConfigFile,
 	}
+	return web.ListenAndServe(server, flagConfig, kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr)))
 }
@@ -0,0 +1,377 @@
+package namenode
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"hadoop_exporter/internal/common"
+	"hadoop_exporter/internal/testutil"
+)
+
+// 包含Collect中处理的所有bean
+const nameNodeJMX = `{"beans":[
+	{"name":"Hadoop:service=NameNode,name=FSNamesystem","tag.Hostname":"nn1","MissingBlocks":1,"CapacityTotal":1000,"CapacityUsed":400,"CapacityRemaining":500,"CapacityUsedNonDFS":100,
+		"BlocksTotal":20,"FilesTotal":30,"CorruptBlocks":0,"UnderReplicatedBlocks":2,"ExcessBlocks":0,"PendingDeletionBlocks":5,"NumActiveClients":3,"LastCheckpointTime":1700000000000,
+		"LockQueueLength":0,"FSNWriteLockAvgTime":1.5,"FSNReadLockAvgTime":0.5,"NumTimedOutPendingReconstructions":0,"PendingDataNodeMessageCount":0,"ExpiredHeartbeats":1,"BlockDeletionStartTime":1700000000000},
+	{"name":"Hadoop:service=NameNode,name=FSNamesystemState","NumLiveDataNodes":3,"NumDeadDataNodes":0,"NumDecomLiveDataNodes":0,"NumDecomDeadDataNodes":0,"NumDecommissioningDataNodes":0,
+		"VolumeFailuresTotal":0,"NumStaleDataNodes":0,"FilesUnderConstruction":2},
+	{"name":"Hadoop:service=NameNode,name=DecayRpcSchedulerMetrics2.ipc.8020","Caller(alice).Volume":50,"Caller(bob).Volume":"70"},
+	{"name":"Hadoop:service=NameNode,name=RpcActivityForPort8020","RpcQueueTimeNumOps":10,"RpcQueueTimeAvgTime":0.1,"RpcProcessingTimeNumOps":10,"RpcProcessingTimeAvgTime":0.2,
+		"RpcAuthenticationFailures":0,"RpcAuthorizationFailures":0,"NumOpenConnections":4},
+	{"name":"Hadoop:service=NameNode,name=RpcDetailedActivityForPort8020","GetContentSummaryNumOps":3,"GetContentSummaryAvgTime":2},
+	{"name":"java.lang:type=GarbageCollector,name=ParNew","CollectionCount":5,"CollectionTime":50},
+	{"name":"java.lang:type=GarbageCollector,name=ConcurrentMarkSweep","CollectionCount":1,"CollectionTime":100},
+	{"name":"java.lang:type=Memory","HeapMemoryUsage":{"committed":100,"init":50,"max":200,"used":80},"NonHeapMemoryUsage":{"committed":10,"init":5,"max":-1,"used":8}},
+	{"name":"Hadoop:service=NameNode,name=JvmMetrics","LogError":0,"LogFatal":0,"LogInfo":10,"LogWarn":1,"GcCount":6,"GcTimeMillis":150,"GcNumWarnThresholdExceeded":0,"GcTimePercentage":1},
+	{"name":"java.lang:type=Runtime","Uptime":1000},
+	{"name":"java.lang:type=OperatingSystem","SystemLoadAverage":0.5,"OpenFileDescriptorCount":100,"TotalPhysicalMemorySize":1000,"FreePhysicalMemorySize":500,"MaxFileDescriptorCount":65536,"AvailableProcessors":4},
+	{"name":"Hadoop:service=NameNode,name=NameNodeStatus","State":"active","LastHATransitionTime":1700000000000,"SlowPeersReport":"[{\"SlowNode\":\"dn1:9866\",\"ReportingNodes\":[\"dn2:9866\"]}]"},
+	{"name":"Hadoop:service=NameNode,name=NameNodeActivity","GetListingOps":5,"GetListingAvgTime":1,"CreateFileOps":2,"DeleteFileOps":1,"TransactionsNumOps":100,"SyncsNumOps":50,"SyncsAvgTime":0.3,"FsImageLoadTime":2000},
+	{"name":"Hadoop:service=NameNode,name=NameNodeInfo","Version":"3.1.1, rabc","SoftwareVersion":"3.1.1",
+		"NameDirStatuses":"{\"active\":{\"/data/nn\":\"IMAGE_AND_EDITS\"},\"failed\":{}}",
+		"DecomNodes":"{\"dn3:9866\":{\"underReplicatedBlocks\":4}}",
+		"JournalTransactionInfo":"{\"MostRecentCheckpointTxId\":\"100\",\"LastAppliedOrWrittenTxId\":\"120\"}",
+		"NumberOfMissingBlocksWithReplicationFactorOne":0,"BytesWithFutureGenerationStamps":0}
+]}`
+
+func newTestExporter(url string) *Exporter {
+	return NewExporter(url, &HDFSConf{RpcPort: "8020", ServerIP: "127.0.0.1", NameService: "ns1", NameNodeID: "nn1"}, "")
+}
+
+// 开启所有可选指标后，采集到的指标都要在Describe中声明
+func TestPedanticRegistry(t *testing.T) {
+	testutil.SetFlag(t, flags, "time.as-age", "true")
+	testutil.SetFlag(t, flags, "namenode.caller-top-n", "1")
+	testutil.SetFlag(t, flags, "block-deletion-stuck-window", "1h")
+	srv := testutil.NewJMXServer(t, nameNodeJMX)
+	mfs := testutil.Gather(t, newTestExporter(srv.URL+"/jmx"))
+	for name, want := range map[string]float64{
+		"NameNode_ServerActive":  1,
+		"NameNode_CapacityTotal": 1000,
+		"NameNode_HAState":       1,
+	} {
+		if got, ok := testutil.MetricValue(mfs, name, nil); !ok || got != want {
+			t.Errorf("%s = %v (found %v), want %v", name, got, ok, want)
+		}
+	}
+}
+
+// 设置--metric.namespace后所有指标都带前缀，包括hadoop_exporter_*等自身的指标
+func TestMetricNamespace(t *testing.T) {
+	srv := testutil.NewJMXServer(t, nameNodeJMX)
+	e := NewExporter(srv.URL+"/jmx", &HDFSConf{RpcPort: "8020", ServerIP: "127.0.0.1", NameService: "ns1", NameNodeID: "nn1"}, "test")
+	testutil.CheckNamespace(t, testutil.Gather(t, e), "test")
+}
+
+// 部分版本把数值编码成字符串，部分字段缺失，此时解析字符串并跳过缺失的指标，不能panic
+func TestCollectStringAndMissingFields(t *testing.T) {
+	testutil.SetFlag(t, flags, "time.as-age", "true")
+	testutil.SetFlag(t, flags, "block-deletion-stuck-window", "1h")
+	srv := testutil.NewJMXServer(t, `{"beans":[
+		{"name":"Hadoop:service=NameNode,name=FSNamesystem","CapacityTotal":"1000","MissingBlocks":" 2 ","LastCheckpointTime":"x"},
+		{"name":"Hadoop:service=NameNode,name=FSNamesystemState","NumLiveDataNodes":"3"},
+		{"name":"java.lang:type=Memory","HeapMemoryUsage":{"used":"80"}},
+		{"name":"Hadoop:service=NameNode,name=JvmMetrics","LogWarn":"1"},
+		{"name":"java.lang:type=Runtime"},
+		{"name":"Hadoop:service=NameNode,name=NameNodeStatus","State":"standby"},
+		{"name":"Hadoop:service=NameNode,name=NameNodeInfo","Version":"3.1.1, rabc"}
+	]}`)
+	mfs := testutil.Gather(t, newTestExporter(srv.URL+"/jmx"))
+	for name, want := range map[string]float64{
+		"NameNode_CapacityTotal":       1000,
+		"NameNode_MissingBlocks":       2,
+		"NameNode_NumLiveDataNodes":    3,
+		"NameNode_heapMemoryUsageUsed": 80,
+		"NameNode_LogWarn":             1,
+		"NameNode_HAState":             0,
+	} {
+		if got, ok := testutil.MetricValue(mfs, name, nil); !ok || got != want {
+			t.Errorf("%s = %v (found %v), want %v", name, got, ok, want)
+		}
+	}
+	for _, name := range []string{
+		"NameNode_CapacityUsed",
+		"NameNode_PendingDeletionBlocks",
+		"NameNode_PendingDeletionBlocksStuck",
+		"NameNode_LastCheckpointTime",
+		"NameNode_LastCheckpointTime_age_seconds",
+		"NameNode_NumDeadDataNodes",
+		"NameNode_heapMemoryUsageMax",
+		"NameNode_LogError",
+		"NameNode_Uptime",
+		"NameNode_LastHATransitionTime",
+	} {
+		if v, ok := testutil.MetricValue(mfs, name, nil); ok {
+			t.Errorf("%s = %v, want missing", name, v)
+		}
+	}
+}
+
+// 同时处理的请求超过web.max-requests时直接返回503
+func TestMaxRequests(t *testing.T) {
+	testutil.SetFlag(t, flags, "web.max-requests", "1")
+	testutil.CheckMaxRequests(t, func(g prometheus.Gatherer) http.Handler {
+		old := gatherer
+		gatherer = g
+		t.Cleanup(func() { gatherer = old })
+		return metricsHandler()
+	})
+}
+
+// 解析hdfs-site.xml格式的配置
+func parseConf(t *testing.T, props string) *common.XMLConf {
+	t.Helper()
+	var x common.XMLConf
+	if err := xml.Unmarshal([]byte("<configuration>"+props+"</configuration>"), &x); err != nil {
+		t.Fatal(err)
+	}
+	return &x
+}
+
+func TestCreateHDFSConf(t *testing.T) {
+	testutil.SetFlag(t, flags, "local.ip", "127.0.0.1")
+	h, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prop := func(name, value string) string {
+		return "<property><name>" + name + "</name><value>" + value + "</value></property>"
+	}
+	for _, c := range []struct {
+		name  string
+		props string
+		want  HDFSConf
+	}{
+		{
+			name:  "single NameNode with default ports",
+			props: prop("dfs.namenode.rpc-address.other", "other:9000") + prop("dfs.namenode.rpc-address", h+":8020"),
+			want:  HDFSConf{RpcPort: "8020", HttpPort: "9870"},
+		},
+		{
+			name: "whitespace",
+			props: prop(" dfs.namenode.rpc-address\n", " "+h+":8020 ") +
+				prop("\n\tdfs.namenode.http-address ", "\n\t"+h+":50070\n"),
+			want: HDFSConf{RpcPort: "8020", HttpPort: "50070"},
+		},
+		{
+			// nn10的配置项名和主机名都以nn1的为前缀，并且排在前面
+			name: "HA",
+			props: prop("dfs.internal.nameservices", "ns1") + prop("dfs.ha.namenodes.ns1", "nn10, nn1,nn2") +
+				prop("dfs.namenode.rpc-address.ns1.nn10", h+"0:8030") + prop("dfs.namenode.http-address.ns1.nn10", h+"0:50080") +
+				prop("dfs.namenode.rpc-address.ns1.nn1", h+":8021") + prop("dfs.namenode.http-address.ns1.nn1", h+":50071") +
+				prop("dfs.namenode.rpc-address.ns1.nn2", "other:8022") + prop("dfs.namenode.http-address.ns1.nn2", "other:50072"),
+			want: HDFSConf{NameService: "ns1", NameNodeID: "nn1", RpcPort: "8021", HttpPort: "50071"},
+		},
+		{
+			name:  "https",
+			props: prop("dfs.http.policy", "HTTPS_ONLY") + prop("dfs.namenode.rpc-address", h+":8020"),
+			want:  HDFSConf{RpcPort: "8020", HttpsOpen: true, HttpsPort: "9871"},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			c.want.ServerIP = "127.0.0.1"
+			if got := CreateHDFSConf(parseConf(t, c.props)); *got != c.want {
+				t.Errorf("CreateHDFSConf() = %+v, want %+v", *got, c.want)
+			}
+		})
+	}
+}
+
+// 新的采集器不能注册时保留原来的采集器，能注册时整体替换
+func TestExporterSetReplace(t *testing.T) {
+	srv := testutil.NewJMXServer(t, nameNodeJMX)
+	exporterFor := func(id string) *Exporter {
+		return NewExporter(srv.URL+"/jmx", &HDFSConf{ServerIP: "127.0.0.1", NameService: "ns1", NameNodeID: id}, "")
+	}
+	hasNameNode := func(set *exporterSet, id string) bool {
+		_, ok := testutil.MetricValue(testutil.Gather(t, set), "NameNode_ServerActive", map[string]string{"namenodeid": id})
+		return ok
+	}
+	set := &exporterSet{}
+	if err := set.replace([]*Exporter{exporterFor("nn1")}); err != nil {
+		t.Fatal(err)
+	}
+	// 两个采集器的标签相同，不能同时注册
+	if err := set.replace([]*Exporter{exporterFor("nn2"), exporterFor("nn2")}); err == nil {
+		t.Error("replace with duplicate exporters succeeded")
+	}
+	if !hasNameNode(set, "nn1") || hasNameNode(set, "nn2") {
+		t.Error("failed replace changed the registered exporters")
+	}
+	if err := set.replace([]*Exporter{exporterFor("nn2")}); err != nil {
+		t.Fatal(err)
+	}
+	if hasNameNode(set, "nn1") || !hasNameNode(set, "nn2") {
+		t.Error("replace did not swap the registered exporters")
+	}
+}
+
+// JMX无响应时请求在get.timeout-seconds后返回，NameNode_ServerActive为0
+func TestCollectClientTimeout(t *testing.T) {
+	testutil.SetFlag(t, flags, "get.timeout-seconds", "1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(srv.Close)
+	start := time.Now()
+	mfs := testutil.Gather(t, newTestExporter(srv.URL+"/jmx"))
+	if d := time.Since(start); d > 4*time.Second {
+		t.Errorf("collect took %s, want about 1s", d)
+	}
+	if v, ok := testutil.MetricValue(mfs, "NameNode_ServerActive", nil); !ok || v != 0 {
+		t.Errorf("NameNode_ServerActive = %v (found %v), want 0", v, ok)
+	}
+}
+
+// 超过collect.timeout时取消未完成的查询，已读取到的bean对应的指标（包括Gauge）正常输出
+func TestCollectTimeoutPartial(t *testing.T) {
+	testutil.SetFlag(t, flags, "jmx.use-query", "true")
+	testutil.SetFlag(t, flags, "collect.timeout", "300ms")
+	var f struct {
+		Beans []map[string]interface{} `json:"beans"`
+	}
+	if err := json.Unmarshal([]byte(nameNodeJMX), &f); err != nil {
+		t.Fatal(err)
+	}
+	fast := map[string]string{"java.lang:type=Runtime": `{"beans":[]}`}
+	for _, bean := range f.Beans {
+		if bean["name"] == "Hadoop:service=NameNode,name=FSNamesystem" {
+			data, _ := json.Marshal(map[string]interface{}{"beans": []interface{}{bean}})
+			fast["Hadoop:service=NameNode,name=FSNamesystem"] = string(data)
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, ok := fast[r.URL.Query().Get("qry")]; ok {
+			w.Write([]byte(body))
+			return
+		}
+		// 其余的bean一直等到请求被取消
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	start := time.Now()
+	mfs := testutil.Gather(t, newTestExporter(srv.URL+"/jmx"))
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("collect took %s, want about 300ms", d)
+	}
+	for name, want := range map[string]float64{
+		"hadoop_exporter_scrape_timeout": 1,
+		"NameNode_ServerActive":          1,
+		"NameNode_CapacityTotal":         1000,
+	} {
+		if got, ok := testutil.MetricValue(mfs, name, nil); !ok || got != want {
+			t.Errorf("%s = %v (found %v), want %v", name, got, ok, want)
+		}
+	}
+}
+
+// 反向代理在请求没有Accept-Encoding时也可能返回gzip压缩的内容，此时需要自行解压
+func TestCollectGzipBody(t *testing.T) {
+	// 关闭Transport的自动解压，模拟Transport没有处理压缩的情况
+	old := transport
+	transport = &http.Transport{DisableCompression: true}
+	t.Cleanup(func() { transport = old })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(nameNodeJMX))
+		gz.Close()
+	}))
+	t.Cleanup(srv.Close)
+	mfs := testutil.Gather(t, newTestExporter(srv.URL+"/jmx"))
+	if v, ok := testutil.MetricValue(mfs, "NameNode_CapacityTotal", nil); !ok || v != 1000 {
+		t.Errorf("NameNode_CapacityTotal = %v (found %v), want 1000", v, ok)
+	}
+}
+
+// IPv6地址需要加上方括号
+func TestCreateExportersIPv6(t *testing.T) {
+	for _, c := range []struct {
+		conf HDFSConf
+		want string
+	}{
+		{HDFSConf{ServerIP: "fe80::1", HttpPort: "9870"}, "http://[fe80::1]:9870/jmx"},
+		{HDFSConf{ServerIP: "fe80::1", HttpsOpen: true, HttpsPort: "9871"}, "https://[fe80::1]:9871/jmx"},
+	} {
+		if exporters := createExporters(&common.XMLConf{}, &c.conf); len(exporters) != 1 || exporters[0].url != c.want {
+			t.Errorf("createExporters(%+v) = %v, want %s", c.conf, exporters, c.want)
+		}
+	}
+}
+
+// 指定--local.ip后不解析主机名，按该IP判断本机的NameNode并原样用于serverip标签
+func TestCreateHDFSConfLocalIP(t *testing.T) {
+	testutil.SetFlag(t, flags, "local.ip", "10.1.2.3")
+	prop := func(name, value string) string {
+		return "<property><name>" + name + "</name><value>" + value + "</value></property>"
+	}
+	got := CreateHDFSConf(parseConf(t, prop("dfs.internal.nameservices", "ns1")+prop("dfs.ha.namenodes.ns1", "nn1,nn2")+
+		prop("dfs.namenode.rpc-address.ns1.nn1", "10.1.2.30:8021")+prop("dfs.namenode.rpc-address.ns1.nn2", "10.1.2.3:8022")))
+	want := HDFSConf{ServerIP: "10.1.2.3", NameService: "ns1", NameNodeID: "nn2", RpcPort: "8022", HttpPort: "9870"}
+	if *got != want {
+		t.Errorf("CreateHDFSConf() = %+v, want %+v", *got, want)
+	}
+}
+
+// 推送时去掉role标签不能改动Desc中的常量标签，推送之后的采集仍然正常
+func TestPushThenGather(t *testing.T) {
+	srv := testutil.NewJMXServer(t, nameNodeJMX)
+	r := prometheus.NewPedanticRegistry()
+	r.MustRegister(newTestExporter(srv.URL + "/jmx"))
+	old := gatherer
+	gatherer = r
+	t.Cleanup(func() { gatherer = old })
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(gw.Close)
+	for i := 0; i < 2; i++ {
+		if _, err := gatherer.Gather(); err != nil {
+			t.Fatalf("gather %d: %s", i, err)
+		}
+		if err := common.NewPusher(gw.URL, "namenode", "127.0.0.1", gatherer).Push(); err != nil {
+			t.Fatalf("push %d: %s", i, err)
+		}
+	}
+	if _, err := gatherer.Gather(); err != nil {
+		t.Fatalf("gather after push: %s", err)
+	}
+}
+
+// 子命令的-log.level=debug生效后，debug日志中列出未使用的bean
+// prometheus/log在初始化时绑定了os.Stderr，因此在子进程中采集并检查子进程的stderr
+func TestLogLevelDebug(t *testing.T) {
+	if level := os.Getenv("HADOOP_EXPORTER_TEST_LOG_LEVEL"); level != "" {
+		if err := flags.Parse([]string{"-log.level=" + level}); err != nil {
+			t.Fatal(err)
+		}
+		srv := testutil.NewJMXServer(t, `{"beans":[{"name":"Hadoop:service=NameNode,name=UnusedBean"}]}`)
+		testutil.Gather(t, newTestExporter(srv.URL+"/jmx"))
+		return
+	}
+	for level, want := range map[string]bool{"debug": true, "info": false} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestLogLevelDebug$")
+		cmd.Env = append(os.Environ(), "HADOOP_EXPORTER_TEST_LOG_LEVEL="+level)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("-log.level=%s: %s\n%s", level, err, stderr.String())
+		}
+		if got := strings.Contains(stderr.String(), "Beans not used by the collector: Hadoop:service=NameNode,name=UnusedBean"); got != want {
+			t.Errorf("-log.level=%s: debug output %v, want %v\n%s", level, got, want, stderr.String())
+		}
+	}
+}
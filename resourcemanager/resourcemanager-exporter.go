@@ -1,19 +1,34 @@
-package main
+package resourcemanager
 
 import (
+	"context"
 	"encoding/json"
-	"encoding/xml"
-	"flag"
-	"io/ioutil"
+	"fmt"
+	"math"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	kitlog "github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/exporter-toolkit/web"
 	"github.com/prometheus/log"
+	"golang.org/x/sync/errgroup"
+
+	"hadoop_exporter/internal/common"
 )
 
 // 设计上，resourcemanger需要手动探测活跃节点
@@ -21,25 +36,87 @@ const (
 	httpsmode = false
 )
 
-var (
-	listenAddress  = flag.String("web.listen-address", ":9075", "暴露指标的监听地址，默认9075.") //设置成ip:port的格式，似乎更容易进行更改
-	metricsPath    = flag.String("web.telemetry-path", "/metrics", "暴露指标的路由.")
-	clientConfFile = flag.String("yarn-site.path", "/etc/hadoop/conf/yarn-site.xml", "")
-	timeout        = flag.String("get.timeout-seconds", "5", "请求超时的时间")
-)
+// 开启metrics percentiles后ClusterMetrics中的分位数字段，例如AMLaunchDelay60s75thPercentileTime
+var amDelayPercentileRegexp = regexp.MustCompile(`^(AMLaunchDelay|AMRegisterDelay)(?:(\d+)s)?(\d+)thPercentile(?:Time|Latency)$`)
 
-//读取配置，从客户端配置中读取需要的信息
-type XMLConf struct {
-	XMLName   xml.Name    `xml:"configuration"`
-	NameValue []NameValue `xml:"property"`
+// QueueMetrics中被抢占的累计值，例如AggregateContainersPreempted、AggregateMemoryMBSecondsPreempted
+var preemptedRegexp = regexp.MustCompile(`^Aggregate(\w+)Preempted$`)
+
+// 健康报告标签的最大长度，超过时截断
+const maxHealthReportLength = 200
+
+// 不同调度器暴露调度耗时的bean不同，这里记录bean名称以及调度次数、平均耗时的字段名
+var schedulerBeans = []struct {
+	scheduler, bean, numOps, avgTime string
+}{
+	{"capacity", "Hadoop:service=ResourceManager,name=CapacitySchedulerMetrics", "AllocateNumOps", "AllocateAvgTime"},
+	{"fair", "Hadoop:service=ResourceManager,name=FSOpDurations", "UpdateThreadRunNumOps", "UpdateThreadRunAvgTime"},
 }
 
-type NameValue struct {
-	Name  string `xml:"name"`
-	Value string `xml:"value"`
-	Final string `xml:"final"`
+// 调度器上一次运行的次数和观察到的时间，用于判断调度器是否卡住
+type schedulerRun struct {
+	numOps float64
+	time   time.Time
 }
 
+// 子命令的参数，由hadoop-exporter resourcemanager之后的参数解析
+var flags = common.NewFlagSet("hadoop-exporter resourcemanager")
+
+var (
+	listenAddress  = common.StringsVar(flags, "web.listen-address", ":9075", "暴露指标的监听地址，可重复指定以监听多个地址，默认9075.") //设置成ip:port的格式，似乎更容易进行更改
+	metricsPath    = flags.String("web.telemetry-path", "/metrics", "暴露指标的路由.")
+	systemdSocket  = flags.Bool("web.systemd-socket", false, "使用systemd socket activation提供的监听，而不是web.listen-address.")
+	webConfigFile  = flags.String("web.config.file", "", "开启TLS或basic auth的配置文件路径，为空时使用HTTP.")
+	clientConfFile = flags.String("yarn-site.path", "/etc/hadoop/conf/yarn-site.xml", "")
+	timeout        = flags.String("get.timeout-seconds", "5", "请求超时的时间")
+	pushGateway    = flags.String("push.gateway", "", "Pushgateway地址，设置后定时推送指标.")
+	pushInterval   = flags.Duration("push.interval", 15*time.Second, "推送到Pushgateway的间隔.")
+	// REST接口采集开关
+	collectClusterMetrics = flags.Bool("collect.cluster-metrics", false, "通过REST接口/ws/v1/cluster/metrics采集集群总资源.")
+	scrapeConcurrency     = flags.Int("scrape.concurrency", 4, "一次采集中并发请求的接口数，小于等于0时不限制.")
+	collectScheduler      = flags.Bool("collect.scheduler", false, "通过REST接口/ws/v1/cluster/scheduler采集各队列的容量、使用率和AM资源限制.")
+	collectQueueUsers     = flags.Bool("collect.queue-users", false, "开启collect.scheduler时，按用户采集各队列的内存使用，用户较多时指标数量很大.")
+	collectNodeHealth     = flags.Bool("collect.node-health", false, "通过REST接口/ws/v1/cluster/nodes采集不健康NM的健康报告和下线中NM剩余的容器数.")
+	collectRMInfo         = flags.Bool("collect.rm-info", false, "通过REST接口/ws/v1/cluster/info采集HA状态和启动时间，standby的RM也会输出.")
+	// 每个NM输出一组指标，NM较多时指标数量较大
+	collectNodeUtilization = flags.Bool("collect.node-utilization", false, "通过REST接口/ws/v1/cluster/nodes采集每个NM实际使用和已分配的内存、CPU，用于发现容器申请的资源远大于实际使用.")
+	// 指标名前缀，设置后指标名为<namespace>_ResourceManager_XXX
+	metricNamespace = flags.String("metric.namespace", "", "指标名前缀，为空时保持原有指标名.")
+	// 按bean查询JMX，减少ResourceManager的CPU和传输量
+	jmxUseQuery = flags.Bool("jmx.use-query", false, "按需要的bean使用qry参数分别查询JMX，而不是获取完整的/jmx.")
+	// 只采集一次，用于调试和在CI中校验配置
+	oneshotMode = flags.Bool("oneshot", false, "采集一次指标并以文本格式输出到标准输出后退出，后端不可达时返回非0.")
+	// 访问后端的代理，为空时使用环境变量中的代理设置
+	httpProxy = flags.String("http.proxy", "", "请求后端时使用的代理地址，为空时使用HTTP_PROXY、HTTPS_PROXY和NO_PROXY环境变量.")
+	// 通过Knox等网关访问时JMX不在/jmx下
+	jmxPath = flags.String("jmx.path", "/jmx", "JMX接口的路径，通过Knox等网关访问时改为网关中的路径，例如/gateway/default/yarn/jmx.")
+	// 后端很慢时避免整个/metrics阻塞到Prometheus超时
+	collectTimeout = flags.Duration("collect.timeout", 0, "单次采集的超时时间，超时后只返回已采集到的指标，为0时不限制.")
+	// 在exporter端按规则保留或丢弃指标，用于控制高基数指标
+	relabelConfigFile = flags.String("metric.relabel-config", "", "指标过滤规则文件路径，按指标名和标签值保留或丢弃指标，为空时不过滤.")
+	// 容器中主机名可能解析到其他网卡的IP或无法解析
+	localIPAddr = flags.String("local.ip", "", "本机IP，原样用于serverip标签和判断本机是否为active节点，为空时通过解析主机名得到.")
+	// 毫秒时间戳在面板中经常被误当作秒，开启后额外输出距离采集时的秒数
+	timeAsAge = flags.Bool("time.as-age", false, "为StartTime等毫秒时间戳指标额外输出距离采集时的秒数*_age_seconds，原指标保持不变.")
+	// 多个Prometheus同时采集时每次采集都会请求后端，限制并发避免放大后端的压力
+	maxRequests = flags.Int("web.max-requests", 4, "同时处理的采集请求数上限，超过时返回503，为0时不限制.")
+	// Kubernetes中configmap可能晚于容器挂载，等待配置文件而不是直接退出
+	configWait = flags.Duration("config.wait", 0, "配置文件不存在或无法解析时重试的最长时间，等待期间/ready返回503，为0时不等待.")
+	// JMX和REST接口经过不同的代理或负载均衡时分别指定，默认都在yarn-site.xml中本机的webapp地址上
+	jmxURL  = flags.String("jmx.url", "", "ResourceManager的JMX地址，例如http://rm1:8088/jmx，为空时根据yarn-site.xml生成本机地址.")
+	restURL = flags.String("rest.url", "", "REST接口的基础地址，例如http://yarn-lb:8088，为空时与JMX地址使用同一个主机和端口.")
+	// 调度器各操作的耗时，用于区分任务等待是调度变慢还是集群资源不足
+	collectSchedulerMetrics = flags.Bool("collect.scheduler-metrics", false, "采集调度器各操作的次数和平均耗时，例如分配容器的Allocate，根据调度器类型读取CapacitySchedulerMetrics或FSOpDurations.")
+	// 任务长时间处于ACCEPTED一般是AM拿不到容器
+	acceptedThreshold = flags.Int64("apps.accepted-threshold-ms", 0, "通过REST接口/ws/v1/cluster/apps?states=ACCEPTED统计处于ACCEPTED超过该毫秒数的任务数，为0时不采集.")
+)
+
+// 请求后端使用的Transport，在main中根据http.proxy设置
+var transport http.RoundTripper = http.DefaultTransport
+
+// 暴露和推送指标使用的Gatherer，在main中根据metric.relabel-config设置
+var gatherer prometheus.Gatherer = prometheus.DefaultGatherer
+
 type YARNConf struct {
 	RpcPort          string //RPC端口
 	ServerIP         string //ResourceManger IP
@@ -47,11 +124,15 @@ type YARNConf struct {
 	HttpsOpen        bool   //是否开启https
 	HttpPort         string //http端口
 	HttpsPort        string //https端口
+	// CapacityScheduler通过yarn.resourcemanager.scheduler.monitor.enable开启抢占，FairScheduler通过yarn.scheduler.fair.preemption
+	PreemptionEnabled bool
 }
 
 type Exporter struct {
 	url string
-	c   YARNConf
+	// REST接口的基础地址，不包含/ws/v1，默认为去掉jmx.path的url
+	restURL string
+	c       YARNConf
 	// 总览信息"Hadoop:service=ResourceManager,name=ClusterMetrics"
 	NumActiveNMs           prometheus.Gauge // 活动NM
 	NumLostNMs             prometheus.Gauge // 失联NM
@@ -64,6 +145,13 @@ type Exporter struct {
 	AMLaunchDelayAvgTime   prometheus.Gauge // AM启动延迟
 	AMRegisterDelayNumOps  prometheus.Gauge // AM注册数量
 	AMRegisterDelayAvgTime prometheus.Gauge // AM注册延迟
+	// JMX只提供当前失联和重启的NM数，这里根据两次采集之间的增长累计事件数，并记录最近一次事件的时间
+	nmEventsMutex           sync.Mutex
+	lastNMCounts            map[string]float64
+	NMLostEvents            prometheus.Counter
+	LastNMLostTimestamp     prometheus.Gauge
+	NMRebootedEvents        prometheus.Counter
+	LastNMRebootedTimestamp prometheus.Gauge
 	// 资源总览 Hadoop:service=ResourceManager,name=QueueMetrics,q0=root,q1=default
 	// 总量算法：allocated+availabled+reserved
 	AllocatedVCores prometheus.Gauge // 已分配的vcore
@@ -74,6 +162,11 @@ type Exporter struct {
 	AvailableMB     prometheus.Gauge // 可用内存
 	PendingMB       prometheus.Gauge // 等待分配的内存
 	ReservedMB      prometheus.Gauge // 驻留内存
+	// 与上面的MB指标相同，单位换算为bytes，和其他内存指标保持一致
+	AllocatedBytes prometheus.Gauge
+	AvailableBytes prometheus.Gauge
+	PendingBytes   prometheus.Gauge
+	ReservedBytes  prometheus.Gauge
 	// 任务运行指标
 	AppsSubmitted prometheus.Gauge // 提交任务总数
 	AppsRunning   prometheus.Gauge // 在运行的任务数
@@ -113,463 +206,1994 @@ type Exporter struct {
 	ServerActive            prometheus.Gauge // 服务状态
 	//其他健康指标
 	isActive prometheus.Gauge //是否是Active的
+	// 集群总资源 "/ws/v1/cluster/metrics"
+	ClusterTotalMB           *prometheus.Desc // 集群总内存
+	ClusterTotalVirtualCores *prometheus.Desc // 集群总vcore
+	ClusterTotalNodes        *prometheus.Desc // 集群NM总数
+	ClusterLostNodes         *prometheus.Desc // 失联NM数量
+	ClusterUnhealthyNodes    *prometheus.Desc // 不健康的NM数量
+	ClusterAppsPending       *prometheus.Desc // 等待资源的任务数
+	// 处于ACCEPTED超过apps.accepted-threshold-ms的任务数 "/ws/v1/cluster/apps?states=ACCEPTED"
+	AppsAcceptedLongRunning *prometheus.Desc
+	// 不健康NM的健康报告，例如local dir bad，只输出不健康的节点 "/ws/v1/cluster/nodes"
+	NodeManagerHealthInfo *prometheus.Desc
+	// 优雅下线中的NM上还在运行的容器数，为0时可以关机 "/ws/v1/cluster/nodes"
+	NMDecommissioningRemainingContainers *prometheus.Desc
+	// NM已分配和实际使用的资源，实际使用来自resourceUtilization，旧版本没有时不输出 "/ws/v1/cluster/nodes"
+	NodeUsedMemoryMB               *prometheus.Desc // 已分配给容器的内存
+	NodeUsedVirtualCores           *prometheus.Desc // 已分配给容器的vcore
+	NodePhysicalMemoryMB           *prometheus.Desc // 节点实际使用的物理内存
+	NodeCPUUsage                   *prometheus.Desc // 节点实际的CPU使用率
+	NodeContainersPhysicalMemoryMB *prometheus.Desc // 所有容器实际使用的物理内存
+	NodeContainersCPUUsage         *prometheus.Desc // 所有容器实际的CPU使用率
+	// GC指标，按收集器区分 "name": "java.lang:type=GarbageCollector,name=XX"
+	gcCollectionCount *prometheus.Desc
+	gcCollectionTime  *prometheus.Desc // GC累计耗时，JMX中单位为毫秒，换算为秒
+	// 从配置文件解析出的信息，必需项为空时值为0，用于发现自动识别配置出错
+	configValid *prometheus.Desc
+	// AM启动和注册延迟的分位数，仅开启metrics percentiles时存在，指标类型不是summary，因此分位数标签为percentile而不是quantile
+	AMLaunchDelayPercentileTime   *prometheus.Desc
+	AMRegisterDelayPercentileTime *prometheus.Desc
+	// AM容器的分配延迟，与启动、注册延迟一起可以拆分AM启动的各阶段耗时，旧版本没有
+	AMContainerAllocationDelayNumOps  *prometheus.Desc
+	AMContainerAllocationDelayAvgTime *prometheus.Desc
+	// 调度器健康指标，调度次数长时间不变说明调度器卡住
+	schedulerMutex               sync.Mutex
+	schedulerTypeMutex           sync.Mutex
+	schedulerType                string // "/ws/v1/cluster/scheduler"中的schedulerInfo.type，例如capacityScheduler、fairScheduler
+	lastSchedulerRun             map[string]schedulerRun
+	SchedulerRunNumOps           *prometheus.Desc // 调度次数
+	SchedulerRunAvgTime          *prometheus.Desc // 调度平均耗时
+	SchedulerSecondsSinceLastRun *prometheus.Desc // 距离调度次数上次变化的秒数
+	// 调度器各操作的次数和平均耗时，例如CapacityScheduler的Allocate、CommitSuccess、CommitFailure，需要开启collect.scheduler-metrics
+	SchedulerOpNumOps  *prometheus.Desc // 操作次数，累计值
+	SchedulerOpAvgTime *prometheus.Desc // 操作平均耗时
+	// 队列容量，单位为占集群资源的百分比 "/ws/v1/cluster/scheduler"
+	QueueCapacity     *prometheus.Desc // 队列保证的容量，FairScheduler为fair share
+	QueueMaxCapacity  *prometheus.Desc // 队列最大容量
+	QueueUsedCapacity *prometheus.Desc // 队列已使用的容量
+	QueueIsLeaf       *prometheus.Desc // 是否为叶子队列，父队列的用量包含子队列，按队列求和时需要只取叶子队列
+	// CapacityScheduler叶子队列的AM资源限制，用量达到限制时新任务会一直处于ACCEPTED状态
+	AMResourceLimitMB     *prometheus.Desc
+	AMResourceLimitVCores *prometheus.Desc
+	usedAMResourceMB      *prometheus.Desc
+	// 各队列的容器数，小容器很多的任务容器数比内存更能反映压力 "name": "Hadoop:service=ResourceManager,name=QueueMetrics,q0=XX,q1=XX"
+	QueuePendingContainers   *prometheus.Desc
+	QueueAllocatedContainers *prometheus.Desc
+	QueueReservedContainers  *prometheus.Desc
+	// 各队列的任务数，用于按队列区分告警，集群总数见AppsRunning等
+	QueueAppsSubmitted *prometheus.Desc // 提交任务数，累计值
+	QueueAppsRunning   *prometheus.Desc
+	QueueAppsPending   *prometheus.Desc
+	// 各队列被抢占的容器和资源，累计值，root队列即集群总数，未开启抢占时不输出，例如AggregateContainersPreempted
+	QueueAggregatePreempted *prometheus.Desc
+	// 各队列任务的第一个容器的分配延迟，2.9和3.x才有
+	QueueFirstContainerAllocationDelayNumOps  *prometheus.Desc
+	QueueFirstContainerAllocationDelayAvgTime *prometheus.Desc
+	// CapacityScheduler叶子队列的用户，用于解释队列未满但用户的任务被限制的情况 "/ws/v1/cluster/scheduler"
+	QueueActiveUsers      *prometheus.Desc
+	QueueUserUsedMemoryMB *prometheus.Desc // 需要开启collect.queue-users
+	// 状态存储的健康指标，状态存储异常时无法提交任务 "name": "Hadoop:service=ResourceManager,name=ZKRMStateStoreOpDurations"
+	StateStoreOpNumOps  *prometheus.Desc // 各操作的次数，累计值
+	StateStoreOpAvgTime *prometheus.Desc // 各操作的平均耗时
+	StateStoreFenced    *prometheus.Desc // 状态存储是否被fence，社区版本没有暴露，仅在bean中有该字段时输出
+	// 预留系统的接受和拒绝次数，累计值，未开启预留系统时没有对应的bean "name": "Hadoop:service=ResourceManager,name=XXReservationXX"
+	ReservationOps *prometheus.Desc
+	// 非堆内存，包括metaspace和code cache，metaspace泄漏同样会导致OOM "name": "java.lang:type=Memory"
+	nonHeapMemoryUsageCommitted *prometheus.Desc
+	nonHeapMemoryUsageInit      *prometheus.Desc
+	nonHeapMemoryUsageMax       *prometheus.Desc // 未限制时为-1
+	nonHeapMemoryUsageUsed      *prometheus.Desc
+	// 进程信息，用于确认exporter采集的是哪个实例和版本，值固定为1，版本来自"/ws/v1/cluster/info"
+	HadoopInfo *prometheus.Desc
+	// 后端的JMX是否采集成功，一个进程采集多个后端时可以区分是哪个不可达
+	BackendUp *prometheus.Desc
+	// 毫秒时间戳距离采集时的秒数，需要开启time.as-age
+	StartTimeAge *prometheus.Desc
+	// HA状态，来自/ws/v1/cluster/info，需要开启collect.rm-info
+	HAState *prometheus.Desc
+	// JvmMetrics中的GC汇总指标，与按收集器区分的GC指标互补 "name": "Hadoop:service=ResourceManager,name=JvmMetrics"
+	JvmGcCount                    *prometheus.Desc // GC次数，累计值
+	JvmGcTimeMillis               *prometheus.Desc // GC累计耗时，单位为毫秒
+	JvmGcNumWarnThresholdExceeded *prometheus.Desc // JvmPauseMonitor检测到超过告警阈值的停顿次数，累计值
+	// 最近一段时间内GC耗时的占比，需要开启GcTimeMonitor，接近100说明JVM一直在GC
+	JvmGcTimePercentage *prometheus.Desc
+	// 本次采集返回的bean数量，指标缺失时先确认bean是否存在
+	beanCount *prometheus.Desc
+	// 采集是否超时
+	scrapeTimeout *prometheus.Desc
+	// 配置的yarn.http.policy与实际协议不一致时为1，得到明确结果前每次采集都检测，之后不再请求
+	schemeMismatch   *prometheus.Desc
+	schemeMutex      sync.Mutex
+	schemeChecked    bool
+	schemeMismatched bool
 }
 
-//用于搜索配置值，支持任意返回值类型
-func SearchConf(name string, x *XMLConf) string {
-	for _, v := range x.NameValue {
-		//匹配配置项
-		if strings.Contains(v.Name, name) {
-			return v.Value
-		}
-	}
-	return ""
+// 一次采集内按地址缓存后端的响应，多个采集函数并发请求同一地址时只请求一次
+// ctx为本次采集的ctx，超过collect.timeout时未完成的请求被取消
+type scrapeCache struct {
+	ctx       context.Context
+	client    *http.Client
+	mutex     sync.Mutex
+	responses map[string]*cachedResponse
 }
 
-//读取XML配置文件，返回一个XMLConf结构体
-func ReadXml(path string) *XMLConf {
-	xmlFile, err := os.Open(path)
-	if err != nil {
-		log.Error("Error opening file: %s", path)
-		os.Exit(1)
-	}
-	defer xmlFile.Close()
-	var x XMLConf
-	data, err := ioutil.ReadAll(xmlFile)
-	if err != nil {
-		log.Error("Error reading file: %s", path)
-		os.Exit(1)
-	}
-	err = xml.Unmarshal(data, &x)
-	if err != nil {
-		log.Error("Error unmarshal xml.")
-		os.Exit(1)
+type cachedResponse struct {
+	once       sync.Once
+	data       []byte
+	statusCode int
+	err        error
+}
+
+func newScrapeCache(ctx context.Context, client *http.Client) *scrapeCache {
+	return &scrapeCache{ctx: ctx, client: client, responses: make(map[string]*cachedResponse)}
+}
+
+// 请求失败时返回错误，状态码不是200时不读取响应体
+func (c *scrapeCache) get(u string) ([]byte, int, error) {
+	c.mutex.Lock()
+	r, ok := c.responses[u]
+	if !ok {
+		r = &cachedResponse{}
+		c.responses[u] = r
 	}
-	return &x
+	c.mutex.Unlock()
+	r.once.Do(func() {
+		resp, err := common.Get(c.ctx, c.client, u)
+		if err != nil {
+			r.err = err
+			return
+		}
+		defer resp.Body.Close()
+		r.statusCode = resp.StatusCode
+		if resp.StatusCode == 200 {
+			r.data, r.err = common.ReadBody(resp)
+		}
+	})
+	return r.data, r.statusCode, r.err
 }
 
-//生成采集器使用的配置项
-func CreateYARNConf(e *XMLConf) *YARNConf {
+// 生成采集器使用的配置项
+func CreateYARNConf(e *common.XMLConf) *YARNConf {
 	c := YARNConf{}
 	h, err := os.Hostname()
 	if err != nil {
 		panic(err)
 	}
-	t, err := net.ResolveIPAddr("ip", h)
-	if err != nil {
-		panic(err)
-	}
-	c.ServerIP = t.IP.String()
+	c.ServerIP = common.LocalIP(*localIPAddr)
 	// 默认关闭https
 	c.HttpsOpen = httpsmode
-	for _, id := range strings.Split(SearchConf("yarn.resourcemanager.ha.rm-ids", e), ",") {
-		r := "yarn.resourcemanager.resource-tracker.address." + id
-		// 在yarn.resourcemanager.hostname.rm1 / rm2 中搜索是否存在主机名h，如果有则认为是这个rm
-		if v := SearchConf(r, e); strings.Contains(v, h) {
+	ids := common.SplitIDs(common.SearchConf("yarn.resourcemanager.ha.rm-ids", e))
+	// 非HA时没有yarn.resourcemanager.ha.rm-ids，配置项不带rm-id后缀
+	if len(ids) == 0 {
+		ids = []string{""}
+	}
+	for _, id := range ids {
+		r := common.HAKey("yarn.resourcemanager.resource-tracker.address", id)
+		// 在yarn.resourcemanager.resource-tracker.address.rm1 / rm2中查找本机，没有配置时使用yarn.resourcemanager.hostname.rm1 / rm2，非HA时只有一个rm
+		addr := common.SearchConf(r, e)
+		if addr == "" {
+			addr = common.SearchConf(common.HAKey("yarn.resourcemanager.hostname", id), e)
+		}
+		if id == "" || common.IsLocalAddress(addr, h, c.ServerIP) {
 			c.ResourceMangerID = id
-			c.RpcPort = strings.Split(SearchConf(r, e), ":")[1]
+			c.RpcPort = common.ConfPort(r, e, "8031")
 			break
 		}
 	}
 	// 判断是否开启HTTPS，并获取端口
-	if v := SearchConf("yarn.http.policy", e); v == "HTTPS_ONLY" {
+	if v := common.SearchConf("yarn.http.policy", e); v == "HTTPS_ONLY" {
 		c.HttpsOpen = true
-		c.HttpsPort = strings.Split(SearchConf("yarn.resourcemanager.webapp.https.address."+c.ResourceMangerID, e), ":")[1]
+		c.HttpsPort = common.ConfPort(common.HAKey("yarn.resourcemanager.webapp.https.address", c.ResourceMangerID), e, "8090")
 	} else {
-		c.HttpPort = strings.Split(SearchConf("yarn.resourcemanager.webapp.address."+c.ResourceMangerID, e), ":")[1]
+		c.HttpPort = common.ConfPort(common.HAKey("yarn.resourcemanager.webapp.address", c.ResourceMangerID), e, "8088")
 	}
 
+	c.PreemptionEnabled = strings.EqualFold(common.SearchConf("yarn.resourcemanager.scheduler.monitor.enable", e), "true") ||
+		strings.EqualFold(common.SearchConf("yarn.scheduler.fair.preemption", e), "true")
+
+	applyEnvConf(&c)
 	return &c
 }
 
 // 指标格式定义：metrics_name{job="XX",ip="10.30.108.2",nameservice=""}
 
-//创建指标
-func NewExporter(url string, c *YARNConf) *Exporter {
+// 创建指标
+func NewExporter(url string, c *YARNConf, namespace string) *Exporter {
 	return &Exporter{
 		url: url,
 		c:   *c,
 		NumActiveNMs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_NumActiveNms",
 			Help:        "NumActiveNms",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		NumLostNMs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_NumLostNMs",
 			Help:        "NumLostNMs",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
+		lastNMCounts: make(map[string]float64),
+		NMLostEvents: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "ResourceManager_NMLostEvents_total",
+			Help:        "The number of NodeManagers observed to become lost",
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		}),
+		LastNMLostTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "ResourceManager_LastNMLostTimestamp",
+			Help:        "The unix time in seconds when a NodeManager was last observed to become lost, 0 if never",
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		}),
+		NMRebootedEvents: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "ResourceManager_NMRebootedEvents_total",
+			Help:        "The number of NodeManagers observed to reboot",
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		}),
+		LastNMRebootedTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "ResourceManager_LastNMRebootedTimestamp",
+			Help:        "The unix time in seconds when a NodeManager was last observed to reboot, 0 if never",
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		}),
 		NumDecommissioningNMs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_NumDecommissioningNMs",
 			Help:        "NumDecommissioningNMs",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		NumDecommissionedNMs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_NumDecommissionedNMs",
 			Help:        "NumDecommissionedNMs",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		NumUnhealthyNMs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_NumUnhealthyNMs",
 			Help:        "NumUnhealthyNMs",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		NumRebootedNMs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_NumRebootedNMs",
 			Help:        "NumRebootedNMs",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		NumShutdownNMs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_NumShutdownNMs",
 			Help:        "NumShutdownNMs",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AMLaunchDelayNumOps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AMLaunchDelayNumOps",
 			Help:        "AMLaunchDelayNumOps",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AMLaunchDelayAvgTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AMLaunchDelayAvgTime",
 			Help:        "AMLaunchDelayAvgTime",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AMRegisterDelayNumOps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AMRegisterDelayNumOps",
 			Help:        "AMRegisterDelayNumOps",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AMRegisterDelayAvgTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AMRegisterDelayAvgTime",
 			Help:        "AMRegisterDelayAvgTime",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AllocatedVCores: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AllocatedVCores",
 			Help:        "AllocatedVCores",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		ReservedVCores: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_ReservedVCores",
 			Help:        "ReservedVCores",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AvailableVCores: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AvailableVCores",
 			Help:        "AvailableVCores",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		PendingVCores: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_PendingVCores",
 			Help:        "PendingVCores",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AllocatedMB: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AllocatedMB",
 			Help:        "AllocatedMB",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AvailableMB: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AvailableMB",
 			Help:        "AvailableMB",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		PendingMB: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_PendingMB",
 			Help:        "PendingMB",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		ReservedMB: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_ReservedMB",
 			Help:        "ReservedMB",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
+		AllocatedBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "ResourceManager_AllocatedBytes",
+			Help:        "AllocatedMB in bytes",
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		}),
+		AvailableBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "ResourceManager_AvailableBytes",
+			Help:        "AvailableMB in bytes",
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		}),
+		PendingBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "ResourceManager_PendingBytes",
+			Help:        "PendingMB in bytes",
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		}),
+		ReservedBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Name:        "ResourceManager_ReservedBytes",
+			Help:        "ReservedMB in bytes",
+			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		}),
 		AppsSubmitted: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AppsSubmitted",
 			Help:        "AppsSubmitted",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AppsRunning: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AppsRunning",
 			Help:        "AppsRunning",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AppsPending: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AppsPending",
 			Help:        "AppsPending",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AppsCompleted: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AppsCompleted",
 			Help:        "AppsCompleted",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AppsKilled: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AppsKilled",
 			Help:        "AppsKilled",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AppsFailed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AppsFailed",
 			Help:        "AppsFailed",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		running_0: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_running_0",
 			Help:        "running time < 60min",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		running_60: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_running_60",
 			Help:        "60min < running time < 300min",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		running_300: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_running_300",
 			Help:        "300min < running time < 1440min",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		running_1440: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_running_1440",
 			Help:        "running time > 1440min",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		RpcQueueTimeNumOps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_RpcQueueTimeNumOps",
 			Help:        "RpcQueueTimeNumOps",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		RpcQueueTimeAvgTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_RpcQueueTimeAvgTime",
 			Help:        "RpcQueueTimeAvgTime",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		RpcProcessingTimeNumOps: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_RpcProcessingTimeNumOps",
 			Help:        "RpcProcessingTimeNumOps",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		RpcProcessingTimeAvgTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_RpcProcessingTimeAvgTime",
 			Help:        "RpcProcessingTimeAvgTime",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		heapMemoryUsageCommitted: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_heapMemoryUsageCommitted",
 			Help:        "heapMemoryUsageCommitted",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		heapMemoryUsageInit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_heapMemoryUsageInit",
 			Help:        "heapMemoryUsageInit",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		heapMemoryUsageMax: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_heapMemoryUsageMax",
 			Help:        "heapMemoryUsageMax",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		heapMemoryUsageUsed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_heapMemoryUsageUsed",
 			Help:        "heapMemoryUsageUsed",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		LogFatal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_LogFatal",
 			Help:        "LogFatal",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		LogError: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_LogError",
 			Help:        "LogError",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		LogInfo: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_LogInfo",
 			Help:        "LogInfo",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		LogWarn: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_LogWarn",
 			Help:        "LogWarn",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		StartTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_StartTime",
 			Help:        "StartTime",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		Uptime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_Uptime",
 			Help:        "Uptime",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		SystemLoadAverage: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_SystemLoadAverage",
 			Help:        "SystemLoadAverage",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		OpenFileDescriptorCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_OpenFileDescriptorCount",
 			Help:        "OpenFileDescriptorCount",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		MaxFileDescriptorCount: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_MaxFileDescriptorCount",
 			Help:        "MaxFileDescriptorCount",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		TotalPhysicalMemorySize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_TotalPhysicalMemorySize",
 			Help:        "TotalPhysicalMemorySize",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		FreePhysicalMemorySize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_FreePhysicalMemorySize",
 			Help:        "FreePhysicalMemorySize",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		AvailableProcessors: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_AvailableProcessors",
 			Help:        "AvailableProcessors",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		ServerActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_ServerActive",
 			Help:        "ServerActive",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
 		isActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
 			Name:        "ResourceManager_isActive",
-			Help:        "isActive",
+			Help:        "isActive, 1 active, 0 standby, -1 unknown",
 			ConstLabels: map[string]string{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
 		}),
+		ClusterTotalMB: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_ClusterTotalMB"),
+			"totalMB",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		ClusterTotalVirtualCores: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_ClusterTotalVirtualCores"),
+			"totalVirtualCores",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		ClusterTotalNodes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_ClusterTotalNodes"),
+			"totalNodes",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		ClusterLostNodes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_ClusterLostNodes"),
+			"lostNodes",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		ClusterUnhealthyNodes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_ClusterUnhealthyNodes"),
+			"unhealthyNodes",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		NodeManagerHealthInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "yarn_nodemanager_health_info"),
+			"The health report of unhealthy NodeManagers, value is always 1",
+			[]string{"node", "healthReport"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		NMDecommissioningRemainingContainers: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_NMDecommissioningRemainingContainers"),
+			"The running containers on the decommissioning NodeManager",
+			[]string{"node"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		NodeUsedMemoryMB: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_NodeUsedMemoryMB"),
+			"usedMemoryMB",
+			[]string{"node"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		NodeUsedVirtualCores: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_NodeUsedVirtualCores"),
+			"usedVirtualCores",
+			[]string{"node"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		NodePhysicalMemoryMB: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_NodePhysicalMemoryMB"),
+			"resourceUtilization.nodePhysicalMemoryMB",
+			[]string{"node"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		NodeCPUUsage: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_NodeCPUUsage"),
+			"resourceUtilization.nodeCPUUsage",
+			[]string{"node"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		NodeContainersPhysicalMemoryMB: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_NodeContainersPhysicalMemoryMB"),
+			"resourceUtilization.aggregatedContainersPhysicalMemoryMB",
+			[]string{"node"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		NodeContainersCPUUsage: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_NodeContainersCPUUsage"),
+			"resourceUtilization.containersCPUUsage",
+			[]string{"node"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		ClusterAppsPending: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_ClusterAppsPending"),
+			"appsPending",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		AppsAcceptedLongRunning: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_AppsAcceptedLongRunning"),
+			"Number of applications in ACCEPTED state longer than apps.accepted-threshold-ms",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		gcCollectionCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_gc_collections_total"),
+			"The GC collection count of each collector",
+			[]string{"collector"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		gcCollectionTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_gc_collection_seconds_total"),
+			"The GC collection time of each collector in seconds",
+			[]string{"collector"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		configValid: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_config_valid"),
+			"Whether the exporter parsed all required fields from the config, 1 valid, 0 invalid",
+			[]string{"resourcemangerid", "rpcport", "httpport"},
+			prometheus.Labels{"serverip": c.ServerIP},
+		),
+		AMLaunchDelayPercentileTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_AMLaunchDelayPercentileTime"),
+			"AMLaunchDelay percentile time",
+			[]string{"percentile", "interval"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		AMRegisterDelayPercentileTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_AMRegisterDelayPercentileTime"),
+			"AMRegisterDelay percentile time",
+			[]string{"percentile", "interval"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		AMContainerAllocationDelayNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_AMContainerAllocationDelayNumOps"),
+			"AMContainerAllocationDelayNumOps",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		AMContainerAllocationDelayAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_AMContainerAllocationDelayAvgTime"),
+			"AMContainerAllocationDelayAvgTime",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		lastSchedulerRun: make(map[string]schedulerRun),
+		SchedulerRunNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_SchedulerRunNumOps_total"),
+			"The number of scheduler runs",
+			[]string{"scheduler"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		SchedulerRunAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_SchedulerRunAvgTime"),
+			"The average time of scheduler runs",
+			[]string{"scheduler"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		SchedulerSecondsSinceLastRun: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_SchedulerSecondsSinceLastRun"),
+			"Seconds since the exporter observed the scheduler run count change",
+			[]string{"scheduler"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		SchedulerOpNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_SchedulerOpNumOps_total"),
+			"The number of scheduler operations",
+			[]string{"scheduler", "op"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		SchedulerOpAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_SchedulerOpAvgTime"),
+			"The average time of scheduler operations",
+			[]string{"scheduler", "op"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		StateStoreOpNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_StateStoreOpNumOps_total"),
+			"The number of RM state store operations",
+			[]string{"op"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		StateStoreOpAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_StateStoreOpAvgTime"),
+			"The average time of RM state store operations",
+			[]string{"op"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		StateStoreFenced: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_StateStoreFenced"),
+			"Whether the RM state store is fenced, 1 fenced, 0 normal",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		ReservationOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_ReservationOps_total"),
+			"The number of reservation system operations, such as accepted and rejected reservations",
+			[]string{"op"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueIsLeaf: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueIsLeaf"),
+			"Whether the queue is a leaf queue, 1 leaf, 0 parent",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueCapacity: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueCapacity"),
+			"The guaranteed capacity of the queue as a percentage of the cluster",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueMaxCapacity: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueMaxCapacity"),
+			"The maximum capacity of the queue as a percentage of the cluster",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueUsedCapacity: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueUsedCapacity"),
+			"The used capacity of the queue as a percentage of the cluster",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		AMResourceLimitMB: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_AMResourceLimitMB"),
+			"The memory limit for application masters in the queue in MB",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		AMResourceLimitVCores: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_AMResourceLimitVCores"),
+			"The vcore limit for application masters in the queue",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		usedAMResourceMB: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_usedAMResourceMB"),
+			"The memory used by application masters in the queue in MB",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueAppsSubmitted: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueAppsSubmitted_total"),
+			"The applications submitted to the queue",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueAggregatePreempted: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueAggregatePreempted_total"),
+			"The containers or resources preempted from the queue",
+			[]string{"queue", "resource"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueFirstContainerAllocationDelayNumOps: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueFirstContainerAllocationDelayNumOps_total"),
+			"AppAttemptFirstContainerAllocationDelayNumOps",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueFirstContainerAllocationDelayAvgTime: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueFirstContainerAllocationDelayAvgTime"),
+			"AppAttemptFirstContainerAllocationDelayAvgTime",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueAppsRunning: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueAppsRunning"),
+			"The running applications in the queue",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueAppsPending: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueAppsPending"),
+			"The pending applications in the queue",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueuePendingContainers: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueuePendingContainers"),
+			"PendingContainers",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueAllocatedContainers: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueAllocatedContainers"),
+			"AllocatedContainers",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueReservedContainers: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueReservedContainers"),
+			"ReservedContainers",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueActiveUsers: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueActiveUsers"),
+			"The number of users with applications in the queue",
+			[]string{"queue"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		QueueUserUsedMemoryMB: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_QueueUserUsedMemoryMB"),
+			"The memory used by the user in the queue in MB",
+			[]string{"queue", "user"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		nonHeapMemoryUsageCommitted: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_nonHeapMemoryUsageCommitted"),
+			"nonHeapMemoryUsageCommitted",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		nonHeapMemoryUsageInit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_nonHeapMemoryUsageInit"),
+			"nonHeapMemoryUsageInit",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		nonHeapMemoryUsageMax: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_nonHeapMemoryUsageMax"),
+			"nonHeapMemoryUsageMax",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		nonHeapMemoryUsageUsed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_nonHeapMemoryUsageUsed"),
+			"nonHeapMemoryUsageUsed",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		HadoopInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_info"),
+			"Information about the Hadoop daemon, value is always 1",
+			[]string{"hostname", "version", "hadoop_version"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID, "role": "resourcemanager"},
+		),
+		StartTimeAge: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_StartTime_age_seconds"),
+			"The seconds since the ResourceManager started",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		HAState: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_HAState"),
+			"HA state, 1 active, 0 standby, 2 initializing, 3 other",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		BackendUp: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_backend_up"),
+			"Whether the last JMX request to the backend succeeded, 1 success, 0 failure",
+			[]string{"target"},
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID, "role": "resourcemanager"},
+		),
+		JvmGcCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_GcCount_total"),
+			"GcCount",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		JvmGcTimeMillis: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_GcTimeMillis_total"),
+			"GcTimeMillis",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		JvmGcNumWarnThresholdExceeded: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_GcNumWarnThresholdExceeded_total"),
+			"GcNumWarnThresholdExceeded",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		JvmGcTimePercentage: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "ResourceManager_GcTimePercentage"),
+			"GcTimePercentage",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		beanCount: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_beans"),
+			"The number of JMX beans returned in the last scrape",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		scrapeTimeout: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_scrape_timeout"),
+			"Whether the last scrape exceeded collect.timeout and returned partial metrics, 1 timeout, 0 normal",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
+		schemeMismatch: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "hadoop_exporter_scheme_mismatch"),
+			"Whether the configured yarn.http.policy does not match the scheme the server actually uses, 1 mismatch, 0 match",
+			nil,
+			prometheus.Labels{"serverip": c.ServerIP, "resourcemangerid": c.ResourceMangerID},
+		),
 	}
 }
 
 // 定义指标的描述
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.NumActiveNMs.Describe(ch)
+	e.NumLostNMs.Describe(ch)
+	e.NumDecommissioningNMs.Describe(ch)
+	e.NumDecommissionedNMs.Describe(ch)
+	e.NumUnhealthyNMs.Describe(ch)
+	e.NumRebootedNMs.Describe(ch)
+	e.NMLostEvents.Describe(ch)
+	e.LastNMLostTimestamp.Describe(ch)
+	e.NMRebootedEvents.Describe(ch)
+	e.LastNMRebootedTimestamp.Describe(ch)
+	e.NumShutdownNMs.Describe(ch)
+	e.AMLaunchDelayNumOps.Describe(ch)
+	e.AMLaunchDelayAvgTime.Describe(ch)
+	e.AMRegisterDelayNumOps.Describe(ch)
+	e.AMRegisterDelayAvgTime.Describe(ch)
+	e.AllocatedVCores.Describe(ch)
+	e.ReservedVCores.Describe(ch)
+	e.AvailableVCores.Describe(ch)
+	e.PendingVCores.Describe(ch)
+	e.AllocatedMB.Describe(ch)
+	e.AvailableMB.Describe(ch)
+	e.PendingMB.Describe(ch)
+	e.ReservedMB.Describe(ch)
+	e.AllocatedBytes.Describe(ch)
+	e.AvailableBytes.Describe(ch)
+	e.PendingBytes.Describe(ch)
+	e.ReservedBytes.Describe(ch)
+	e.AppsSubmitted.Describe(ch)
+	e.AppsRunning.Describe(ch)
+	e.AppsPending.Describe(ch)
+	e.AppsCompleted.Describe(ch)
+	e.AppsKilled.Describe(ch)
+	e.AppsFailed.Describe(ch)
+	e.running_0.Describe(ch)
+	e.running_60.Describe(ch)
+	e.running_300.Describe(ch)
+	e.running_1440.Describe(ch)
+	e.RpcQueueTimeNumOps.Describe(ch)
+	e.RpcQueueTimeAvgTime.Describe(ch)
+	e.RpcProcessingTimeNumOps.Describe(ch)
+	e.RpcProcessingTimeAvgTime.Describe(ch)
 	e.heapMemoryUsageCommitted.Describe(ch)
 	e.heapMemoryUsageInit.Describe(ch)
 	e.heapMemoryUsageMax.Describe(ch)
 	e.heapMemoryUsageUsed.Describe(ch)
+	e.LogFatal.Describe(ch)
+	e.LogError.Describe(ch)
+	e.LogWarn.Describe(ch)
+	e.LogInfo.Describe(ch)
+	e.StartTime.Describe(ch)
+	e.Uptime.Describe(ch)
+	e.SystemLoadAverage.Describe(ch)
+	e.MaxFileDescriptorCount.Describe(ch)
+	e.OpenFileDescriptorCount.Describe(ch)
+	e.TotalPhysicalMemorySize.Describe(ch)
+	e.FreePhysicalMemorySize.Describe(ch)
+	e.AvailableProcessors.Describe(ch)
+	e.ServerActive.Describe(ch)
 	e.isActive.Describe(ch)
+	ch <- e.ClusterTotalMB
+	ch <- e.ClusterTotalVirtualCores
+	ch <- e.ClusterTotalNodes
+	ch <- e.ClusterLostNodes
+	ch <- e.ClusterUnhealthyNodes
+	ch <- e.ClusterAppsPending
+	ch <- e.AppsAcceptedLongRunning
+	ch <- e.NodeManagerHealthInfo
+	ch <- e.NMDecommissioningRemainingContainers
+	ch <- e.NodeUsedMemoryMB
+	ch <- e.NodeUsedVirtualCores
+	ch <- e.NodePhysicalMemoryMB
+	ch <- e.NodeCPUUsage
+	ch <- e.NodeContainersPhysicalMemoryMB
+	ch <- e.NodeContainersCPUUsage
+	ch <- e.gcCollectionCount
+	ch <- e.gcCollectionTime
+	ch <- e.configValid
+	ch <- e.AMLaunchDelayPercentileTime
+	ch <- e.AMRegisterDelayPercentileTime
+	ch <- e.AMContainerAllocationDelayNumOps
+	ch <- e.AMContainerAllocationDelayAvgTime
+	ch <- e.SchedulerRunNumOps
+	ch <- e.SchedulerRunAvgTime
+	ch <- e.SchedulerOpNumOps
+	ch <- e.SchedulerOpAvgTime
+	ch <- e.SchedulerSecondsSinceLastRun
+	ch <- e.QueueCapacity
+	ch <- e.QueueMaxCapacity
+	ch <- e.QueueUsedCapacity
+	ch <- e.QueueIsLeaf
+	ch <- e.AMResourceLimitMB
+	ch <- e.AMResourceLimitVCores
+	ch <- e.usedAMResourceMB
+	ch <- e.QueuePendingContainers
+	ch <- e.QueueAllocatedContainers
+	ch <- e.QueueReservedContainers
+	ch <- e.QueueAppsSubmitted
+	ch <- e.QueueAggregatePreempted
+	ch <- e.QueueFirstContainerAllocationDelayNumOps
+	ch <- e.QueueFirstContainerAllocationDelayAvgTime
+	ch <- e.QueueAppsRunning
+	ch <- e.QueueAppsPending
+	ch <- e.QueueActiveUsers
+	ch <- e.QueueUserUsedMemoryMB
+	ch <- e.StateStoreOpNumOps
+	ch <- e.StateStoreOpAvgTime
+	ch <- e.StateStoreFenced
+	ch <- e.ReservationOps
+	ch <- e.nonHeapMemoryUsageCommitted
+	ch <- e.nonHeapMemoryUsageInit
+	ch <- e.nonHeapMemoryUsageMax
+	ch <- e.nonHeapMemoryUsageUsed
+	ch <- e.HadoopInfo
+	ch <- e.BackendUp
+	ch <- e.StartTimeAge
+	ch <- e.HAState
+	ch <- e.JvmGcCount
+	ch <- e.JvmGcTimeMillis
+	ch <- e.JvmGcNumWarnThresholdExceeded
+	ch <- e.JvmGcTimePercentage
+	ch <- e.beanCount
+	ch <- e.scrapeTimeout
+	ch <- e.schemeMismatch
+}
+
+// 采集调度器的运行次数和耗时，并根据运行次数的变化计算调度器多久没有运行
+func (e *Exporter) collectSchedulerHealth(scheduler, numOpsKey, avgTimeKey string, bean map[string]interface{}, ch chan<- prometheus.Metric) {
+	numOps, ok := common.GetFloat(bean, numOpsKey)
+	if !ok {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(e.SchedulerRunNumOps, prometheus.CounterValue, numOps, scheduler)
+	if v, ok := common.GetFloat(bean, avgTimeKey); ok {
+		ch <- prometheus.MustNewConstMetric(e.SchedulerRunAvgTime, prometheus.GaugeValue, v, scheduler)
+	}
+	e.schedulerMutex.Lock()
+	defer e.schedulerMutex.Unlock()
+	last, ok := e.lastSchedulerRun[scheduler]
+	if !ok || last.numOps != numOps {
+		last = schedulerRun{numOps: numOps, time: time.Now()}
+		e.lastSchedulerRun[scheduler] = last
+	}
+	ch <- prometheus.MustNewConstMetric(e.SchedulerSecondsSinceLastRun, prometheus.GaugeValue, time.Since(last.time).Seconds(), scheduler)
+}
+
+// 采集调度器各操作的次数和耗时，字段为<操作>NumOps和<操作>AvgTime，例如AllocateNumOps
+// 社区版本没有预留容器被满足或取消的次数，CommitSuccess和CommitFailure是分配和预留提案被接受和拒绝的次数
+func (e *Exporter) collectSchedulerOps(scheduler string, bean map[string]interface{}, ch chan<- prometheus.Metric) {
+	for key := range bean {
+		if !strings.HasSuffix(key, "NumOps") {
+			continue
+		}
+		op := strings.TrimSuffix(key, "NumOps")
+		if v, ok := common.GetFloat(bean, key); ok {
+			ch <- prometheus.MustNewConstMetric(e.SchedulerOpNumOps, prometheus.CounterValue, v, scheduler, op)
+		}
+		if v, ok := common.GetFloat(bean, op+"AvgTime"); ok {
+			ch <- prometheus.MustNewConstMetric(e.SchedulerOpAvgTime, prometheus.GaugeValue, v, scheduler, op)
+		}
+	}
+}
+
+// 采集状态存储各操作的次数和耗时，字段为<操作>NumOps和<操作>AvgTime，例如LoadStateCallNumOps
+func (e *Exporter) collectStateStore(bean map[string]interface{}, ch chan<- prometheus.Metric) {
+	for key := range bean {
+		if !strings.HasSuffix(key, "NumOps") {
+			continue
+		}
+		op := strings.TrimSuffix(key, "NumOps")
+		if v, ok := common.GetFloat(bean, key); ok {
+			ch <- prometheus.MustNewConstMetric(e.StateStoreOpNumOps, prometheus.CounterValue, v, op)
+		}
+		if v, ok := common.GetFloat(bean, op+"AvgTime"); ok {
+			ch <- prometheus.MustNewConstMetric(e.StateStoreOpAvgTime, prometheus.GaugeValue, v, op)
+		}
+	}
+	// 被fence后状态存储进入FENCED状态，不同发行版可能输出为布尔值或状态字符串
+	fenced := -1.0
+	if v, ok := bean["Fenced"].(bool); ok {
+		fenced = 0
+		if v {
+			fenced = 1
+		}
+	} else if v, ok := bean["State"].(string); ok {
+		fenced = 0
+		if v == "FENCED" {
+			fenced = 1
+		}
+	}
+	if fenced != -1 {
+		ch <- prometheus.MustNewConstMetric(e.StateStoreFenced, prometheus.GaugeValue, fenced)
+	}
+}
+
+// 采集预留系统的计数，不同发行版的字段名不同，<操作>NumOps取操作名，其他数值字段直接作为操作名，平均耗时跳过
+func (e *Exporter) collectReservation(bean map[string]interface{}, ch chan<- prometheus.Metric) {
+	for key := range bean {
+		if strings.HasPrefix(key, "tag.") || strings.HasSuffix(key, "AvgTime") {
+			continue
+		}
+		if v, ok := common.GetFloat(bean, key); ok {
+			ch <- prometheus.MustNewConstMetric(e.ReservationOps, prometheus.CounterValue, v, strings.TrimSuffix(key, "NumOps"))
+		}
+	}
+}
+
+// 当前值比上次采集时大时累加事件数并记录时间，变小说明NM恢复或RM重启，只更新基线
+func (e *Exporter) trackNMEvents(key string, current float64, events prometheus.Counter, lastTime prometheus.Gauge) {
+	e.nmEventsMutex.Lock()
+	defer e.nmEventsMutex.Unlock()
+	last, ok := e.lastNMCounts[key]
+	e.lastNMCounts[key] = current
+	if ok && current > last {
+		events.Add(current - last)
+		lastTime.Set(float64(time.Now().Unix()))
+	}
+}
+
+// 输出从配置文件解析出的信息，resourcemangerid和端口任一为空都视为无效
+func (e *Exporter) collectConfigValid(ch chan<- prometheus.Metric) {
+	httpPort := e.c.HttpPort
+	if e.c.HttpsOpen {
+		httpPort = e.c.HttpsPort
+	}
+	valid := 1.0
+	for _, v := range []string{e.c.ResourceMangerID, e.c.RpcPort, httpPort} {
+		if v == "" {
+			valid = 0
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(e.configValid, prometheus.GaugeValue, valid, e.c.ResourceMangerID, e.c.RpcPort, httpPort)
+}
+
+// 通过REST接口采集集群总资源，比按队列累加更准确
+func (e *Exporter) collectClusterMetrics(cache *scrapeCache, ch chan<- prometheus.Metric) {
+	data, statusCode, err := cache.get(e.restURL + "/ws/v1/cluster/metrics")
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if statusCode != 200 {
+		return
+	}
+	var f struct {
+		ClusterMetrics map[string]interface{} `json:"clusterMetrics"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		log.Error(err)
+		return
+	}
+	for key, desc := range map[string]*prometheus.Desc{
+		"totalMB":           e.ClusterTotalMB,
+		"totalVirtualCores": e.ClusterTotalVirtualCores,
+		"totalNodes":        e.ClusterTotalNodes,
+		"lostNodes":         e.ClusterLostNodes,
+		"unhealthyNodes":    e.ClusterUnhealthyNodes,
+		"appsPending":       e.ClusterAppsPending,
+	} {
+		if v, ok := common.GetFloat(f.ClusterMetrics, key); ok {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+		}
+	}
+}
+
+// 统计处于ACCEPTED超过阈值的任务数，ACCEPTED时elapsedTime基本就是等待AM的时间
+// 没有任务时也输出0，便于告警规则判断
+func (e *Exporter) collectAcceptedApps(cache *scrapeCache, ch chan<- prometheus.Metric) {
+	data, statusCode, err := cache.get(e.restURL + "/ws/v1/cluster/apps?states=ACCEPTED")
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if statusCode != 200 {
+		return
+	}
+	// 没有符合条件的任务时返回{"apps":null}
+	var f struct {
+		Apps *struct {
+			App []struct {
+				ElapsedTime float64 `json:"elapsedTime"`
+			} `json:"app"`
+		} `json:"apps"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		log.Error(err)
+		return
+	}
+	count := 0.0
+	if f.Apps != nil {
+		for _, app := range f.Apps.App {
+			if app.ElapsedTime > float64(*acceptedThreshold) {
+				count++
+			}
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(e.AppsAcceptedLongRunning, prometheus.GaugeValue, count)
+}
+
+// RM的JMX中没有版本，通过REST接口读取，standby的RM也可以访问该接口
+// standby时JMX不可用，拿不到主机名，只输出collect.rm-info的指标
+func (e *Exporter) collectInfo(cache *scrapeCache, hostname string, ch chan<- prometheus.Metric) {
+	data, statusCode, err := cache.get(e.restURL + "/ws/v1/cluster/info")
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if statusCode != 200 {
+		return
+	}
+	var f struct {
+		ClusterInfo struct {
+			ResourceManagerVersion string  `json:"resourceManagerVersion"`
+			HadoopVersion          string  `json:"hadoopVersion"`
+			HAState                string  `json:"haState"`
+			StartedOn              float64 `json:"startedOn"`
+		} `json:"clusterInfo"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		log.Error(err)
+		return
+	}
+	if f.ClusterInfo.ResourceManagerVersion != "" && hostname != "" {
+		ch <- prometheus.MustNewConstMetric(e.HadoopInfo, prometheus.GaugeValue, 1, hostname, f.ClusterInfo.ResourceManagerVersion, f.ClusterInfo.HadoopVersion)
+	}
+	if !*collectRMInfo {
+		return
+	}
+	if f.ClusterInfo.HAState != "" {
+		haState := 3.0
+		switch strings.ToLower(f.ClusterInfo.HAState) {
+		case "active":
+			haState = 1
+		case "standby":
+			haState = 0
+		case "initializing":
+			haState = 2
+		}
+		ch <- prometheus.MustNewConstMetric(e.HAState, prometheus.GaugeValue, haState)
+	}
+	// startedOn与JMX中的StartTime相同，standby时JMX不可用，以REST接口为准
+	if f.ClusterInfo.StartedOn > 0 {
+		e.StartTime.Set(f.ClusterInfo.StartedOn)
+		// active时在collectJMX最后统一输出
+		if hostname == "" {
+			e.StartTime.Collect(ch)
+		}
+	}
+}
+
+// 通过REST接口采集不健康NM的健康报告和下线中NM剩余的容器数，健康报告过长时截断，避免标签值过大
+// 开启collect.node-utilization时需要所有节点，不再按状态过滤
+func (e *Exporter) collectNodes(cache *scrapeCache, ch chan<- prometheus.Metric) {
+	nodesURL := e.restURL + "/ws/v1/cluster/nodes"
+	if !*collectNodeUtilization {
+		nodesURL += "?states=UNHEALTHY,DECOMMISSIONING"
+	}
+	data, statusCode, err := cache.get(nodesURL)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if statusCode != 200 {
+		return
+	}
+	// 没有符合条件的节点时返回{"nodes":null}
+	var f struct {
+		Nodes *struct {
+			Node []struct {
+				ID            string  `json:"id"`
+				State         string  `json:"state"`
+				HealthReport  string  `json:"healthReport"`
+				NumContainers float64 `json:"numContainers"`
+				// 旧版本没有resourceUtilization，字段缺失时跳过
+				UsedMemoryMB        *float64               `json:"usedMemoryMB"`
+				UsedVirtualCores    *float64               `json:"usedVirtualCores"`
+				ResourceUtilization map[string]interface{} `json:"resourceUtilization"`
+			} `json:"node"`
+		} `json:"nodes"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		log.Error(err)
+		return
+	}
+	if f.Nodes == nil {
+		return
+	}
+	for _, node := range f.Nodes.Node {
+		if *collectNodeUtilization && node.State == "RUNNING" {
+			e.collectNodeUtilization(node.ID, node.UsedMemoryMB, node.UsedVirtualCores, node.ResourceUtilization, ch)
+		}
+		if !*collectNodeHealth {
+			continue
+		}
+		if node.State == "DECOMMISSIONING" {
+			ch <- prometheus.MustNewConstMetric(e.NMDecommissioningRemainingContainers, prometheus.GaugeValue, node.NumContainers, node.ID)
+			continue
+		}
+		report := []rune(node.HealthReport)
+		if len(report) > maxHealthReportLength {
+			report = append(report[:maxHealthReportLength], []rune("...")...)
+		}
+		if node.State == "UNHEALTHY" {
+			ch <- prometheus.MustNewConstMetric(e.NodeManagerHealthInfo, prometheus.GaugeValue, 1, node.ID, string(report))
+		}
+	}
+}
+
+// 输出NM已分配和实际使用的资源，两者相差较大说明容器申请的资源偏大
+func (e *Exporter) collectNodeUtilization(node string, usedMemoryMB, usedVirtualCores *float64, utilization map[string]interface{}, ch chan<- prometheus.Metric) {
+	if usedMemoryMB != nil {
+		ch <- prometheus.MustNewConstMetric(e.NodeUsedMemoryMB, prometheus.GaugeValue, *usedMemoryMB, node)
+	}
+	if usedVirtualCores != nil {
+		ch <- prometheus.MustNewConstMetric(e.NodeUsedVirtualCores, prometheus.GaugeValue, *usedVirtualCores, node)
+	}
+	for key, desc := range map[string]*prometheus.Desc{
+		"nodePhysicalMemoryMB":                 e.NodePhysicalMemoryMB,
+		"nodeCPUUsage":                         e.NodeCPUUsage,
+		"aggregatedContainersPhysicalMemoryMB": e.NodeContainersPhysicalMemoryMB,
+		"containersCPUUsage":                   e.NodeContainersCPUUsage,
+	} {
+		if v, ok := common.GetFloat(utilization, key); ok {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, node)
+		}
+	}
+}
+
+// 通过REST接口获取调度器类型，获取成功后缓存，失败时返回空字符串并在下次采集时重试
+func (e *Exporter) detectSchedulerType(cache *scrapeCache) string {
+	e.schedulerTypeMutex.Lock()
+	defer e.schedulerTypeMutex.Unlock()
+	if e.schedulerType != "" {
+		return e.schedulerType
+	}
+	data, statusCode, err := cache.get(e.restURL + "/ws/v1/cluster/scheduler")
+	if err != nil {
+		log.Error(err)
+		return ""
+	}
+	if statusCode != 200 {
+		return ""
+	}
+	var f struct {
+		Scheduler struct {
+			SchedulerInfo struct {
+				Type string `json:"type"`
+			} `json:"schedulerInfo"`
+		} `json:"scheduler"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		log.Error(err)
+		return ""
+	}
+	e.schedulerType = f.Scheduler.SchedulerInfo.Type
+	return e.schedulerType
+}
+
+// 通过REST接口采集各队列的容量，CapacityScheduler和FairScheduler返回的结构不同
+func (e *Exporter) collectSchedulerQueues(cache *scrapeCache, ch chan<- prometheus.Metric) {
+	data, statusCode, err := cache.get(e.restURL + "/ws/v1/cluster/scheduler")
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if statusCode != 200 {
+		return
+	}
+	var f struct {
+		Scheduler struct {
+			SchedulerInfo map[string]interface{} `json:"schedulerInfo"`
+		} `json:"scheduler"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		log.Error(err)
+		return
+	}
+	info := f.Scheduler.SchedulerInfo
+	switch info["type"] {
+	case "capacityScheduler":
+		e.collectCapacityQueue(info, "", ch)
+	case "fairScheduler":
+		if root, ok := info["rootQueue"].(map[string]interface{}); ok {
+			e.collectFairQueue(root, ch)
+		}
+	}
 }
 
-//采集器方法
+// 子队列在不同版本中可能是数组，也可能是{"queue": [...]}
+func schedulerChildQueues(v interface{}) []map[string]interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		v = m["queue"]
+	}
+	list, _ := v.([]interface{})
+	var queues []map[string]interface{}
+	for _, q := range list {
+		if m, ok := q.(map[string]interface{}); ok {
+			queues = append(queues, m)
+		}
+	}
+	return queues
+}
+
+// CapacityScheduler的capacity等字段是相对父队列的，优先使用相对整个集群的absolute字段，root队列没有absolute字段
+func (e *Exporter) collectCapacityQueue(q map[string]interface{}, parent string, ch chan<- prometheus.Metric) {
+	name, _ := q["queueName"].(string)
+	if parent != "" {
+		name = parent + "." + name
+	}
+	for _, m := range []struct {
+		absolute, relative string
+		desc               *prometheus.Desc
+	}{
+		{"absoluteCapacity", "capacity", e.QueueCapacity},
+		{"absoluteMaxCapacity", "maxCapacity", e.QueueMaxCapacity},
+		{"absoluteUsedCapacity", "usedCapacity", e.QueueUsedCapacity},
+	} {
+		v, ok := common.GetFloat(q, m.absolute)
+		if !ok {
+			v, ok = common.GetFloat(q, m.relative)
+		}
+		if ok {
+			ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, v, name)
+		}
+	}
+	// 只有叶子队列有AM资源限制
+	for _, m := range []struct {
+		resource, key string
+		desc          *prometheus.Desc
+	}{
+		{"AMResourceLimit", "memory", e.AMResourceLimitMB},
+		{"AMResourceLimit", "vCores", e.AMResourceLimitVCores},
+		{"usedAMResource", "memory", e.usedAMResourceMB},
+	} {
+		if r, ok := q[m.resource].(map[string]interface{}); ok {
+			if v, ok := common.GetFloat(r, m.key); ok {
+				ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, v, name)
+			}
+		}
+	}
+	// 叶子队列的users格式为{"user": [...]}，没有用户时为null
+	if q["type"] == "capacitySchedulerLeafQueueInfo" {
+		users, _ := q["users"].(map[string]interface{})
+		userList, _ := users["user"].([]interface{})
+		ch <- prometheus.MustNewConstMetric(e.QueueActiveUsers, prometheus.GaugeValue, float64(len(userList)), name)
+		if *collectQueueUsers {
+			for _, u := range userList {
+				user, _ := u.(map[string]interface{})
+				username, _ := user["username"].(string)
+				used, _ := user["resourcesUsed"].(map[string]interface{})
+				if v, ok := common.GetFloat(used, "memory"); ok && username != "" {
+					ch <- prometheus.MustNewConstMetric(e.QueueUserUsedMemoryMB, prometheus.GaugeValue, v, name, username)
+				}
+			}
+		}
+	}
+	children := schedulerChildQueues(q["queues"])
+	e.collectQueueIsLeaf(q, name, children, ch)
+	for _, child := range children {
+		e.collectCapacityQueue(child, name, ch)
+	}
+}
+
+// FairScheduler没有百分比字段，用fair share、最大资源、已用资源的内存占集群内存的比例代替
+func (e *Exporter) collectFairQueue(q map[string]interface{}, ch chan<- prometheus.Metric) {
+	name, _ := q["queueName"].(string)
+	memory := func(key string) (float64, bool) {
+		r, ok := q[key].(map[string]interface{})
+		if !ok {
+			return 0, false
+		}
+		return common.GetFloat(r, "memory")
+	}
+	if cluster, ok := memory("clusterResources"); ok && cluster > 0 {
+		for key, desc := range map[string]*prometheus.Desc{
+			"fairResources": e.QueueCapacity,
+			"maxResources":  e.QueueMaxCapacity,
+			"usedResources": e.QueueUsedCapacity,
+		} {
+			if v, ok := memory(key); ok {
+				// 未配置maxResources时为Integer.MAX_VALUE，按集群资源计算
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, math.Min(v/cluster*100, 100), name)
+			}
+		}
+	}
+	children := schedulerChildQueues(q["childQueues"])
+	e.collectQueueIsLeaf(q, name, children, ch)
+	for _, child := range children {
+		e.collectFairQueue(child, ch)
+	}
+}
+
+// 叶子队列的type为capacitySchedulerLeafQueueInfo或fairSchedulerLeafQueueInfo，没有type字段时按是否有子队列判断
+func (e *Exporter) collectQueueIsLeaf(q map[string]interface{}, name string, children []map[string]interface{}, ch chan<- prometheus.Metric) {
+	leaf := len(children) == 0
+	if t, ok := q["type"].(string); ok {
+		leaf = strings.HasSuffix(t, "LeafQueueInfo")
+	}
+	isLeaf := 0.0
+	if leaf {
+		isLeaf = 1
+	}
+	ch <- prometheus.MustNewConstMetric(e.QueueIsLeaf, prometheus.GaugeValue, isLeaf, name)
+}
+
+// 从QueueMetrics的bean名称中解析队列名，例如q0=root,q1=default解析为root.default，按用户统计的bean跳过
+func queueMetricsName(name string) (string, bool) {
+	var queue []string
+	for _, kv := range strings.Split(strings.TrimPrefix(name, "Hadoop:service=ResourceManager,name=QueueMetrics,"), ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(k, "q") {
+			return "", false
+		}
+		queue = append(queue, v)
+	}
+	return strings.Join(queue, "."), len(queue) > 0
+}
+
+// 输出本次采集到的bean数量，debug日志中列出没有匹配beanPatterns的bean，用于排查指标缺失，例如RPC端口与配置不同
+func (e *Exporter) collectBeanCount(beans []interface{}, ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(e.beanCount, prometheus.GaugeValue, float64(len(beans)))
+	var unmatched []string
+	patterns := e.beanPatterns()
+	for _, bean := range beans {
+		m, _ := bean.(map[string]interface{})
+		name, _ := m["name"].(string)
+		matched := false
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			unmatched = append(unmatched, name)
+		}
+	}
+	if len(unmatched) > 0 {
+		log.Debugf("Beans not used by the collector: %s", strings.Join(unmatched, ", "))
+	}
+}
+
+// 开启jmx.use-query时需要查询的bean
+func (e *Exporter) jmxQueries() []string {
+	if !*jmxUseQuery {
+		return nil
+	}
+	return e.beanPatterns()
+}
+
+// Collect中用到的bean，新增bean的处理时需要同时加到这里
+func (e *Exporter) beanPatterns() []string {
+	return []string{
+		"Hadoop:service=ResourceManager,name=ClusterMetrics",
+		"Hadoop:service=ResourceManager,name=JvmMetrics",
+		"Hadoop:service=ResourceManager,name=QueueMetrics,*",
+		"Hadoop:service=ResourceManager,name=CapacitySchedulerMetrics",
+		"Hadoop:service=ResourceManager,name=FSOpDurations",
+		"Hadoop:service=ResourceManager,name=*StateStore*",
+		"Hadoop:service=ResourceManager,name=*Reservation*",
+		"Hadoop:service=ResourceManager,name=RpcActivityForPort" + e.c.RpcPort,
+		"java.lang:type=GarbageCollector,name=*",
+		"java.lang:type=Memory",
+		"java.lang:type=OperatingSystem",
+		"java.lang:type=Runtime",
+	}
+}
+
+// 检测配置的协议与实际是否一致，后端不可达时无法判断，下次采集再检测
+func (e *Exporter) collectSchemeMismatch(ctx context.Context, client *http.Client, ch chan<- prometheus.Metric) {
+	e.schemeMutex.Lock()
+	defer e.schemeMutex.Unlock()
+	if !e.schemeChecked {
+		// 不跟随重定向，直接检查Location的协议，跟随后https的证书错误会掩盖重定向
+		probe := *client
+		probe.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		target := e.url + "?qry=" + url.QueryEscape("java.lang:type=Runtime")
+		resp, err := common.Get(ctx, &probe, target)
+		if err == nil {
+			resp.Body.Close()
+		}
+		e.schemeMismatched = common.SchemeMismatch(target, resp, err)
+		e.schemeChecked = err == nil || e.schemeMismatched
+		if e.schemeMismatched {
+			log.Errorf("Scheme of %s does not match the server, check yarn.http.policy", e.url)
+		}
+	}
+	v := 0.0
+	if e.schemeMismatched {
+		v = 1
+	}
+	ch <- prometheus.MustNewConstMetric(e.schemeMismatch, prometheus.GaugeValue, v)
+}
+
+// 采集器方法，超过collect.timeout时取消未完成的请求，只输出已读取到的指标，并将hadoop_exporter_scrape_timeout置为1
+// 请求在超时后立即返回，采集在本次Collect内结束，不会与下一次采集重叠
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	common.CollectWithTimeout(*collectTimeout, e.scrapeTimeout, ch, func(ctx context.Context) {
+		e.collect(ctx, ch)
+	})
+}
+
+// 采集器方法
+func (e *Exporter) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	e.collectConfigValid(ch)
 	// 超时处理
-	t, err := strconv.Atoi(*timeout)
+	t, _ := strconv.Atoi(*timeout)
 	client := http.Client{
-		Timeout: time.Duration(t * int(time.Second)),
+		Transport: transport,
+		Timeout:   time.Duration(t * int(time.Second)),
+	}
+	e.collectSchemeMismatch(ctx, &client, ch)
+	// 同一个REST接口在一次采集中只请求一次，例如调度器类型和队列都来自/ws/v1/cluster/scheduler
+	cache := newScrapeCache(ctx, &client)
+	// JMX和REST接口互不依赖，并发请求，单个接口失败不影响其他接口的指标
+	g := new(errgroup.Group)
+	if *scrapeConcurrency > 0 {
+		g.SetLimit(*scrapeConcurrency)
 	}
-	resp, err := client.Get(e.url)
+	g.Go(func() error {
+		e.collectJMX(cache, ch)
+		return nil
+	})
+	if *collectClusterMetrics {
+		g.Go(func() error {
+			e.collectClusterMetrics(cache, ch)
+			return nil
+		})
+	}
+	if *collectScheduler {
+		g.Go(func() error {
+			e.collectSchedulerQueues(cache, ch)
+			return nil
+		})
+	}
+	if *collectNodeHealth || *collectNodeUtilization {
+		g.Go(func() error {
+			e.collectNodes(cache, ch)
+			return nil
+		})
+	}
+	if *acceptedThreshold > 0 {
+		g.Go(func() error {
+			e.collectAcceptedApps(cache, ch)
+			return nil
+		})
+	}
+	g.Wait()
+}
+
+// 采集JMX指标
+func (e *Exporter) collectJMX(cache *scrapeCache, ch chan<- prometheus.Metric) {
+	nameList, statusCode, err := common.FetchBeans(cache.ctx, cache.client, e.url, e.jmxQueries())
+	backendUp := 1.0
 	if err != nil {
+		backendUp = 0
+	}
+	ch <- prometheus.MustNewConstMetric(e.BackendUp, prometheus.GaugeValue, backendUp, e.url)
+	if statusCode == 0 {
+		// 请求失败，服务不可达
 		log.Error(err)
 		e.ServerActive.Set(0)
 		e.ServerActive.Collect(ch)
 		return
 	}
-	if resp.StatusCode != 200 {
+	if err != nil {
+		// 服务有响应，但启动中或过载时可能返回500的HTML页面或不完整的JSON，此时无法判断主备
+		log.Error(err)
 		e.ServerActive.Set(1)
 		e.ServerActive.Collect(ch)
-		if resp.StatusCode == 307 {
+		e.isActive.Set(-1)
+		if statusCode == 307 {
 			e.isActive.Set(0)
-			e.isActive.Collect(ch)
+		}
+		e.isActive.Collect(ch)
+		if *collectRMInfo {
+			e.collectInfo(cache, "", ch)
 		}
 		return
 	}
-	defer resp.Body.Close()
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Error(err)
-	}
-	var f interface{}
-	err = json.Unmarshal(data, &f)
-	if err != nil {
-		log.Error(err)
-	}
-	m := f.(map[string]interface{})
-	var nameList = m["beans"].([]interface{})
 	e.ServerActive.Set(1) // 如果获取到数据了，就是活动服务
 	e.isActive.Set(1)
+	hostname := ""
+	schedulerType, hasRunningBuckets := e.detectSchedulerType(cache), false
+	e.collectBeanCount(nameList, ch)
 	for _, nameData := range nameList {
 		nameDataMap := nameData.(map[string]interface{})
+		if v, ok := nameDataMap["tag.Hostname"].(string); ok && hostname == "" {
+			hostname = v
+		}
 		if nameDataMap["name"] == "Hadoop:service=ResourceManager,name=ClusterMetrics" {
-			t, _ := net.ResolveIPAddr("ip", nameDataMap["tag.Hostname"].(string))
-			if t.IP.String() != e.c.ServerIP {
+			tagHostname, _ := nameDataMap["tag.Hostname"].(string)
+			if t, err := net.ResolveIPAddr("ip", tagHostname); err != nil || t.IP.String() != e.c.ServerIP {
 				e.isActive.Set(0)
 			}
-			e.NumActiveNMs.Set(nameDataMap["NumActiveNMs"].(float64))
-			e.NumLostNMs.Set(nameDataMap["NumLostNMs"].(float64))
-			e.NumDecommissioningNMs.Set(nameDataMap["NumDecommissioningNMs"].(float64))
-			e.NumDecommissionedNMs.Set(nameDataMap["NumDecommissionedNMs"].(float64))
-			e.NumUnhealthyNMs.Set(nameDataMap["NumUnhealthyNMs"].(float64))
-			e.NumRebootedNMs.Set(nameDataMap["NumRebootedNMs"].(float64))
-			e.NumShutdownNMs.Set(nameDataMap["NumShutdownNMs"].(float64))
-			e.AMLaunchDelayNumOps.Set(nameDataMap["AMLaunchDelayNumOps"].(float64))
-			e.AMLaunchDelayAvgTime.Set(nameDataMap["AMLaunchDelayAvgTime"].(float64))
-			e.AMRegisterDelayNumOps.Set(nameDataMap["AMRegisterDelayNumOps"].(float64))
-			e.AMRegisterDelayAvgTime.Set(nameDataMap["AMRegisterDelayAvgTime"].(float64))
+			for key, gauge := range map[string]prometheus.Gauge{
+				"NumActiveNMs":           e.NumActiveNMs,
+				"NumLostNMs":             e.NumLostNMs,
+				"NumDecommissioningNMs":  e.NumDecommissioningNMs,
+				"NumDecommissionedNMs":   e.NumDecommissionedNMs,
+				"NumUnhealthyNMs":        e.NumUnhealthyNMs,
+				"NumRebootedNMs":         e.NumRebootedNMs,
+				"NumShutdownNMs":         e.NumShutdownNMs,
+				"AMLaunchDelayNumOps":    e.AMLaunchDelayNumOps,
+				"AMLaunchDelayAvgTime":   e.AMLaunchDelayAvgTime,
+				"AMRegisterDelayNumOps":  e.AMRegisterDelayNumOps,
+				"AMRegisterDelayAvgTime": e.AMRegisterDelayAvgTime,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					gauge.Set(v)
+				}
+			}
+			if v, ok := common.GetFloat(nameDataMap, "NumLostNMs"); ok {
+				e.trackNMEvents("lost", v, e.NMLostEvents, e.LastNMLostTimestamp)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "NumRebootedNMs"); ok {
+				e.trackNMEvents("rebooted", v, e.NMRebootedEvents, e.LastNMRebootedTimestamp)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "AMContainerAllocationDelayNumOps"); ok {
+				ch <- prometheus.MustNewConstMetric(e.AMContainerAllocationDelayNumOps, prometheus.GaugeValue, v)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "AMContainerAllocationDelayAvgTime"); ok {
+				ch <- prometheus.MustNewConstMetric(e.AMContainerAllocationDelayAvgTime, prometheus.GaugeValue, v)
+			}
+			for key := range nameDataMap {
+				s := amDelayPercentileRegexp.FindStringSubmatch(key)
+				if s == nil {
+					continue
+				}
+				v, ok := common.GetFloat(nameDataMap, key)
+				if !ok {
+					continue
+				}
+				p, _ := strconv.ParseFloat(s[3], 64)
+				quantile := strconv.FormatFloat(p/100, 'f', -1, 64)
+				desc := e.AMLaunchDelayPercentileTime
+				if s[1] == "AMRegisterDelay" {
+					desc = e.AMRegisterDelayPercentileTime
+				}
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, quantile, s[2])
+			}
 		}
+		if name, _ := nameDataMap["name"].(string); strings.HasPrefix(name, "Hadoop:service=ResourceManager,name=QueueMetrics,") {
+			if queue, ok := queueMetricsName(name); ok {
+				for key, desc := range map[string]*prometheus.Desc{
+					"PendingContainers":   e.QueuePendingContainers,
+					"AllocatedContainers": e.QueueAllocatedContainers,
+					"ReservedContainers":  e.QueueReservedContainers,
+					"AppsRunning":         e.QueueAppsRunning,
+					"AppsPending":         e.QueueAppsPending,
+				} {
+					if v, ok := common.GetFloat(nameDataMap, key); ok {
+						ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, queue)
+					}
+				}
+				if v, ok := common.GetFloat(nameDataMap, "AppsSubmitted"); ok {
+					ch <- prometheus.MustNewConstMetric(e.QueueAppsSubmitted, prometheus.CounterValue, v, queue)
+				}
+				if v, ok := common.GetFloat(nameDataMap, "AppAttemptFirstContainerAllocationDelayNumOps"); ok {
+					ch <- prometheus.MustNewConstMetric(e.QueueFirstContainerAllocationDelayNumOps, prometheus.CounterValue, v, queue)
+				}
+				if v, ok := common.GetFloat(nameDataMap, "AppAttemptFirstContainerAllocationDelayAvgTime"); ok {
+					ch <- prometheus.MustNewConstMetric(e.QueueFirstContainerAllocationDelayAvgTime, prometheus.GaugeValue, v, queue)
+				}
+				if e.c.PreemptionEnabled {
+					for key := range nameDataMap {
+						if s := preemptedRegexp.FindStringSubmatch(key); s != nil {
+							if v, ok := common.GetFloat(nameDataMap, key); ok {
+								ch <- prometheus.MustNewConstMetric(e.QueueAggregatePreempted, prometheus.CounterValue, v, queue, s[1])
+							}
+						}
+					}
+				}
+			}
+		}
+		// 不同调度器的QueueMetrics字段不同，字段不存在时保持原值，不能直接断言为float64
 		if nameDataMap["name"] == "Hadoop:service=ResourceManager,name=QueueMetrics,q0=root,q1=default" {
-			e.AllocatedVCores.Set(nameDataMap["AllocatedVCores"].(float64))
-			e.ReservedVCores.Set(nameDataMap["ReservedVCores"].(float64))
-			e.AvailableVCores.Set(nameDataMap["AvailableVCores"].(float64))
-			e.PendingVCores.Set(nameDataMap["PendingVCores"].(float64))
-			e.AllocatedMB.Set(nameDataMap["AllocatedMB"].(float64))
-			e.AvailableMB.Set(nameDataMap["AvailableMB"].(float64))
-			e.PendingMB.Set(nameDataMap["PendingMB"].(float64))
-			e.ReservedMB.Set(nameDataMap["ReservedMB"].(float64))
-			e.AppsSubmitted.Set(nameDataMap["AppsSubmitted"].(float64))
-			e.AppsRunning.Set(nameDataMap["AppsRunning"].(float64))
-			e.AppsPending.Set(nameDataMap["AppsPending"].(float64))
-			e.AppsCompleted.Set(nameDataMap["AppsCompleted"].(float64))
-			e.AppsKilled.Set(nameDataMap["AppsKilled"].(float64))
-			e.AppsFailed.Set(nameDataMap["AppsFailed"].(float64))
-			e.running_0.Set(nameDataMap["running_0"].(float64))
-			e.running_60.Set(nameDataMap["running_60"].(float64))
-			e.running_300.Set(nameDataMap["running_300"].(float64))
-			e.running_1440.Set(nameDataMap["running_1440"].(float64))
+			for key, gauge := range map[string]prometheus.Gauge{
+				"AllocatedVCores": e.AllocatedVCores,
+				"ReservedVCores":  e.ReservedVCores,
+				"AvailableVCores": e.AvailableVCores,
+				"PendingVCores":   e.PendingVCores,
+				"AllocatedMB":     e.AllocatedMB,
+				"AvailableMB":     e.AvailableMB,
+				"PendingMB":       e.PendingMB,
+				"ReservedMB":      e.ReservedMB,
+				"AppsSubmitted":   e.AppsSubmitted,
+				"AppsRunning":     e.AppsRunning,
+				"AppsPending":     e.AppsPending,
+				"AppsCompleted":   e.AppsCompleted,
+				"AppsKilled":      e.AppsKilled,
+				"AppsFailed":      e.AppsFailed,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					gauge.Set(v)
+				}
+			}
+			for key, gauge := range map[string]prometheus.Gauge{
+				"AllocatedMB": e.AllocatedBytes,
+				"AvailableMB": e.AvailableBytes,
+				"PendingMB":   e.PendingBytes,
+				"ReservedMB":  e.ReservedBytes,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					gauge.Set(v * 1024 * 1024)
+				}
+			}
+			// 按运行时长统计的任务数只有CapacityScheduler有
+			if schedulerType != "fairScheduler" {
+				for key, gauge := range map[string]prometheus.Gauge{
+					"running_0":    e.running_0,
+					"running_60":   e.running_60,
+					"running_300":  e.running_300,
+					"running_1440": e.running_1440,
+				} {
+					if v, ok := common.GetFloat(nameDataMap, key); ok {
+						gauge.Set(v)
+						hasRunningBuckets = true
+					}
+				}
+			}
 		}
 		if nameDataMap["name"] == "Hadoop:service=ResourceManager,name=RpcActivityForPort"+e.c.RpcPort {
-			e.RpcQueueTimeNumOps.Set(nameDataMap["RpcQueueTimeNumOps"].(float64))
-			e.RpcQueueTimeAvgTime.Set(nameDataMap["RpcQueueTimeAvgTime"].(float64))
-			e.RpcProcessingTimeNumOps.Set(nameDataMap["RpcProcessingTimeNumOps"].(float64))
-			e.RpcProcessingTimeAvgTime.Set(nameDataMap["RpcProcessingTimeAvgTime"].(float64))
+			for key, gauge := range map[string]prometheus.Gauge{
+				"RpcQueueTimeNumOps":       e.RpcQueueTimeNumOps,
+				"RpcQueueTimeAvgTime":      e.RpcQueueTimeAvgTime,
+				"RpcProcessingTimeNumOps":  e.RpcProcessingTimeNumOps,
+				"RpcProcessingTimeAvgTime": e.RpcProcessingTimeAvgTime,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					gauge.Set(v)
+				}
+			}
+		}
+		for _, b := range schedulerBeans {
+			if nameDataMap["name"] == b.bean {
+				e.collectSchedulerHealth(b.scheduler, b.numOps, b.avgTime, nameDataMap, ch)
+				// 调度器类型未知时两个bean都输出，一般只会存在当前调度器的bean
+				if *collectSchedulerMetrics && (schedulerType == "" || schedulerType == b.scheduler+"Scheduler") {
+					e.collectSchedulerOps(b.scheduler, nameDataMap, ch)
+				}
+			}
+		}
+		// 3.x的ZKRMStateStore为ZKRMStateStoreOpDurations，其他实现和版本的bean名不同或没有
+		if name, _ := nameDataMap["name"].(string); strings.HasPrefix(name, "Hadoop:service=ResourceManager,name=") && strings.Contains(name, "StateStore") {
+			e.collectStateStore(nameDataMap, ch)
+		}
+		if name, _ := nameDataMap["name"].(string); strings.HasPrefix(name, "Hadoop:service=ResourceManager,name=") && strings.Contains(name, "Reservation") {
+			e.collectReservation(nameDataMap, ch)
+		}
+		// 不同JDK和GC参数下收集器名称不同，因此不写死ParNew/CMS
+		if name, _ := nameDataMap["name"].(string); strings.HasPrefix(name, "java.lang:type=GarbageCollector,name=") {
+			collector := strings.TrimPrefix(name, "java.lang:type=GarbageCollector,name=")
+			if v, ok := common.GetFloat(nameDataMap, "CollectionCount"); ok {
+				ch <- prometheus.MustNewConstMetric(e.gcCollectionCount, prometheus.CounterValue, v, collector)
+			}
+			if v, ok := common.GetFloat(nameDataMap, "CollectionTime"); ok {
+				ch <- prometheus.MustNewConstMetric(e.gcCollectionTime, prometheus.CounterValue, v/1000, collector)
+			}
 		}
 		if nameDataMap["name"] == "java.lang:type=Memory" {
-			heapMemoryUsage := nameDataMap["HeapMemoryUsage"].(map[string]interface{})
-			e.heapMemoryUsageCommitted.Set(heapMemoryUsage["committed"].(float64))
-			e.heapMemoryUsageInit.Set(heapMemoryUsage["init"].(float64))
-			e.heapMemoryUsageMax.Set(heapMemoryUsage["max"].(float64))
-			e.heapMemoryUsageUsed.Set(heapMemoryUsage["used"].(float64))
+			heapMemoryUsage, _ := nameDataMap["HeapMemoryUsage"].(map[string]interface{})
+			for key, gauge := range map[string]prometheus.Gauge{
+				"committed": e.heapMemoryUsageCommitted,
+				"init":      e.heapMemoryUsageInit,
+				"max":       e.heapMemoryUsageMax,
+				"used":      e.heapMemoryUsageUsed,
+			} {
+				if v, ok := common.GetFloat(heapMemoryUsage, key); ok {
+					gauge.Set(v)
+				}
+			}
+			nonHeapMemoryUsage, _ := nameDataMap["NonHeapMemoryUsage"].(map[string]interface{})
+			for key, desc := range map[string]*prometheus.Desc{
+				"committed": e.nonHeapMemoryUsageCommitted,
+				"init":      e.nonHeapMemoryUsageInit,
+				"max":       e.nonHeapMemoryUsageMax,
+				"used":      e.nonHeapMemoryUsageUsed,
+			} {
+				if v, ok := common.GetFloat(nonHeapMemoryUsage, key); ok {
+					ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v)
+				}
+			}
 		}
 		if nameDataMap["name"] == "Hadoop:service=ResourceManager,name=JvmMetrics" {
-			e.LogError.Set(nameDataMap["LogError"].(float64))
-			e.LogFatal.Set(nameDataMap["LogFatal"].(float64))
-			e.LogInfo.Set(nameDataMap["LogInfo"].(float64))
-			e.LogWarn.Set(nameDataMap["LogWarn"].(float64))
+			for key, gauge := range map[string]prometheus.Gauge{
+				"LogError": e.LogError,
+				"LogFatal": e.LogFatal,
+				"LogInfo":  e.LogInfo,
+				"LogWarn":  e.LogWarn,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					gauge.Set(v)
+				}
+			}
+			for key, desc := range map[string]*prometheus.Desc{
+				"GcCount":                    e.JvmGcCount,
+				"GcTimeMillis":               e.JvmGcTimeMillis,
+				"GcNumWarnThresholdExceeded": e.JvmGcNumWarnThresholdExceeded,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v)
+				}
+			}
+			if v, ok := common.GetFloat(nameDataMap, "GcTimePercentage"); ok {
+				ch <- prometheus.MustNewConstMetric(e.JvmGcTimePercentage, prometheus.GaugeValue, v)
+			}
 		}
 		if nameDataMap["name"] == "java.lang:type=Runtime" {
-			e.StartTime.Set(nameDataMap["StartTime"].(float64))
-			e.Uptime.Set(nameDataMap["Uptime"].(float64))
+			if v, ok := common.GetFloat(nameDataMap, "StartTime"); ok {
+				e.StartTime.Set(v)
+				if *timeAsAge && v > 0 {
+					ch <- prometheus.MustNewConstMetric(e.StartTimeAge, prometheus.GaugeValue, ageSeconds(v))
+				}
+			}
+			if v, ok := common.GetFloat(nameDataMap, "Uptime"); ok {
+				e.Uptime.Set(v)
+			}
 		}
 		if nameDataMap["name"] == "java.lang:type=OperatingSystem" {
-			e.SystemLoadAverage.Set(nameDataMap["SystemLoadAverage"].(float64))
-			e.OpenFileDescriptorCount.Set(nameDataMap["OpenFileDescriptorCount"].(float64))
-			e.TotalPhysicalMemorySize.Set(nameDataMap["TotalPhysicalMemorySize"].(float64))
-			e.FreePhysicalMemorySize.Set(nameDataMap["FreePhysicalMemorySize"].(float64))
-			e.MaxFileDescriptorCount.Set(nameDataMap["MaxFileDescriptorCount"].(float64))
-			e.AvailableProcessors.Set(nameDataMap["AvailableProcessors"].(float64))
+			for key, gauge := range map[string]prometheus.Gauge{
+				"SystemLoadAverage":       e.SystemLoadAverage,
+				"OpenFileDescriptorCount": e.OpenFileDescriptorCount,
+				"TotalPhysicalMemorySize": e.TotalPhysicalMemorySize,
+				"FreePhysicalMemorySize":  e.FreePhysicalMemorySize,
+				"MaxFileDescriptorCount":  e.MaxFileDescriptorCount,
+				"AvailableProcessors":     e.AvailableProcessors,
+			} {
+				if v, ok := common.GetFloat(nameDataMap, key); ok {
+					gauge.Set(v)
+				}
+			}
 		}
 	}
+	e.collectInfo(cache, hostname, ch)
 	e.NumActiveNMs.Collect(ch)
 	e.NumLostNMs.Collect(ch)
 	e.NumDecommissionedNMs.Collect(ch)
 	e.NumDecommissioningNMs.Collect(ch)
 	e.NumUnhealthyNMs.Collect(ch)
 	e.NumRebootedNMs.Collect(ch)
+	e.NMLostEvents.Collect(ch)
+	e.LastNMLostTimestamp.Collect(ch)
+	e.NMRebootedEvents.Collect(ch)
+	e.LastNMRebootedTimestamp.Collect(ch)
 	e.NumShutdownNMs.Collect(ch)
 	e.AMLaunchDelayNumOps.Collect(ch)
 	e.AMLaunchDelayAvgTime.Collect(ch)
@@ -583,16 +2207,22 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	e.AvailableMB.Collect(ch)
 	e.PendingMB.Collect(ch)
 	e.ReservedMB.Collect(ch)
+	e.AllocatedBytes.Collect(ch)
+	e.AvailableBytes.Collect(ch)
+	e.PendingBytes.Collect(ch)
+	e.ReservedBytes.Collect(ch)
 	e.AppsSubmitted.Collect(ch)
 	e.AppsRunning.Collect(ch)
 	e.AppsPending.Collect(ch)
 	e.AppsCompleted.Collect(ch)
 	e.AppsKilled.Collect(ch)
 	e.AppsFailed.Collect(ch)
-	e.running_0.Collect(ch)
-	e.running_60.Collect(ch)
-	e.running_300.Collect(ch)
-	e.running_1440.Collect(ch)
+	if hasRunningBuckets {
+		e.running_0.Collect(ch)
+		e.running_60.Collect(ch)
+		e.running_300.Collect(ch)
+		e.running_1440.Collect(ch)
+	}
 	e.RpcQueueTimeNumOps.Collect(ch)
 	e.RpcQueueTimeAvgTime.Collect(ch)
 	e.RpcProcessingTimeNumOps.Collect(ch)
@@ -617,20 +2247,165 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 	e.isActive.Collect(ch)
 }
 
-func main() {
-	flag.Parse()
-	log.Info("Hadoop Exporter make By Lijiadong(Meepod) (๑•̀ㅂ•́)و✧")
-	conf := CreateYARNConf(ReadXml(*clientConfFile))
+// 毫秒时间戳距离本次采集的秒数
+func ageSeconds(epochMs float64) float64 {
+	return float64(time.Now().UnixNano())/1e9 - epochMs/1000
+}
+
+// 最近一次采集时后端是否可达
+func (e *Exporter) up() bool {
+	var m dto.Metric
+	if err := e.ServerActive.Write(&m); err != nil {
+		return false
+	}
+	return m.GetGauge().GetValue() == 1
+}
+
+// 采集一次指标并以文本格式输出到标准输出，与服务模式使用同一个已注册的采集器
+func oneshot(exporter *Exporter) error {
+	mfs, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(os.Stdout, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	if !exporter.up() {
+		return fmt.Errorf("backend %s is unreachable", exporter.url)
+	}
+	return nil
+}
+
+// 配置文件读取成功并注册采集器后为true，用于/ready
+var ready atomic.Bool
+
+// 环境变量中的配置优先于XML配置
+func applyEnvConf(c *YARNConf) {
+	for env, v := range map[string]*string{
+		"HADOOP_EXPORTER_RESOURCEMANAGER_ID": &c.ResourceMangerID,
+		"HADOOP_EXPORTER_RPC_PORT":           &c.RpcPort,
+		"HADOOP_EXPORTER_HTTP_PORT":          &c.HttpPort,
+		"HADOOP_EXPORTER_HTTPS_PORT":         &c.HttpsPort,
+	} {
+		if s, ok := os.LookupEnv(env); ok {
+			*v = s
+		}
+	}
+	if s, ok := os.LookupEnv("HADOOP_EXPORTER_HTTPS"); ok {
+		c.HttpsOpen = s == "true"
+	}
+}
+
+// 根据配置生成采集器
+func createExporter(conf *YARNConf) *Exporter {
 	resourcemanagerJmxUrl := ""
 	if conf.HttpsOpen {
-		resourcemanagerJmxUrl = "https://" + conf.ServerIP + ":" + conf.HttpsPort + "/jmx"
+		resourcemanagerJmxUrl = "https://" + net.JoinHostPort(conf.ServerIP, conf.HttpsPort) + *jmxPath
 	} else {
-		resourcemanagerJmxUrl = "http://" + conf.ServerIP + ":" + conf.HttpPort + "/jmx"
+		resourcemanagerJmxUrl = "http://" + net.JoinHostPort(conf.ServerIP, conf.HttpPort) + *jmxPath
+	}
+	if *jmxURL != "" {
+		resourcemanagerJmxUrl = *jmxURL
+	}
+	e := NewExporter(resourcemanagerJmxUrl, conf, *metricNamespace)
+	e.restURL = strings.TrimSuffix(resourcemanagerJmxUrl, *jmxPath)
+	if *restURL != "" {
+		e.restURL = strings.TrimSuffix(*restURL, "/")
+	}
+	return e
+}
+
+// 收到SIGHUP时重新读取配置，配置变化可能导致标签变化，因此重新注册采集器
+func reloadOnSIGHUP(exporter *Exporter) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		xmlConf, err := common.ReadXmlOrEmpty(*clientConfFile)
+		if err != nil {
+			log.Errorf("Reload config failed: %s", err)
+			continue
+		}
+		newExporter := createExporter(CreateYARNConf(xmlConf))
+		prometheus.Unregister(exporter)
+		if err := prometheus.Register(newExporter); err != nil {
+			log.Errorf("Register reloaded exporter failed: %s", err)
+			prometheus.Register(exporter)
+			continue
+		}
+		exporter = newExporter
+		log.Info("Config reloaded")
+	}
+}
+
+// /metrics的处理器，同时处理的采集请求超过web.max-requests时返回503
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{MaxRequestsInFlight: *maxRequests}))
+}
+
+// 运行exporter，args为子命令之后的参数
+func Main(args []string) {
+	flags.Parse(args)
+	common.FlagsFromEnv(flags)
+	log.Info("Hadoop Exporter make By Lijiadong(Meepod) (๑•̀ㅂ•́)و✧")
+	// 等待配置期间先启动HTTP服务，/ready返回503，配置读取成功后才注册/metrics
+	serveErr := make(chan error, 1)
+	if *configWait > 0 && !*oneshotMode {
+		go func() {
+			serveErr <- serve()
+		}()
+	}
+	xmlConf, err := common.ReadXmlWithWait(*clientConfFile, *configWait)
+	if err != nil {
+		log.Fatal(err)
+	}
+	transport, err = common.NewTransport(*httpProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *relabelConfigFile != "" {
+		gatherer, err = common.RelabelGatherer(prometheus.DefaultGatherer, *relabelConfigFile)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	exporter := NewExporter(resourcemanagerJmxUrl, conf)
+	conf := CreateYARNConf(xmlConf)
+	exporter := createExporter(conf)
 	prometheus.MustRegister(exporter)
-	log.Printf("Starting Server: %s", *listenAddress)
-	http.Handle(*metricsPath, prometheus.Handler())
+	if *oneshotMode {
+		if err := oneshot(exporter); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	go reloadOnSIGHUP(exporter)
+	log.Printf("Starting Server: %s", listenAddress)
+	if *pushGateway != "" {
+		go common.PushMetrics(*pushGateway, *pushInterval, "resourcemanager", conf.ServerIP, gatherer)
+	}
+	http.Handle(*metricsPath, metricsHandler())
+	ready.Store(true)
+	if *configWait > 0 {
+		err = <-serveErr
+	} else {
+		err = serve()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// 启动HTTP服务，/metrics在配置读取成功后单独注册
+func serve() error {
+	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "config is not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("OK"))
+	})
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 		<head><title>Resourcemanager Exporter</title></head>
@@ -641,8 +2416,11 @@ func main() {
 		</body>
 		</html>`))
 	})
-	err := http.ListenAndServe(*listenAddress, nil)
-	if err != nil {
-		log.Fatal(err)
+	server := &http.Server{}
+	flagConfig := &web.FlagConfig{
+		WebListenAddresses: &listenAddress.Values,
+		WebSystemdSocket:   systemdSocket,
+		WebConfigFile:      webConfigFile,
 	}
+	return web.ListenAndServe(server, flagConfig, kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stderr)))
 }
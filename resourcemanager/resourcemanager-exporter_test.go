@@ -0,0 +1,374 @@
+package resourcemanager
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"hadoop_exporter/internal/common"
+	"hadoop_exporter/internal/testutil"
+)
+
+// 按路径返回固定JSON
+func jsonBody(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}
+}
+
+// 按路径分发请求的RM，JMX和REST接口使用同一个地址
+func newRMServer(t *testing.T, routes map[string]http.HandlerFunc) (*httptest.Server, *Exporter) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	e := NewExporter(srv.URL+"/jmx", &YARNConf{ServerIP: "127.0.0.1", ResourceMangerID: "rm1"}, "")
+	e.restURL = srv.URL
+	return srv, e
+}
+
+// FairScheduler的JMX没有running_*，数值可能是字符串，tag.Hostname也可能缺失
+const fairSchedulerJMX = `{"beans":[
+	{"name":"Hadoop:service=ResourceManager,name=ClusterMetrics","NumActiveNMs":"3","NumLostNMs":1,"AMLaunchDelayNumOps":"2"},
+	{"name":"Hadoop:service=ResourceManager,name=QueueMetrics,q0=root,q1=default","AllocatedMB":"2048","AppsRunning":2},
+	{"name":"Hadoop:service=ResourceManager,name=FSOpDurations","UpdateThreadRunNumOps":"10","UpdateThreadRunAvgTime":0.5,"ContinuousSchedulingRunNumOps":4},
+	{"name":"Hadoop:service=ResourceManager,name=RpcActivityForPort8031","RpcQueueTimeNumOps":"5"},
+	{"name":"Hadoop:service=ResourceManager,name=JvmMetrics","LogError":"1"},
+	{"name":"java.lang:type=Memory","HeapMemoryUsage":{"used":"100"}},
+	{"name":"java.lang:type=Runtime","StartTime":"1700000000000"},
+	{"name":"java.lang:type=OperatingSystem","SystemLoadAverage":"0.5"}
+]}`
+
+const fairSchedulerREST = `{"scheduler":{"schedulerInfo":{"type":"fairScheduler","rootQueue":{
+	"queueName":"root","clusterResources":{"memory":4096},"usedResources":{"memory":"2048"},
+	"childQueues":{"queue":[{"queueName":"root.default","type":"fairSchedulerLeafQueueInfo","clusterResources":{"memory":4096},"maxResources":{"memory":2147483647}}]}
+}}}}`
+
+func TestCollectFairScheduler(t *testing.T) {
+	testutil.SetFlag(t, flags, "collect.scheduler", "true")
+	testutil.SetFlag(t, flags, "collect.scheduler-metrics", "true")
+	_, e := newRMServer(t, map[string]http.HandlerFunc{
+		"/jmx":                     jsonBody(fairSchedulerJMX),
+		"/ws/v1/cluster/scheduler": jsonBody(fairSchedulerREST),
+	})
+	e.c.RpcPort = "8031"
+	mfs := testutil.Gather(t, e)
+	for _, c := range []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"ResourceManager_ServerActive", nil, 1},
+		{"ResourceManager_isActive", nil, 0},
+		{"ResourceManager_NumActiveNms", nil, 3},
+		{"ResourceManager_AllocatedMB", nil, 2048},
+		{"ResourceManager_RpcQueueTimeNumOps", nil, 5},
+		{"ResourceManager_StartTime", nil, 1700000000000},
+		{"ResourceManager_SystemLoadAverage", nil, 0.5},
+		{"ResourceManager_SchedulerRunNumOps_total", map[string]string{"scheduler": "fair"}, 10},
+		{"ResourceManager_QueueUsedCapacity", map[string]string{"queue": "root"}, 50},
+		{"ResourceManager_QueueMaxCapacity", map[string]string{"queue": "root.default"}, 100},
+	} {
+		if got, ok := testutil.MetricValue(mfs, c.name, c.labels); !ok || got != c.want {
+			t.Errorf("%s%v = %v (found %v), want %v", c.name, c.labels, got, ok, c.want)
+		}
+	}
+}
+
+func TestCollectBrokenJMX(t *testing.T) {
+	for name, h := range map[string]http.HandlerFunc{
+		"html500": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("<html><body><h2>HTTP ERROR 500</h2></body></html>"))
+		},
+		"truncated": jsonBody(`{"beans":[{"name":"Hadoop:service=ResourceManager,name=ClusterMetrics","NumActiveNMs":3`),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, e := newRMServer(t, map[string]http.HandlerFunc{"/jmx": h})
+			mfs := testutil.Gather(t, e)
+			for metric, want := range map[string]float64{
+				"ResourceManager_ServerActive": 1,
+				"ResourceManager_isActive":     -1,
+			} {
+				if got, ok := testutil.MetricValue(mfs, metric, nil); !ok || got != want {
+					t.Errorf("%s = %v (found %v), want %v", metric, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerRequestedOncePerScrape(t *testing.T) {
+	testutil.SetFlag(t, flags, "collect.scheduler", "true")
+	testutil.SetFlag(t, flags, "collect.scheduler-metrics", "true")
+	var hits atomic.Int32
+	_, e := newRMServer(t, map[string]http.HandlerFunc{
+		"/jmx": jsonBody(fairSchedulerJMX),
+		"/ws/v1/cluster/scheduler": func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			jsonBody(fairSchedulerREST)(w, r)
+		},
+	})
+	for i := 1; i <= 2; i++ {
+		hits.Store(0)
+		testutil.Gather(t, e)
+		if n := hits.Load(); n != 1 {
+			t.Errorf("scrape %d requested /ws/v1/cluster/scheduler %d times, want 1", i, n)
+		}
+	}
+}
+
+// 一个接口超时、一个接口返回500时，其他接口的指标照常输出，同时请求数不超过scrape.concurrency
+func TestCollectConcurrency(t *testing.T) {
+	testutil.SetFlag(t, flags, "scrape.concurrency", "2")
+	testutil.SetFlag(t, flags, "get.timeout-seconds", "1")
+	testutil.SetFlag(t, flags, "collect.cluster-metrics", "true")
+	testutil.SetFlag(t, flags, "collect.scheduler", "true")
+	testutil.SetFlag(t, flags, "collect.node-health", "true")
+	testutil.SetFlag(t, flags, "apps.accepted-threshold-ms", "1000")
+	var inflight, maxInflight atomic.Int32
+	track := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			n := inflight.Add(1)
+			defer inflight.Add(-1)
+			for m := maxInflight.Load(); n > m && !maxInflight.CompareAndSwap(m, n); m = maxInflight.Load() {
+			}
+			time.Sleep(50 * time.Millisecond)
+			h(w, r)
+		}
+	}
+	_, e := newRMServer(t, map[string]http.HandlerFunc{
+		"/jmx":                     track(jsonBody(fairSchedulerJMX)),
+		"/ws/v1/cluster/scheduler": track(jsonBody(fairSchedulerREST)),
+		"/ws/v1/cluster/apps":      track(jsonBody(`{"apps":{"app":[{"elapsedTime":5000},{"elapsedTime":10}]}}`)),
+		"/ws/v1/cluster/metrics": track(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}),
+		// 超过get.timeout-seconds，客户端断开后结束
+		"/ws/v1/cluster/nodes": track(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}),
+	})
+	mfs := testutil.Gather(t, e)
+	for _, c := range []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"ResourceManager_NumActiveNms", nil, 3},
+		{"ResourceManager_QueueIsLeaf", map[string]string{"queue": "root.default"}, 1},
+		{"ResourceManager_AppsAcceptedLongRunning", nil, 1},
+	} {
+		if got, ok := testutil.MetricValue(mfs, c.name, c.labels); !ok || got != c.want {
+			t.Errorf("%s%v = %v (found %v), want %v", c.name, c.labels, got, ok, c.want)
+		}
+	}
+	if n := maxInflight.Load(); n > 2 {
+		t.Errorf("%d requests in flight, want at most 2", n)
+	}
+}
+
+// CapacityScheduler下包含Collect中处理的所有bean
+const capacitySchedulerJMX = `{"beans":[
+	{"name":"Hadoop:service=ResourceManager,name=ClusterMetrics","tag.Hostname":"localhost","NumActiveNMs":3,"NumLostNMs":0,"NumDecommissioningNMs":0,"NumDecommissionedNMs":0,
+		"NumUnhealthyNMs":1,"NumRebootedNMs":0,"NumShutdownNMs":0,"AMLaunchDelayNumOps":1,"AMLaunchDelayAvgTime":2,"AMRegisterDelayNumOps":1,"AMRegisterDelayAvgTime":3,
+		"AMContainerAllocationDelayNumOps":1,"AMContainerAllocationDelayAvgTime":4,"AMLaunchDelay60s99thPercentileLatency":5},
+	{"name":"Hadoop:service=ResourceManager,name=QueueMetrics,q0=root","AppsRunning":2,"AppsPending":0},
+	{"name":"Hadoop:service=ResourceManager,name=QueueMetrics,q0=root,q1=default","AllocatedVCores":2,"ReservedVCores":0,"AvailableVCores":6,"PendingVCores":0,
+		"AllocatedMB":2048,"AvailableMB":6144,"PendingMB":0,"ReservedMB":0,"AppsSubmitted":10,"AppsRunning":2,"AppsPending":0,"AppsCompleted":7,"AppsKilled":1,"AppsFailed":0,
+		"PendingContainers":0,"AllocatedContainers":2,"ReservedContainers":0,"AppAttemptFirstContainerAllocationDelayNumOps":10,"AppAttemptFirstContainerAllocationDelayAvgTime":5,
+		"AggregateContainersPreempted":1,"AggregateMemoryMBPreempted":1024,
+		"running_0":1,"running_60":1,"running_300":0,"running_1440":0},
+	{"name":"Hadoop:service=ResourceManager,name=CapacitySchedulerMetrics","AllocateNumOps":50,"AllocateAvgTime":1.5,"CommitSuccessNumOps":40,"CommitSuccessAvgTime":0.2},
+	{"name":"Hadoop:service=ResourceManager,name=RpcActivityForPort8031","RpcQueueTimeNumOps":10,"RpcQueueTimeAvgTime":0.1,"RpcProcessingTimeNumOps":10,"RpcProcessingTimeAvgTime":0.2},
+	{"name":"Hadoop:service=ResourceManager,name=ZKRMStateStoreOpDurations","LoadStateCallNumOps":1,"LoadStateCallAvgTime":20,"StoreApplicationStateCallNumOps":10,"StoreApplicationStateCallAvgTime":2},
+	{"name":"Hadoop:service=ResourceManager,name=ReservationSystemMetrics","tag.Context":"yarn","ReservationsNumOps":1,"ReservationsAvgTime":2},
+	{"name":"java.lang:type=GarbageCollector,name=G1 Young Generation","CollectionCount":5,"CollectionTime":50},
+	{"name":"java.lang:type=Memory","HeapMemoryUsage":{"committed":100,"init":50,"max":200,"used":80},"NonHeapMemoryUsage":{"committed":10,"init":5,"max":-1,"used":8}},
+	{"name":"Hadoop:service=ResourceManager,name=JvmMetrics","LogError":0,"LogFatal":0,"LogInfo":10,"LogWarn":1,"GcCount":6,"GcTimeMillis":150,"GcNumWarnThresholdExceeded":0,"GcTimePercentage":1},
+	{"name":"java.lang:type=Runtime","StartTime":1700000000000,"Uptime":1000},
+	{"name":"java.lang:type=OperatingSystem","SystemLoadAverage":0.5,"OpenFileDescriptorCount":100,"TotalPhysicalMemorySize":1000,"FreePhysicalMemorySize":500,"MaxFileDescriptorCount":65536,"AvailableProcessors":4}
+]}`
+
+const capacitySchedulerREST = `{"scheduler":{"schedulerInfo":{"type":"capacityScheduler","capacity":100,"maxCapacity":100,"usedCapacity":25,"queueName":"root",
+	"queues":{"queue":[{"type":"capacitySchedulerLeafQueueInfo","queueName":"default","absoluteCapacity":100,"absoluteMaxCapacity":100,"absoluteUsedCapacity":25,
+		"AMResourceLimit":{"memory":1024,"vCores":1},"usedAMResource":{"memory":512},
+		"users":{"user":[{"username":"hive","resourcesUsed":{"memory":2048}}]}}]}}}}`
+
+// 开启所有可选指标后，采集到的指标都要在Describe中声明
+func TestPedanticRegistry(t *testing.T) {
+	for _, name := range []string{"collect.cluster-metrics", "collect.scheduler", "collect.queue-users", "collect.node-health",
+		"collect.rm-info", "collect.node-utilization", "collect.scheduler-metrics", "time.as-age"} {
+		testutil.SetFlag(t, flags, name, "true")
+	}
+	testutil.SetFlag(t, flags, "apps.accepted-threshold-ms", "1000")
+	_, e := newRMServer(t, map[string]http.HandlerFunc{
+		"/jmx":                     jsonBody(capacitySchedulerJMX),
+		"/ws/v1/cluster/scheduler": jsonBody(capacitySchedulerREST),
+		"/ws/v1/cluster/metrics":   jsonBody(`{"clusterMetrics":{"totalMB":8192,"totalVirtualCores":8,"totalNodes":3,"lostNodes":0,"unhealthyNodes":1,"appsPending":0}}`),
+		"/ws/v1/cluster/info":      jsonBody(`{"clusterInfo":{"resourceManagerVersion":"3.1.1","hadoopVersion":"3.1.1","haState":"ACTIVE","startedOn":1700000000000}}`),
+		"/ws/v1/cluster/apps":      jsonBody(`{"apps":{"app":[{"elapsedTime":5000}]}}`),
+		"/ws/v1/cluster/nodes": jsonBody(`{"nodes":{"node":[{"id":"nm1:8041","state":"UNHEALTHY","healthReport":"1/1 local-dirs are bad","numContainers":0},
+			{"id":"nm2:8041","state":"DECOMMISSIONING","numContainers":3},
+			{"id":"nm3:8041","state":"RUNNING","numContainers":5,"usedMemoryMB":8192,"usedVirtualCores":4,
+				"resourceUtilization":{"nodePhysicalMemoryMB":3000,"nodeCPUUsage":0.25,"aggregatedContainersPhysicalMemoryMB":2500,"containersCPUUsage":0.2}}]}}`),
+	})
+	e.c.RpcPort = "8031"
+	e.c.PreemptionEnabled = true
+	mfs := testutil.Gather(t, e)
+	for _, c := range []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"ResourceManager_isActive", nil, 1},
+		{"ResourceManager_NumActiveNms", nil, 3},
+		{"ResourceManager_QueueUserUsedMemoryMB", map[string]string{"queue": "root.default", "user": "hive"}, 2048},
+	} {
+		if got, ok := testutil.MetricValue(mfs, c.name, c.labels); !ok || got != c.want {
+			t.Errorf("%s%v = %v (found %v), want %v", c.name, c.labels, got, ok, c.want)
+		}
+	}
+}
+
+// 设置--metric.namespace后所有指标都带前缀，包括hadoop_exporter_*等自身的指标
+func TestMetricNamespace(t *testing.T) {
+	testutil.SetFlag(t, flags, "collect.rm-info", "true")
+	srv, _ := newRMServer(t, map[string]http.HandlerFunc{
+		"/jmx":                jsonBody(capacitySchedulerJMX),
+		"/ws/v1/cluster/info": jsonBody(`{"clusterInfo":{"resourceManagerVersion":"3.1.1","hadoopVersion":"3.1.1","haState":"ACTIVE","startedOn":1700000000000}}`),
+	})
+	e := NewExporter(srv.URL+"/jmx", &YARNConf{ServerIP: "127.0.0.1", ResourceMangerID: "rm1"}, "test")
+	e.restURL = srv.URL
+	testutil.CheckNamespace(t, testutil.Gather(t, e), "test")
+}
+
+// 同时处理的请求超过web.max-requests时直接返回503
+func TestMaxRequests(t *testing.T) {
+	testutil.SetFlag(t, flags, "web.max-requests", "1")
+	testutil.CheckMaxRequests(t, func(g prometheus.Gatherer) http.Handler {
+		old := gatherer
+		gatherer = g
+		t.Cleanup(func() { gatherer = old })
+		return metricsHandler()
+	})
+}
+
+// 解析yarn-site.xml格式的配置
+func parseConf(t *testing.T, props string) *common.XMLConf {
+	t.Helper()
+	var x common.XMLConf
+	if err := xml.Unmarshal([]byte("<configuration>"+props+"</configuration>"), &x); err != nil {
+		t.Fatal(err)
+	}
+	return &x
+}
+
+func TestCreateYARNConf(t *testing.T) {
+	testutil.SetFlag(t, flags, "local.ip", "127.0.0.1")
+	h, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prop := func(name, value string) string {
+		return "<property><name>" + name + "</name><value>" + value + "</value></property>"
+	}
+	for _, c := range []struct {
+		name  string
+		props string
+		want  YARNConf
+	}{
+		{
+			name:  "non-HA with default ports",
+			props: prop("yarn.resourcemanager.hostname", h),
+			want:  YARNConf{RpcPort: "8031", HttpPort: "8088"},
+		},
+		{
+			name: "whitespace",
+			props: prop(" yarn.resourcemanager.resource-tracker.address\n", " "+h+":8025 ") +
+				prop("\n\tyarn.resourcemanager.webapp.address ", "\n\t"+h+":8089\n"),
+			want: YARNConf{RpcPort: "8025", HttpPort: "8089"},
+		},
+		{
+			// rm10的配置项名和主机名都以rm1的为前缀，并且排在前面
+			name: "three ResourceManagers",
+			props: prop("yarn.resourcemanager.ha.rm-ids", "rm10, rm1,rm2") +
+				prop("yarn.resourcemanager.resource-tracker.address.rm10", h+"0:8030") + prop("yarn.resourcemanager.webapp.address.rm10", h+"0:8080") +
+				prop("yarn.resourcemanager.resource-tracker.address.rm1", h+":8021") + prop("yarn.resourcemanager.webapp.address.rm1", h+":8081") +
+				prop("yarn.resourcemanager.resource-tracker.address.rm2", "other:8022") + prop("yarn.resourcemanager.webapp.address.rm2", "other:8082"),
+			want: YARNConf{ResourceMangerID: "rm1", RpcPort: "8021", HttpPort: "8081"},
+		},
+		{
+			name: "HA with hostnames only",
+			props: prop("yarn.resourcemanager.ha.rm-ids", "rm1,rm2") +
+				prop("yarn.resourcemanager.hostname.rm1", "other") + prop("yarn.resourcemanager.hostname.rm2", h),
+			want: YARNConf{ResourceMangerID: "rm2", RpcPort: "8031", HttpPort: "8088"},
+		},
+		{
+			name:  "https",
+			props: prop("yarn.http.policy", "HTTPS_ONLY"),
+			want:  YARNConf{RpcPort: "8031", HttpsOpen: true, HttpsPort: "8090"},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			c.want.ServerIP = "127.0.0.1"
+			if got := CreateYARNConf(parseConf(t, c.props)); *got != c.want {
+				t.Errorf("CreateYARNConf() = %+v, want %+v", *got, c.want)
+			}
+		})
+	}
+}
+
+// yarn.scheduler.fair.preemption.cluster-utilization-threshold不代表开启了抢占
+func TestPreemptionEnabled(t *testing.T) {
+	testutil.SetFlag(t, flags, "local.ip", "127.0.0.1")
+	for props, want := range map[string]bool{
+		"": false,
+		"<property><name>yarn.scheduler.fair.preemption.cluster-utilization-threshold</name><value>true</value></property>": false,
+		"<property><name>yarn.scheduler.fair.preemption</name><value>false</value></property>":                              false,
+		"<property><name>yarn.scheduler.fair.preemption</name><value>true</value></property>":                               true,
+		"<property><name>yarn.resourcemanager.scheduler.monitor.enable</name><value>TRUE</value></property>":                true,
+	} {
+		if got := CreateYARNConf(parseConf(t, props)).PreemptionEnabled; got != want {
+			t.Errorf("PreemptionEnabled = %v, want %v for %s", got, want, props)
+		}
+	}
+}
+
+// IPv6地址需要加上方括号，REST接口与JMX使用同一个地址
+func TestCreateExporterIPv6(t *testing.T) {
+	e := createExporter(&YARNConf{ServerIP: "fe80::1", HttpPort: "8088"})
+	if e.url != "http://[fe80::1]:8088/jmx" || e.restURL != "http://[fe80::1]:8088" {
+		t.Errorf("url = %q, restURL = %q", e.url, e.restURL)
+	}
+	e = createExporter(&YARNConf{ServerIP: "fe80::1", HttpsOpen: true, HttpsPort: "8090"})
+	if e.url != "https://[fe80::1]:8090/jmx" || e.restURL != "https://[fe80::1]:8090" {
+		t.Errorf("url = %q, restURL = %q", e.url, e.restURL)
+	}
+}
+
+// 超过collect.timeout时取消未完成的请求，采集在超时后返回，不会等到get.timeout-seconds
+func TestCollectTimeoutCancelsRequests(t *testing.T) {
+	testutil.SetFlag(t, flags, "collect.timeout", "300ms")
+	testutil.SetFlag(t, flags, "get.timeout-seconds", "30")
+	testutil.CheckCollectTimeout(t, func(url string) prometheus.Collector {
+		return NewExporter(url+"/jmx", &YARNConf{ServerIP: "127.0.0.1", ResourceMangerID: "rm1"}, "")
+	})
+}